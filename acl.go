@@ -0,0 +1,148 @@
+package turn
+
+import (
+	"net"
+	"strings"
+)
+
+// ParseNetworks parses list of CIDR networks, like "10.0.0.0/8" or
+// "fc00::/7". Single IP addresses are parsed as host networks.
+func ParseNetworks(cidrs ...string) ([]*net.IPNet, error) {
+	networks := make([]*net.IPNet, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		if !strings.Contains(cidr, "/") {
+			ip := net.ParseIP(cidr)
+			if ip == nil {
+				return nil, &net.ParseError{Type: "IP address", Text: cidr}
+			}
+			bits := 8 * net.IPv6len
+			if ip4 := ip.To4(); ip4 != nil {
+				ip, bits = ip4, 8*net.IPv4len
+			}
+			networks = append(networks, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, err
+		}
+		networks = append(networks, network)
+	}
+	return networks, nil
+}
+
+func mustParseNetworks(cidrs ...string) []*net.IPNet {
+	networks, err := ParseNetworks(cidrs...)
+	if err != nil {
+		panic(err)
+	}
+	return networks
+}
+
+// DefaultDeniedNetworks are loopback, private, link-local, multicast
+// and other special-purpose networks from IANA registries that server
+// should not relay data to by default.
+//
+// RFC 6890, RFC 8656 Section 21.3.2
+var DefaultDeniedNetworks = mustParseNetworks(
+	// IPv4, also matches IPv4-mapped IPv6 addresses.
+	"0.0.0.0/8",       // "this" network
+	"10.0.0.0/8",      // private
+	"100.64.0.0/10",   // shared address space (CGN)
+	"127.0.0.0/8",     // loopback
+	"169.254.0.0/16",  // link-local, including cloud metadata
+	"172.16.0.0/12",   // private
+	"192.0.0.0/24",    // IETF protocol assignments
+	"192.0.2.0/24",    // documentation (TEST-NET-1)
+	"192.88.99.0/24",  // 6to4 relay anycast
+	"192.168.0.0/16",  // private
+	"198.18.0.0/15",   // benchmarking
+	"198.51.100.0/24", // documentation (TEST-NET-2)
+	"203.0.113.0/24",  // documentation (TEST-NET-3)
+	"224.0.0.0/4",     // multicast
+	"240.0.0.0/4",     // reserved and limited broadcast
+
+	// IPv6.
+	"::/96",          // unspecified, loopback and IPv4-compatible
+	"64:ff9b:1::/48", // local-use IPv4/IPv6 translation
+	"100::/64",       // discard-only
+	"2001:2::/48",    // benchmarking
+	"2001:10::/28",   // ORCHID
+	"2001:db8::/32",  // documentation
+	"fc00::/7",       // unique local
+	"fe80::/10",      // link-local
+	"fec0::/10",      // site-local (deprecated)
+	"ff00::/8",       // multicast
+)
+
+// Prefixes of IPv6 addresses that embed IPv4 address.
+var (
+	// prefix6to4 is 6to4 prefix, IPv4 address follows prefix.
+	//
+	// RFC 3056 Section 2
+	prefix6to4 = mustParseNetworks("2002::/16")[0]
+	// prefixNAT64 is well-known prefix for IPv4/IPv6 translation, IPv4
+	// address is in last 4 bytes.
+	//
+	// RFC 6052 Section 2.1
+	prefixNAT64 = mustParseNetworks("64:ff9b::/96")[0]
+)
+
+// embeddedIPv4 returns IPv4 address that is embedded in IPv6 address
+// or nil. IPv4-mapped addresses are not handled here, because they are
+// matched by IPv4 networks.
+func embeddedIPv4(ip net.IP) net.IP {
+	if ip.To4() != nil || len(ip) != net.IPv6len {
+		return nil
+	}
+	switch {
+	case prefix6to4.Contains(ip):
+		return net.IPv4(ip[2], ip[3], ip[4], ip[5])
+	case prefixNAT64.Contains(ip):
+		return net.IPv4(ip[12], ip[13], ip[14], ip[15])
+	default:
+		return nil
+	}
+}
+
+// PeerACL controls peer addresses that server relays data to.
+//
+// Address is allowed if it belongs to Allow networks or does not belong
+// to Deny networks. IPv4 address that is embedded in 6to4 or NAT64
+// address is checked too, so both must be allowed. IPv4-mapped IPv6
+// addresses (::ffff:0:0/96) are checked as IPv4 addresses.
+//
+// RFC 8656 Section 21.3.2
+type PeerACL struct {
+	Allow []*net.IPNet
+	Deny  []*net.IPNet
+}
+
+// DefaultPeerACL returns PeerACL that denies DefaultDeniedNetworks.
+func DefaultPeerACL() *PeerACL {
+	return &PeerACL{Deny: DefaultDeniedNetworks}
+}
+
+func contains(networks []*net.IPNet, ip net.IP) bool {
+	for _, n := range networks {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func (a *PeerACL) allowed(ip net.IP) bool {
+	return contains(a.Allow, ip) || !contains(a.Deny, ip)
+}
+
+// Allowed returns true if server can relay data to peer IP.
+func (a *PeerACL) Allowed(ip net.IP) bool {
+	if ip == nil || !a.allowed(ip) {
+		return false
+	}
+	if embedded := embeddedIPv4(ip); embedded != nil {
+		return a.allowed(embedded)
+	}
+	return true
+}
@@ -0,0 +1,107 @@
+package turn
+
+import (
+	"net"
+	"testing"
+)
+
+func TestParseNetworks(t *testing.T) {
+	networks, err := ParseNetworks("10.0.0.0/8", "192.168.1.1", "2001:db8::1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, expected := range []string{"10.0.0.0/8", "192.168.1.1/32", "2001:db8::1/128"} {
+		if networks[i].String() != expected {
+			t.Errorf("%s (got) != %s (expected)", networks[i], expected)
+		}
+	}
+	for _, invalid := range []string{"10.0.0.0/33", "bad", "bad/8"} {
+		if _, err = ParseNetworks(invalid); err == nil {
+			t.Errorf("should error on %q", invalid)
+		}
+	}
+}
+
+func TestPeerACL_Allowed(t *testing.T) {
+	acl := DefaultPeerACL()
+	for _, tc := range []struct {
+		ip      string
+		allowed bool
+	}{
+		{"8.8.8.8", true},
+		{"2001:4860:4860::8888", true},
+		{"0.0.0.0", false},
+		{"127.0.0.1", false},
+		{"10.1.2.3", false},
+		{"172.16.0.1", false},
+		{"172.32.0.1", true},
+		{"192.168.0.1", false},
+		{"100.64.0.1", false},
+		{"169.254.169.254", false},
+		{"224.0.0.1", false},
+		{"255.255.255.255", false},
+		{"198.51.100.1", false},
+		{"::", false},
+		{"::1", false},
+		{"::127.0.0.1", false},
+		{"fe80::1", false},
+		{"fd00::1", false},
+		{"ff02::1", false},
+		{"2001:db8::1", false},
+		// IPv4-mapped.
+		{"::ffff:127.0.0.1", false},
+		{"::ffff:169.254.169.254", false},
+		{"::ffff:8.8.8.8", true},
+		// 6to4.
+		{"2002:7f00:1::1", false},
+		{"2002:a9fe:a9fe::", false},
+		{"2002:808:808::1", true},
+		// NAT64.
+		{"64:ff9b::7f00:1", false},
+		{"64:ff9b::a00:1", false},
+		{"64:ff9b::808:808", true},
+		{"64:ff9b:1::808:808", false},
+	} {
+		if acl.Allowed(net.ParseIP(tc.ip)) != tc.allowed {
+			t.Errorf("%s: Allowed() != %v", tc.ip, tc.allowed)
+		}
+	}
+	if acl.Allowed(nil) {
+		t.Error("nil should not be allowed")
+	}
+	t.Run("Allow", func(t *testing.T) {
+		acl := &PeerACL{
+			Allow: mustParseNetworks("10.0.0.0/24"),
+			Deny:  DefaultDeniedNetworks,
+		}
+		for _, tc := range []struct {
+			ip      string
+			allowed bool
+		}{
+			{"10.0.0.1", true},
+			{"::ffff:10.0.0.1", true},
+			{"64:ff9b::a00:1", true},
+			{"10.0.1.1", false},
+		} {
+			if acl.Allowed(net.ParseIP(tc.ip)) != tc.allowed {
+				t.Errorf("%s: Allowed() != %v", tc.ip, tc.allowed)
+			}
+		}
+	})
+	t.Run("Empty", func(t *testing.T) {
+		if !new(PeerACL).Allowed(net.IPv4(127, 0, 0, 1)) {
+			t.Error("empty ACL should allow all")
+		}
+	})
+}
+
+func BenchmarkPeerACL_Allowed(b *testing.B) {
+	acl := DefaultPeerACL()
+	ip := net.ParseIP("2002:808:808::1")
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if !acl.Allowed(ip) {
+			b.Fatal("not allowed")
+		}
+	}
+}
@@ -0,0 +1,48 @@
+package turn
+
+import (
+	"errors"
+
+	"gortc.io/stun"
+)
+
+// AdditionalAddressFamily represents the ADDITIONAL-ADDRESS-FAMILY attribute
+// as defined in RFC 8656 Section 18.11.
+//
+// It is used by client to request IPv6 relayed address in addition to the
+// IPv4 one, so RequestedFamilyIPv6 is the only allowed value. The IPv4 value
+// is still decoded, so server can reject request with 400 (Bad Request).
+type AdditionalAddressFamily RequestedAddressFamily
+
+const additionalFamilySize = 4
+
+// GetFrom decodes ADDITIONAL-ADDRESS-FAMILY from message.
+func (f *AdditionalAddressFamily) GetFrom(m *stun.Message) error {
+	v, err := m.Get(AttrAdditionalAddressFamily)
+	if err != nil {
+		return err
+	}
+	if err = stun.CheckSize(AttrAdditionalAddressFamily, len(v), additionalFamilySize); err != nil {
+		return err
+	}
+	switch v[0] {
+	case byte(RequestedFamilyIPv4), byte(RequestedFamilyIPv6):
+		*f = AdditionalAddressFamily(v[0])
+	default:
+		return errors.New("invalid value for additional family attribute")
+	}
+	return nil
+}
+
+func (f AdditionalAddressFamily) String() string {
+	return RequestedAddressFamily(f).String()
+}
+
+// AddTo adds ADDITIONAL-ADDRESS-FAMILY to message.
+func (f AdditionalAddressFamily) AddTo(m *stun.Message) error {
+	v := make([]byte, additionalFamilySize)
+	v[0] = byte(f)
+	// b[1:4] is RFFU = 0.
+	m.Add(AttrAdditionalAddressFamily, v)
+	return nil
+}
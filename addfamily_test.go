@@ -0,0 +1,67 @@
+package turn
+
+import (
+	"testing"
+
+	"gortc.io/stun"
+)
+
+func TestAdditionalAddressFamily(t *testing.T) {
+	t.Run("String", func(t *testing.T) {
+		if AdditionalAddressFamily(RequestedFamilyIPv6).String() != "IPv6" {
+			t.Error("bad string")
+		}
+	})
+	t.Run("NoAlloc", func(t *testing.T) {
+		m := &stun.Message{}
+		if wasAllocs(func() {
+			f := AdditionalAddressFamily(RequestedFamilyIPv6)
+			f.AddTo(m)
+			m.Reset()
+		}) {
+			t.Error("Unexpected allocations")
+		}
+	})
+	t.Run("AddTo", func(t *testing.T) {
+		m := new(stun.Message)
+		f := AdditionalAddressFamily(RequestedFamilyIPv6)
+		if err := f.AddTo(m); err != nil {
+			t.Error(err)
+		}
+		m.WriteHeader()
+		t.Run("GetFrom", func(t *testing.T) {
+			decoded := new(stun.Message)
+			if _, err := decoded.Write(m.Raw); err != nil {
+				t.Fatal("failed to decode message:", err)
+			}
+			var got AdditionalAddressFamily
+			if err := got.GetFrom(decoded); err != nil {
+				t.Fatal(err)
+			}
+			if got != f {
+				t.Errorf("Decoded %q, expected %q", got, f)
+			}
+			if wasAllocs(func() {
+				got.GetFrom(decoded)
+			}) {
+				t.Error("Unexpected allocations")
+			}
+			t.Run("HandleErr", func(t *testing.T) {
+				m := new(stun.Message)
+				var handle AdditionalAddressFamily
+				if err := handle.GetFrom(m); err != stun.ErrAttributeNotFound {
+					t.Errorf("%v should be not found", err)
+				}
+				m.Add(AttrAdditionalAddressFamily, []byte{1, 2, 3})
+				if !stun.IsAttrSizeInvalid(handle.GetFrom(m)) {
+					t.Error("IsAttrSizeInvalid should be true")
+				}
+				m.Reset()
+				m.Add(AttrAdditionalAddressFamily, []byte{5, 0, 0, 0})
+				if handle.GetFrom(m) == nil {
+					t.Error("should error on invalid value")
+				}
+			})
+		})
+	})
+}
@@ -63,3 +63,31 @@ func (t FiveTuple) Equal(b FiveTuple) bool {
 	}
 	return true
 }
+
+// addrKey is comparable representation of Addr that can be used as
+// map key without allocations.
+type addrKey struct {
+	ip   [net.IPv6len]byte
+	port int
+}
+
+func newAddrKey(ip net.IP, port int) addrKey {
+	k := addrKey{port: port}
+	copy(k.ip[:], ip.To16())
+	return k
+}
+
+// tupleKey is comparable representation of FiveTuple.
+type tupleKey struct {
+	client addrKey
+	server addrKey
+	proto  Protocol
+}
+
+func (t FiveTuple) key() tupleKey {
+	return tupleKey{
+		client: newAddrKey(t.Client.IP, t.Client.Port),
+		server: newAddrKey(t.Server.IP, t.Server.Port),
+		proto:  t.Proto,
+	}
+}
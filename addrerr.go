@@ -0,0 +1,76 @@
+package turn
+
+import (
+	"errors"
+	"fmt"
+
+	"gortc.io/stun"
+)
+
+// AddressErrorCode represents the ADDRESS-ERROR-CODE attribute as defined
+// in RFC 8656 Section 18.12.
+//
+// It is used by server in Allocate success response to indicate that
+// relayed address of specific family was not allocated, while another
+// one was.
+type AddressErrorCode struct {
+	Family RequestedAddressFamily
+	Code   stun.ErrorCode
+	Reason []byte
+}
+
+func (c AddressErrorCode) String() string {
+	return fmt.Sprintf("%s %d: %s", c.Family, c.Code, c.Reason)
+}
+
+// constants for ADDRESS-ERROR-CODE encoding.
+const (
+	addressErrorReasonStart = 4
+	addressErrorFamilyByte  = 0
+	addressErrorClassByte   = 2
+	addressErrorNumberByte  = 3
+	addressErrorReasonMaxB  = 763
+	addressErrorModulo      = 100
+	addressErrorClassMask   = 0x07
+)
+
+// AddTo adds ADDRESS-ERROR-CODE to message.
+func (c AddressErrorCode) AddTo(m *stun.Message) error {
+	if err := stun.CheckOverflow(AttrAddressErrorCode,
+		len(c.Reason), addressErrorReasonMaxB,
+	); err != nil {
+		return err
+	}
+	v := make([]byte, addressErrorReasonStart+len(c.Reason))
+	v[addressErrorFamilyByte] = byte(c.Family)
+	// v[1] and 5 bits of v[2] are reserved and set to zero.
+	v[addressErrorClassByte] = byte(c.Code / addressErrorModulo)
+	v[addressErrorNumberByte] = byte(c.Code % addressErrorModulo)
+	copy(v[addressErrorReasonStart:], c.Reason)
+	m.Add(AttrAddressErrorCode, v)
+	return nil
+}
+
+// GetFrom decodes ADDRESS-ERROR-CODE from message.
+func (c *AddressErrorCode) GetFrom(m *stun.Message) error {
+	v, err := m.Get(AttrAddressErrorCode)
+	if err != nil {
+		return err
+	}
+	if len(v) < addressErrorReasonStart {
+		return stun.ErrAttributeSizeInvalid
+	}
+	switch v[addressErrorFamilyByte] {
+	case byte(RequestedFamilyIPv4), byte(RequestedFamilyIPv6):
+		c.Family = RequestedAddressFamily(v[addressErrorFamilyByte])
+	default:
+		return errors.New("invalid value for address error family")
+	}
+	var (
+		class  = int(v[addressErrorClassByte] & addressErrorClassMask)
+		number = int(v[addressErrorNumberByte])
+	)
+	c.Code = stun.ErrorCode(class*addressErrorModulo + number)
+	c.Reason = append(c.Reason[:0], v[addressErrorReasonStart:]...)
+	return nil
+}
@@ -0,0 +1,69 @@
+package turn
+
+import (
+	"bytes"
+	"testing"
+
+	"gortc.io/stun"
+)
+
+func TestAddressErrorCode(t *testing.T) {
+	c := AddressErrorCode{
+		Family: RequestedFamilyIPv6,
+		Code:   stun.CodeAddrFamilyNotSupported,
+		Reason: []byte("Address Family not Supported"),
+	}
+	t.Run("String", func(t *testing.T) {
+		if c.String() != "IPv6 440: Address Family not Supported" {
+			t.Errorf("bad string %q", c)
+		}
+	})
+	t.Run("AddTo", func(t *testing.T) {
+		m := new(stun.Message)
+		if err := c.AddTo(m); err != nil {
+			t.Fatal(err)
+		}
+		m.WriteHeader()
+		t.Run("GetFrom", func(t *testing.T) {
+			decoded := new(stun.Message)
+			if _, err := decoded.Write(m.Raw); err != nil {
+				t.Fatal("failed to decode message:", err)
+			}
+			var got AddressErrorCode
+			if err := got.GetFrom(decoded); err != nil {
+				t.Fatal(err)
+			}
+			if got.Family != c.Family || got.Code != c.Code || !bytes.Equal(got.Reason, c.Reason) {
+				t.Errorf("Decoded %s, expected %s", got, c)
+			}
+			if wasAllocs(func() {
+				got.GetFrom(decoded)
+			}) {
+				t.Error("Unexpected allocations")
+			}
+		})
+	})
+	t.Run("HandleErr", func(t *testing.T) {
+		m := new(stun.Message)
+		var handle AddressErrorCode
+		if err := handle.GetFrom(m); err != stun.ErrAttributeNotFound {
+			t.Errorf("%v should be not found", err)
+		}
+		m.Add(AttrAddressErrorCode, []byte{2, 0, 4})
+		if !stun.IsAttrSizeInvalid(handle.GetFrom(m)) {
+			t.Error("IsAttrSizeInvalid should be true")
+		}
+		m.Reset()
+		m.Add(AttrAddressErrorCode, []byte{5, 0, 4, 40})
+		if handle.GetFrom(m) == nil {
+			t.Error("should error on invalid family")
+		}
+		long := AddressErrorCode{
+			Family: RequestedFamilyIPv4,
+			Reason: make([]byte, 1024),
+		}
+		if err := long.AddTo(m); err == nil {
+			t.Error("should error on overflow")
+		}
+	})
+}
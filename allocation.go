@@ -0,0 +1,316 @@
+package turn
+
+import (
+	"errors"
+	"net"
+	"sync"
+	"time"
+
+	"gortc.io/stun"
+)
+
+// allocationQueueSize is count of received packets that are buffered
+// by Allocation before being dropped.
+const allocationQueueSize = 128
+
+// ErrAllocationClosed means that allocation is closed.
+var ErrAllocationClosed = errors.New("allocation is closed")
+
+// Allocation represents allocation on TURN server that was
+// created by Client.
+//
+// Allocation implements net.PacketConn, relaying packets to peers via
+// Send indications or ChannelData messages if channel is bound to peer,
+// so it can be used like UDP socket. Note that server relays packets
+// only to peers for which permission is installed.
+//
+// Addresses returned by ReadFrom are *net.UDPAddr.
+//
+// RFC 5766 Section 5
+type Allocation struct {
+	client *Client
+
+	// Relayed is relayed transport address that was allocated
+	// by server.
+	Relayed RelayedAddress
+	// AdditionalRelayed is relayed transport address of second address
+	// family if it was requested and allocated by server.
+	AdditionalRelayed RelayedAddress
+	// AddressError is ADDRESS-ERROR-CODE from Allocate response if
+	// server provided it.
+	AddressError AddressErrorCode
+	// Reflexive is server reflexive address of client as seen
+	// by server, if server provided it.
+	Reflexive stun.XORMappedAddress
+	// Lifetime is allocation lifetime granted by server on Allocate.
+	Lifetime Lifetime
+
+	packets       chan packet
+	attempts      chan connectionAttempt // only for TCP allocations
+	readDeadline  *deadline
+	writeDeadline *deadline
+
+	mux         sync.Mutex
+	lifetime    Lifetime  // last granted lifetime
+	refreshAt   time.Time // time of next allocation refresh
+	expiresAt   time.Time
+	perms       map[string]*permission // peer IP -> permission
+	channels    map[ChannelNumber]*binding
+	peers       map[string]*binding // peer address -> binding
+	cooldowns   map[ChannelNumber]cooldown
+	nextChannel ChannelNumber
+	ticket      MobilityTicket // last mobility ticket from server
+
+	closeOnce sync.Once
+	closed    chan struct{}
+	done      chan struct{} // closed when refresh loop is stopped
+	wake      chan struct{} // wakes refresh loop to re-schedule
+}
+
+// packet is data that was relayed from peer.
+type packet struct {
+	data []byte
+	addr Addr
+	err  error // ICMP error reported by server
+}
+
+func newAllocation(c *Client) *Allocation {
+	return &Allocation{
+		client:        c,
+		packets:       make(chan packet, allocationQueueSize),
+		readDeadline:  newDeadline(),
+		writeDeadline: newDeadline(),
+		perms:         make(map[string]*permission),
+		channels:      make(map[ChannelNumber]*binding),
+		peers:         make(map[string]*binding),
+		cooldowns:     make(map[ChannelNumber]cooldown),
+		nextChannel:   MinChannelNumber,
+		closed:        make(chan struct{}),
+		done:          make(chan struct{}),
+		wake:          make(chan struct{}, 1),
+	}
+}
+
+// peerAddr converts net.Addr to Addr.
+func peerAddr(addr net.Addr) (Addr, error) {
+	switch a := addr.(type) {
+	case *net.UDPAddr:
+		return Addr{IP: a.IP, Port: a.Port}, nil
+	case *net.TCPAddr:
+		return Addr{IP: a.IP, Port: a.Port}, nil
+	case Addr:
+		return a, nil
+	case *Addr:
+		return *a, nil
+	default:
+		return Addr{}, errors.New("unsupported address type")
+	}
+}
+
+// channel returns channel number that is bound to peer.
+func (a *Allocation) channel(peer Addr) (ChannelNumber, bool) {
+	a.mux.Lock()
+	b, ok := a.peers[peer.String()]
+	a.mux.Unlock()
+	if !ok {
+		return 0, false
+	}
+	return b.number, true
+}
+
+// peer returns peer address that is bound to channel number.
+func (a *Allocation) peer(n ChannelNumber) (Addr, bool) {
+	a.mux.Lock()
+	b, ok := a.channels[n]
+	a.mux.Unlock()
+	if !ok {
+		return Addr{}, false
+	}
+	return b.peer, true
+}
+
+// handleData handles Data indication from server.
+func (a *Allocation) handleData(m *stun.Message) {
+	var (
+		peer PeerAddress
+		data Data
+		icmp ICMP
+	)
+	if err := peer.GetFrom(m); err != nil {
+		return
+	}
+	if err := icmp.GetFrom(m); err == nil {
+		// Data indication with ICMP has no DATA, RFC 8656 Section 11.6.
+		addr := &net.UDPAddr{IP: peer.IP, Port: peer.Port}
+		if icmpErr := icmp.Err(addr); icmpErr != nil {
+			a.enqueue(packet{addr: Addr(peer), err: icmpErr})
+		}
+		return
+	}
+	if err := data.GetFrom(m); err != nil {
+		return
+	}
+	// Data is sub-slice of m.Raw that is not reused.
+	a.enqueue(packet{
+		data: data,
+		addr: Addr(peer),
+	})
+}
+
+// handleChannelData handles ChannelData message from server.
+func (a *Allocation) handleChannelData(buf []byte) {
+	d := &ChannelData{Raw: buf}
+	if err := d.Decode(); err != nil {
+		return
+	}
+	peer, ok := a.peer(d.Number)
+	if !ok {
+		return
+	}
+	a.enqueue(packet{
+		data: append([]byte(nil), d.Data...),
+		addr: peer,
+	})
+}
+
+func (a *Allocation) enqueue(p packet) {
+	select {
+	case a.packets <- p:
+	default:
+		// Dropping packet like UDP socket does on buffer overflow.
+	}
+}
+
+// ReadFrom implements net.PacketConn.
+//
+// If peer responded with ICMP error to data sent by client, ReadFrom
+// returns *PortUnreachableError or *FragmentationNeededError with the
+// peer address.
+func (a *Allocation) ReadFrom(b []byte) (int, net.Addr, error) {
+	select {
+	case p := <-a.packets:
+		n := copy(b, p.data)
+		return n, &net.UDPAddr{IP: p.addr.IP, Port: p.addr.Port}, p.err
+	case <-a.readDeadline.done():
+		return 0, nil, timeoutError{}
+	case <-a.closed:
+		return 0, nil, ErrAllocationClosed
+	}
+}
+
+// WriteTo implements net.PacketConn.
+func (a *Allocation) WriteTo(b []byte, addr net.Addr) (int, error) {
+	return a.writeTo(b, addr, false)
+}
+
+// WriteToDontFragment is like WriteTo, but always uses Send indication
+// with DONT-FRAGMENT attribute, so server sets the DF bit when relaying
+// data to peer. Together with *FragmentationNeededError returned from
+// ReadFrom it can be used for path MTU discovery.
+//
+// RFC 8656 Section 11.1
+func (a *Allocation) WriteToDontFragment(b []byte, addr net.Addr) (int, error) {
+	return a.writeTo(b, addr, true)
+}
+
+func (a *Allocation) writeTo(b []byte, addr net.Addr, dontFragment bool) (int, error) {
+	select {
+	case <-a.closed:
+		return 0, ErrAllocationClosed
+	default:
+	}
+	if a.writeDeadline.exceeded() {
+		return 0, timeoutError{}
+	}
+	peer, err := peerAddr(addr)
+	if err != nil {
+		return 0, err
+	}
+	c := a.client
+	if n, ok := a.channel(peer); ok && !dontFragment {
+		d := &ChannelData{
+			Number: n,
+			Data:   b,
+		}
+		d.Encode()
+		if _, err = c.connection().WriteTo(d.Raw, c.server); err != nil {
+			return 0, err
+		}
+		return len(b), nil
+	}
+	setters := []stun.Setter{
+		stun.TransactionID, SendIndication, PeerAddress(peer), Data(b),
+	}
+	if dontFragment {
+		setters = append(setters, DontFragment)
+	}
+	m, err := stun.Build(setters...)
+	if err != nil {
+		return 0, err
+	}
+	if _, err = c.connection().WriteTo(m.Raw, c.server); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+// Close stops automatic refreshes and deletes allocation on
+// server by sending Refresh request with zero lifetime.
+//
+// Implements net.PacketConn.
+func (a *Allocation) Close() error {
+	var err error
+	a.closeOnce.Do(func() {
+		close(a.closed)
+		<-a.done
+		err = a.deallocate()
+		a.release()
+	})
+	return err
+}
+
+// expire closes allocation that does not exist on server anymore, so
+// client can create new one.
+func (a *Allocation) expire() {
+	a.closeOnce.Do(func() {
+		close(a.closed)
+		a.release()
+	})
+}
+
+// release removes allocation from client.
+func (a *Allocation) release() {
+	c := a.client
+	c.mux.Lock()
+	if c.alloc == a {
+		c.alloc = nil
+	}
+	c.mux.Unlock()
+}
+
+// LocalAddr returns relayed transport address as *net.UDPAddr.
+func (a *Allocation) LocalAddr() net.Addr {
+	return &net.UDPAddr{
+		IP:   a.Relayed.IP,
+		Port: a.Relayed.Port,
+	}
+}
+
+// SetDeadline implements net.PacketConn.
+func (a *Allocation) SetDeadline(t time.Time) error {
+	a.readDeadline.set(t)
+	a.writeDeadline.set(t)
+	return nil
+}
+
+// SetReadDeadline implements net.PacketConn.
+func (a *Allocation) SetReadDeadline(t time.Time) error {
+	a.readDeadline.set(t)
+	return nil
+}
+
+// SetWriteDeadline implements net.PacketConn.
+func (a *Allocation) SetWriteDeadline(t time.Time) error {
+	a.writeDeadline.set(t)
+	return nil
+}
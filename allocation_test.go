@@ -0,0 +1,203 @@
+package turn
+
+import (
+	"bytes"
+	"net"
+	"testing"
+	"time"
+
+	"gortc.io/stun"
+)
+
+func newTestAllocation(t *testing.T, s *fakeServer) (*Client, *Allocation) {
+	c := newTestClient(t, s)
+	a, err := c.Allocate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c, a
+}
+
+// addTestBinding adds channel binding without ChannelBind request.
+func (a *Allocation) addTestBinding(n ChannelNumber, peer Addr) {
+	b := &binding{
+		number:    n,
+		peer:      peer,
+		refreshAt: time.Now().Add(refreshDelay(ChannelLifetime)),
+		expiresAt: time.Now().Add(ChannelLifetime),
+	}
+	a.channels[n] = b
+	a.peers[peer.String()] = b
+}
+
+func TestAllocation_WriteTo(t *testing.T) {
+	peer := &net.UDPAddr{IP: net.IPv4(10, 0, 0, 2), Port: 3478}
+	got := make(chan []byte, 2)
+	dontFragment := make(chan bool, 2)
+	s := newFakeServer(t, func(req *stun.Message, addr net.Addr) *stun.Message {
+		if req.Type != SendIndication {
+			return allocateHandler(t, req, addr)
+		}
+		dontFragment <- DontFragment.IsSet(req)
+		var (
+			p    PeerAddress
+			data Data
+		)
+		if err := req.Parse(&p, &data); err != nil {
+			t.Error(err)
+		}
+		if !p.IP.Equal(peer.IP) || p.Port != peer.Port {
+			t.Errorf("unexpected peer %s", p)
+		}
+		got <- data
+		return nil
+	})
+	s.setRaw(func(buf []byte, addr net.Addr) {
+		d := &ChannelData{Raw: buf}
+		if err := d.Decode(); err != nil {
+			t.Error(err)
+		}
+		if d.Number != MinChannelNumber {
+			t.Errorf("unexpected number %s", d.Number)
+		}
+		got <- append([]byte(nil), d.Data...)
+	})
+	defer s.Close()
+	c, a := newTestAllocation(t, s)
+	defer c.Close()
+	if n, err := a.WriteTo([]byte("hello"), peer); err != nil || n != 5 {
+		t.Fatal(n, err)
+	}
+	if v := <-got; !bytes.Equal(v, []byte("hello")) {
+		t.Errorf("unexpected data %q", v)
+	}
+	if <-dontFragment {
+		t.Error("DONT-FRAGMENT should not be set")
+	}
+	// Binding channel manually.
+	a.mux.Lock()
+	a.addTestBinding(MinChannelNumber, Addr{IP: peer.IP, Port: peer.Port})
+	a.mux.Unlock()
+	if _, err := a.WriteTo([]byte("channel"), peer); err != nil {
+		t.Fatal(err)
+	}
+	if v := <-got; !bytes.Equal(v, []byte("channel")) {
+		t.Errorf("unexpected data %q", v)
+	}
+	// Send indication should be used even if channel is bound.
+	if _, err := a.WriteToDontFragment([]byte("df"), peer); err != nil {
+		t.Fatal(err)
+	}
+	if v := <-got; !bytes.Equal(v, []byte("df")) {
+		t.Errorf("unexpected data %q", v)
+	}
+	if !<-dontFragment {
+		t.Error("DONT-FRAGMENT should be set")
+	}
+	if _, err := a.WriteTo([]byte("hello"), &net.IPAddr{}); err == nil {
+		t.Error("should error on unsupported address")
+	}
+	if err := a.SetWriteDeadline(time.Now().Add(-time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := a.WriteTo([]byte("hello"), peer); err == nil {
+		t.Error("should timeout")
+	}
+}
+
+func TestAllocation_ReadFrom(t *testing.T) {
+	s := newFakeServer(t, func(req *stun.Message, addr net.Addr) *stun.Message {
+		return allocateHandler(t, req, addr)
+	})
+	defer s.Close()
+	c, a := newTestAllocation(t, s)
+	defer c.Close()
+	peer := Addr{IP: net.IPv4(10, 0, 0, 2), Port: 3478}
+	buf := make([]byte, 1024)
+
+	// Data indication.
+	s.send(stun.MustBuild(stun.TransactionID, stun.NewType(stun.MethodData, stun.ClassIndication),
+		PeerAddress(peer), Data("indication"),
+	).Raw, c.conn.LocalAddr())
+	n, addr, err := a.ReadFrom(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(buf[:n]) != "indication" {
+		t.Errorf("unexpected data %q", buf[:n])
+	}
+	if addr.String() != peer.String() {
+		t.Errorf("unexpected addr %s", addr)
+	}
+
+	// ChannelData.
+	a.mux.Lock()
+	a.addTestBinding(MinChannelNumber+1, peer)
+	a.mux.Unlock()
+	d := &ChannelData{Number: MinChannelNumber + 1, Data: []byte("channel")}
+	d.Encode()
+	s.send(d.Raw, c.conn.LocalAddr())
+	if n, addr, err = a.ReadFrom(buf); err != nil {
+		t.Fatal(err)
+	}
+	if string(buf[:n]) != "channel" {
+		t.Errorf("unexpected data %q", buf[:n])
+	}
+	if addr.String() != peer.String() {
+		t.Errorf("unexpected addr %s", addr)
+	}
+
+	// Data indication with ICMP.
+	s.send(stun.MustBuild(stun.TransactionID, stun.NewType(stun.MethodData, stun.ClassIndication),
+		PeerAddress(peer), ICMP{Type: 3, Code: 4, Data: 1400},
+	).Raw, c.conn.LocalAddr())
+	_, addr, err = a.ReadFrom(buf)
+	if fragErr, ok := err.(*FragmentationNeededError); !ok || fragErr.MTU != 1400 {
+		t.Errorf("unexpected error %v", err)
+	}
+	if addr.String() != peer.String() {
+		t.Errorf("unexpected addr %s", addr)
+	}
+
+	// Deadline.
+	if err = a.SetDeadline(time.Now().Add(time.Millisecond * 10)); err != nil {
+		t.Fatal(err)
+	}
+	_, _, err = a.ReadFrom(buf)
+	if ne, ok := err.(net.Error); !ok || !ne.Timeout() {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if err = a.SetReadDeadline(time.Time{}); err != nil {
+		t.Fatal(err)
+	}
+
+	if a.LocalAddr().String() != "10.0.0.1:5000" {
+		t.Errorf("unexpected local addr %s", a.LocalAddr())
+	}
+	if err = a.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err = a.ReadFrom(buf); err != ErrAllocationClosed {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if _, err = a.WriteTo(buf, &net.UDPAddr{}); err != ErrAllocationClosed {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestPeerAddr(t *testing.T) {
+	ip := net.IPv4(1, 2, 3, 4)
+	for _, addr := range []net.Addr{
+		&net.UDPAddr{IP: ip, Port: 1},
+		Addr{IP: ip, Port: 1},
+		&Addr{IP: ip, Port: 1},
+	} {
+		a, err := peerAddr(addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !a.Equal(Addr{IP: ip, Port: 1}) {
+			t.Errorf("unexpected %s", a)
+		}
+	}
+}
@@ -0,0 +1,139 @@
+package turn
+
+import (
+	"bufio"
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"gortc.io/stun"
+)
+
+// ErrUnknownUser means that there are no credentials for user.
+var ErrUnknownUser = errors.New("unknown user")
+
+// Auth looks up long-term credentials for server.
+//
+// Key can be called concurrently. Lookups for requests on TCP and TLS
+// connections are called on the loop that reads from connection, so
+// slow lookups delay the client, see ServerOptions.AuthTimeout.
+//
+// RFC 5389 Section 10.2
+type Auth interface {
+	// Key returns long-term key, which is MD5(username ":" realm ":"
+	// password), or ErrUnknownUser if user is not allowed to use server.
+	// Lookup should be aborted when ctx is done, which happens after
+	// timeout of server or when server is closed.
+	Key(ctx context.Context, username, realm string) (stun.MessageIntegrity, error)
+}
+
+// AuthFunc is adapter to allow use of ordinary function as Auth.
+type AuthFunc func(ctx context.Context, username, realm string) (stun.MessageIntegrity, error)
+
+// Key calls f(ctx, username, realm).
+func (f AuthFunc) Key(ctx context.Context, username, realm string) (stun.MessageIntegrity, error) {
+	return f(ctx, username, realm)
+}
+
+// StaticAuth is Auth with static username to password mapping that
+// accepts any realm.
+type StaticAuth map[string]string
+
+// Key implements Auth.
+func (a StaticAuth) Key(ctx context.Context, username, realm string) (stun.MessageIntegrity, error) {
+	password, ok := a[username]
+	if !ok {
+		return nil, ErrUnknownUser
+	}
+	return stun.NewLongTermIntegrity(username, realm, password), nil
+}
+
+// userDBKeyPrefix is prefix of hex-encoded key in userdb file.
+const userDBKeyPrefix = "0x"
+
+// userDBEntry is either password or long-term key of user.
+type userDBEntry struct {
+	password string
+	key      stun.MessageIntegrity
+}
+
+// UserDB is Auth with users from flat file database in coturn format,
+// see ParseUserDB.
+type UserDB struct {
+	// Realm is the only realm that is accepted if not blank. Keys in
+	// database are valid only for realm they were generated for, so
+	// it should be set if database contains keys.
+	Realm string
+
+	users map[string]userDBEntry
+}
+
+// ParseUserDB reads users from r in coturn userdb format, with
+// one user per line:
+//
+//	username:password
+//	username:0x7da2270ccfa49786e0115366d3a3d14d
+//
+// where second form is hex-encoded long-term key that is generated by
+// "turnadmin -k". Blank lines and lines starting with # are ignored.
+func ParseUserDB(r io.Reader) (*UserDB, error) {
+	db := &UserDB{
+		users: make(map[string]userDBEntry),
+	}
+	s := bufio.NewScanner(r)
+	for line := 1; s.Scan(); line++ {
+		text := strings.TrimSpace(s.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		i := strings.Index(text, ":")
+		if i <= 0 {
+			return nil, fmt.Errorf("userdb: line %d: expected username:password", line)
+		}
+		username, secret := text[:i], text[i+1:]
+		var entry userDBEntry
+		if strings.HasPrefix(secret, userDBKeyPrefix) {
+			key, err := hex.DecodeString(secret[len(userDBKeyPrefix):])
+			if err != nil || len(key) != 16 {
+				return nil, fmt.Errorf("userdb: line %d: invalid key", line)
+			}
+			entry.key = key
+		} else {
+			entry.password = secret
+		}
+		db.users[username] = entry
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+	return db, nil
+}
+
+// LoadUserDB reads users from file in coturn userdb format.
+func LoadUserDB(name string) (*UserDB, error) {
+	f, err := os.Open(name) // #nosec
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ParseUserDB(f)
+}
+
+// Key implements Auth.
+func (db *UserDB) Key(ctx context.Context, username, realm string) (stun.MessageIntegrity, error) {
+	if db.Realm != "" && realm != db.Realm {
+		return nil, ErrUnknownUser
+	}
+	entry, ok := db.users[username]
+	if !ok {
+		return nil, ErrUnknownUser
+	}
+	if entry.key != nil {
+		return entry.key, nil
+	}
+	return stun.NewLongTermIntegrity(username, realm, entry.password), nil
+}
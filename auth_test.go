@@ -0,0 +1,88 @@
+package turn
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+
+	"gortc.io/stun"
+)
+
+func TestStaticAuth(t *testing.T) {
+	a := StaticAuth{"user": "secret"}
+	key, err := a.Key(context.Background(), "user", "realm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(key, stun.NewLongTermIntegrity("user", "realm", "secret")) {
+		t.Error("unexpected key")
+	}
+	if _, err = a.Key(context.Background(), "other", "realm"); err != ErrUnknownUser {
+		t.Errorf("unexpected error %v", err)
+	}
+}
+
+func TestAuthFunc(t *testing.T) {
+	var a Auth = AuthFunc(func(ctx context.Context, username, realm string) (stun.MessageIntegrity, error) {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := a.Key(ctx, "user", "realm"); err != context.Canceled {
+		t.Errorf("unexpected error %v", err)
+	}
+}
+
+func TestUserDB(t *testing.T) {
+	db, err := LoadUserDB("testdata/turnuserdb.conf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	for _, tc := range []struct {
+		username, password string
+	}{
+		{"alice", "alice-password"},
+		{"bob", "bob-password"},
+	} {
+		t.Run(tc.username, func(t *testing.T) {
+			key, keyErr := db.Key(ctx, tc.username, "example.org")
+			if keyErr != nil {
+				t.Fatal(keyErr)
+			}
+			if !bytes.Equal(key, stun.NewLongTermIntegrity(tc.username, "example.org", tc.password)) {
+				t.Error("unexpected key")
+			}
+		})
+	}
+	t.Run("Unknown", func(t *testing.T) {
+		if _, err = db.Key(ctx, "eve", "example.org"); err != ErrUnknownUser {
+			t.Errorf("unexpected error %v", err)
+		}
+	})
+	t.Run("Realm", func(t *testing.T) {
+		db.Realm = "example.org"
+		if _, err = db.Key(ctx, "bob", "example.com"); err != ErrUnknownUser {
+			t.Errorf("unexpected error %v", err)
+		}
+	})
+	t.Run("Invalid", func(t *testing.T) {
+		for _, text := range []string{
+			"username",
+			":password",
+			"user:0x1234",
+			"user:0xzz92ed8dce9d4662b9a6acc3a3ba4f53",
+		} {
+			if _, err = ParseUserDB(strings.NewReader(text)); err == nil {
+				t.Errorf("should error on %q", text)
+			}
+		}
+	})
+	t.Run("NotFound", func(t *testing.T) {
+		if _, err = LoadUserDB("testdata/not-found.conf"); err == nil {
+			t.Error("should error")
+		}
+	})
+}
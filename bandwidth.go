@@ -0,0 +1,89 @@
+package turn
+
+import (
+	"sync"
+	"time"
+)
+
+// BandwidthLimit limits rate of relayed data. Zero value means no limit.
+type BandwidthLimit struct {
+	// BytesPerSecond is sustained rate.
+	BytesPerSecond int
+	// Burst is maximum count of bytes that can be relayed at once,
+	// BytesPerSecond if zero. UDP packets that are bigger than Burst
+	// are always dropped.
+	Burst int
+}
+
+// tokenBucket limits rate of data with token bucket algorithm, where
+// tokens are bytes. Safe for concurrent use.
+type tokenBucket struct {
+	rate  float64 // tokens per second
+	burst float64
+
+	mux    sync.Mutex
+	tokens float64
+	last   time.Time
+}
+
+// newTokenBucket returns full token bucket for limit or nil if there
+// is no limit.
+func newTokenBucket(l BandwidthLimit, now time.Time) *tokenBucket {
+	if l.BytesPerSecond <= 0 {
+		return nil
+	}
+	if l.Burst <= 0 {
+		l.Burst = l.BytesPerSecond
+	}
+	return &tokenBucket{
+		rate:   float64(l.BytesPerSecond),
+		burst:  float64(l.Burst),
+		tokens: float64(l.Burst),
+		last:   now,
+	}
+}
+
+// allow takes n tokens and returns true if there are enough of them,
+// otherwise returns false without taking any. Nil bucket allows all.
+func (b *tokenBucket) allow(n int, now time.Time) bool {
+	if b == nil {
+		return true
+	}
+	b.mux.Lock()
+	defer b.mux.Unlock()
+	b.fill(now)
+	if float64(n) > b.tokens {
+		return false
+	}
+	b.tokens -= float64(n)
+	return true
+}
+
+// reserve takes n tokens, which should not exceed burst, and returns
+// duration to wait before relaying them. Tokens are borrowed if there
+// are not enough of them, so concurrent callers are served in order.
+// Nil bucket never waits.
+func (b *tokenBucket) reserve(n int, now time.Time) time.Duration {
+	if b == nil {
+		return 0
+	}
+	b.mux.Lock()
+	defer b.mux.Unlock()
+	b.fill(now)
+	b.tokens -= float64(n)
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / b.rate * float64(time.Second))
+}
+
+// fill adds tokens for time elapsed since last fill, up to burst.
+func (b *tokenBucket) fill(now time.Time) {
+	if elapsed := now.Sub(b.last); elapsed > 0 {
+		b.tokens += elapsed.Seconds() * b.rate
+		if b.tokens > b.burst {
+			b.tokens = b.burst
+		}
+		b.last = now
+	}
+}
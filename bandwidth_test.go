@@ -0,0 +1,74 @@
+package turn
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTokenBucket(t *testing.T) {
+	now := time.Now()
+	if b := newTokenBucket(BandwidthLimit{}, now); b != nil || !b.allow(1<<20, now) {
+		t.Error("zero limit should allow all")
+	}
+	b := newTokenBucket(BandwidthLimit{BytesPerSecond: 1000, Burst: 1500}, now)
+	if !b.allow(1000, now) || !b.allow(500, now) {
+		t.Error("burst should be allowed")
+	}
+	if b.allow(1, now) {
+		t.Error("should be limited after burst")
+	}
+	now = now.Add(time.Millisecond * 100)
+	if !b.allow(100, now) {
+		t.Error("tokens should be refilled")
+	}
+	if b.allow(1, now) {
+		t.Error("should be limited")
+	}
+	now = now.Add(time.Hour)
+	if b.allow(1501, now) {
+		t.Error("packet bigger than burst should not be allowed")
+	}
+	if !b.allow(1500, now) {
+		t.Error("tokens should be refilled up to burst")
+	}
+	t.Run("DefaultBurst", func(t *testing.T) {
+		b := newTokenBucket(BandwidthLimit{BytesPerSecond: 1000}, now)
+		if !b.allow(1000, now) || b.allow(1, now) {
+			t.Error("burst should be equal to rate")
+		}
+	})
+}
+
+func TestTokenBucket_reserve(t *testing.T) {
+	now := time.Now()
+	var unlimited *tokenBucket
+	if wait := unlimited.reserve(1<<20, now); wait != 0 {
+		t.Errorf("unexpected wait %s", wait)
+	}
+	b := newTokenBucket(BandwidthLimit{BytesPerSecond: 1000, Burst: 500}, now)
+	if wait := b.reserve(500, now); wait != 0 {
+		t.Errorf("burst should not wait, got %s", wait)
+	}
+	if wait := b.reserve(100, now); wait != time.Millisecond*100 {
+		t.Errorf("unexpected wait %s", wait)
+	}
+	// Tokens are borrowed, so next reservation waits longer.
+	if wait := b.reserve(200, now); wait != time.Millisecond*300 {
+		t.Errorf("unexpected wait %s", wait)
+	}
+	if wait := b.reserve(100, now.Add(time.Millisecond*400)); wait != 0 {
+		t.Errorf("unexpected wait %s", wait)
+	}
+}
+
+func BenchmarkTokenBucket_allow(b *testing.B) {
+	now := time.Now()
+	bucket := newTokenBucket(BandwidthLimit{BytesPerSecond: 1 << 30}, now)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		now = now.Add(time.Microsecond)
+		if !bucket.allow(1200, now) {
+			b.Fatal("not allowed")
+		}
+	}
+}
@@ -0,0 +1,204 @@
+package turn
+
+import (
+	"errors"
+	"net"
+	"time"
+)
+
+// ChannelLifetime is lifetime of channel binding.
+//
+// RFC 5766 Section 11
+const ChannelLifetime = time.Minute * 10
+
+// channelCooldown is duration after channel binding expiration during
+// which channel number can't be bound to another peer and peer
+// can't be bound to another channel number.
+//
+// RFC 5766 Section 11
+const channelCooldown = time.Minute * 5
+
+// ErrNoFreeChannel means that all channel numbers are bound or
+// can't be reused yet.
+var ErrNoFreeChannel = errors.New("no free channel number")
+
+// binding represents channel binding of peer to channel number.
+type binding struct {
+	number    ChannelNumber
+	peer      Addr
+	refreshAt time.Time
+	expiresAt time.Time
+}
+
+// cooldown represents expired channel binding.
+type cooldown struct {
+	peer  Addr
+	until time.Time
+}
+
+// BindChannel binds peer to free channel number, so ChannelData messages
+// will be used to exchange data with peer instead of Send and Data
+// indications. Returns already bound number if peer is bound.
+//
+// Channel binding is refreshed automatically until it is removed by
+// UnbindChannel or allocation is closed.
+//
+// RFC 5766 Section 11
+func (a *Allocation) BindChannel(p net.Addr) (ChannelNumber, error) {
+	peer, err := peerAddr(p)
+	if err != nil {
+		return 0, err
+	}
+	now := time.Now()
+	a.mux.Lock()
+	if b, ok := a.peers[peer.String()]; ok {
+		a.mux.Unlock()
+		return b.number, nil
+	}
+	n, err := a.freeChannel(peer, now)
+	if err == nil {
+		// Reserving number until request is completed.
+		a.cooldowns[n] = cooldown{peer: peer, until: now.Add(channelCooldown)}
+	}
+	a.mux.Unlock()
+	if err != nil {
+		return 0, err
+	}
+	if _, err = a.client.request(ChannelBindRequest, n, PeerAddress(peer)); err != nil {
+		if _, ok := err.(*ResponseError); ok {
+			// Server explicitly rejected binding, so number can be reused.
+			a.mux.Lock()
+			delete(a.cooldowns, n)
+			a.mux.Unlock()
+		}
+		return 0, err
+	}
+	now = time.Now()
+	b := &binding{
+		number:    n,
+		peer:      peer,
+		refreshAt: now.Add(refreshDelay(ChannelLifetime)),
+		expiresAt: now.Add(ChannelLifetime),
+	}
+	a.mux.Lock()
+	delete(a.cooldowns, n)
+	a.channels[n] = b
+	a.peers[peer.String()] = b
+	a.mux.Unlock()
+	a.reschedule()
+	return n, nil
+}
+
+// freeChannel returns channel number that can be bound to peer.
+// Should be called under lock.
+func (a *Allocation) freeChannel(peer Addr, now time.Time) (ChannelNumber, error) {
+	for n, c := range a.cooldowns {
+		if now.After(c.until) {
+			delete(a.cooldowns, n)
+			continue
+		}
+		if c.peer.Equal(peer) {
+			// Peer can be bound only to same channel number.
+			return n, nil
+		}
+	}
+	const count = int(MaxChannelNumber-MinChannelNumber) + 1
+	n := a.nextChannel
+	for i := 0; i < count; i++ {
+		if !n.Valid() {
+			n = MinChannelNumber
+		}
+		candidate := n
+		n++
+		if _, bound := a.channels[candidate]; bound {
+			continue
+		}
+		if _, cooling := a.cooldowns[candidate]; cooling {
+			continue
+		}
+		a.nextChannel = n
+		return candidate, nil
+	}
+	return 0, ErrNoFreeChannel
+}
+
+// UnbindChannel stops refreshing channel binding of peer and switches
+// to Send indications for it. TURN has no explicit unbinding, so
+// channel number and peer can't be bound again to anything else until
+// binding expires on server and cooldown period ends.
+func (a *Allocation) UnbindChannel(p net.Addr) error {
+	peer, err := peerAddr(p)
+	if err != nil {
+		return err
+	}
+	a.mux.Lock()
+	if b, ok := a.peers[peer.String()]; ok {
+		a.expireBinding(b)
+	}
+	a.mux.Unlock()
+	return nil
+}
+
+// expireBinding removes binding and starts cooldown period for it.
+// Should be called under lock.
+func (a *Allocation) expireBinding(b *binding) {
+	delete(a.channels, b.number)
+	delete(a.peers, b.peer.String())
+	a.cooldowns[b.number] = cooldown{
+		peer:  b.peer,
+		until: b.expiresAt.Add(channelCooldown),
+	}
+}
+
+// Channels returns currently bound channel numbers and their peers.
+func (a *Allocation) Channels() map[ChannelNumber]net.Addr {
+	a.mux.Lock()
+	defer a.mux.Unlock()
+	channels := make(map[ChannelNumber]net.Addr, len(a.channels))
+	for n, b := range a.channels {
+		channels[n] = &net.UDPAddr{IP: b.peer.IP, Port: b.peer.Port}
+	}
+	return channels
+}
+
+// maintainChannels re-binds channels that are due at now and returns
+// time of next channel refresh.
+func (a *Allocation) maintainChannels(now time.Time) (time.Time, error) {
+	var due []*binding
+	a.mux.Lock()
+	for _, b := range a.channels {
+		if !now.Before(b.expiresAt) {
+			// Failed to refresh binding in time.
+			a.expireBinding(b)
+			continue
+		}
+		if now.Before(b.refreshAt) {
+			continue
+		}
+		due = append(due, b)
+	}
+	a.mux.Unlock()
+	var err error
+	for _, b := range due {
+		_, bindErr := a.client.request(ChannelBindRequest, b.number, PeerAddress(b.peer))
+		a.mux.Lock()
+		if bindErr == nil {
+			b.refreshAt = now.Add(refreshDelay(ChannelLifetime))
+			b.expiresAt = now.Add(ChannelLifetime)
+		} else {
+			b.refreshAt = now.Add(refreshRetryInterval)
+			if b.refreshAt.After(b.expiresAt) {
+				b.refreshAt = b.expiresAt
+			}
+			err = bindErr
+		}
+		a.mux.Unlock()
+	}
+	var next time.Time
+	a.mux.Lock()
+	for _, b := range a.channels {
+		next = earliest(next, b.refreshAt)
+	}
+	a.mux.Unlock()
+	return next, err
+}
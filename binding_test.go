@@ -0,0 +1,212 @@
+package turn
+
+import (
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"gortc.io/stun"
+)
+
+// bindServer is fake server that records ChannelBind requests.
+type bindServer struct {
+	mux      sync.Mutex
+	requests []ChannelNumber
+	code     stun.ErrorCode
+}
+
+func (s *bindServer) handle(t testing.TB, req *stun.Message, addr net.Addr) *stun.Message {
+	if req.Type != ChannelBindRequest {
+		return allocateHandler(t, req, addr)
+	}
+	if res := authenticate(t, req); res != nil {
+		return res
+	}
+	var (
+		n    ChannelNumber
+		peer PeerAddress
+	)
+	if err := req.Parse(&n, &peer); err != nil {
+		t.Error(err)
+	}
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	s.requests = append(s.requests, n)
+	if s.code != 0 {
+		return stun.MustBuild(req, stun.NewType(req.Type.Method, stun.ClassErrorResponse), s.code)
+	}
+	return stun.MustBuild(req, stun.NewType(req.Type.Method, stun.ClassSuccessResponse),
+		testIntegrity, stun.Fingerprint,
+	)
+}
+
+func (s *bindServer) count() int {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	return len(s.requests)
+}
+
+func (s *bindServer) setCode(code stun.ErrorCode) {
+	s.mux.Lock()
+	s.code = code
+	s.mux.Unlock()
+}
+
+func TestAllocation_BindChannel(t *testing.T) {
+	bs := new(bindServer)
+	s := newFakeServer(t, func(req *stun.Message, addr net.Addr) *stun.Message {
+		return bs.handle(t, req, addr)
+	})
+	got := make(chan ChannelNumber, 1)
+	s.setRaw(func(buf []byte, addr net.Addr) {
+		d := &ChannelData{Raw: buf}
+		if err := d.Decode(); err != nil {
+			t.Error(err)
+		}
+		got <- d.Number
+	})
+	defer s.Close()
+	c, a := newTestAllocation(t, s)
+	defer c.Close()
+	var (
+		peerA = &net.UDPAddr{IP: net.IPv4(10, 0, 0, 2), Port: 1001}
+		peerB = &net.UDPAddr{IP: net.IPv4(10, 0, 0, 2), Port: 1002}
+		peerC = &net.UDPAddr{IP: net.IPv4(10, 0, 0, 3), Port: 1001}
+	)
+	n, err := a.BindChannel(peerA)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != MinChannelNumber {
+		t.Errorf("unexpected number %s", n)
+	}
+	if n, err = a.BindChannel(peerA); err != nil || n != MinChannelNumber {
+		t.Errorf("unexpected re-bind result %s, %v", n, err)
+	}
+	if bs.count() != 1 {
+		t.Error("bound peer should not be bound again")
+	}
+	if n, err = a.BindChannel(peerB); err != nil || n != MinChannelNumber+1 {
+		t.Errorf("unexpected result %s, %v", n, err)
+	}
+	if channels := a.Channels(); len(channels) != 2 || channels[MinChannelNumber].String() != peerA.String() {
+		t.Errorf("unexpected channels %v", channels)
+	}
+	if _, err = a.WriteTo([]byte("data"), peerB); err != nil {
+		t.Fatal(err)
+	}
+	if v := <-got; v != MinChannelNumber+1 {
+		t.Errorf("unexpected channel %s", v)
+	}
+
+	// Unbinding.
+	if err = a.UnbindChannel(peerA); err != nil {
+		t.Fatal(err)
+	}
+	if len(a.Channels()) != 1 {
+		t.Error("channel should be unbound")
+	}
+	if n, err = a.BindChannel(peerC); err != nil || n != MinChannelNumber+2 {
+		t.Errorf("cooling channel should not be reused: %s, %v", n, err)
+	}
+	if n, err = a.BindChannel(peerA); err != nil || n != MinChannelNumber {
+		t.Errorf("peer should be re-bound to same channel: %s, %v", n, err)
+	}
+
+	// Errors.
+	if _, err = a.BindChannel(&net.IPAddr{}); err == nil {
+		t.Error("should error on unsupported address")
+	}
+	if err = a.UnbindChannel(&net.IPAddr{}); err == nil {
+		t.Error("should error on unsupported address")
+	}
+	bs.setCode(stun.CodeBadRequest)
+	peerD := &net.UDPAddr{IP: net.IPv4(10, 0, 0, 4), Port: 1001}
+	if _, err = a.BindChannel(peerD); err == nil {
+		t.Error("should error")
+	}
+	a.mux.Lock()
+	_, cooling := a.cooldowns[MinChannelNumber+3]
+	a.mux.Unlock()
+	if cooling {
+		t.Error("rejected number should be released")
+	}
+}
+
+func TestAllocation_freeChannel(t *testing.T) {
+	a := newAllocation(nil)
+	now := time.Now()
+	for n := MinChannelNumber; n <= MaxChannelNumber; n++ {
+		a.channels[n] = &binding{number: n}
+	}
+	peer := Addr{IP: net.IPv4(10, 0, 0, 2), Port: 1001}
+	if _, err := a.freeChannel(peer, now); err != ErrNoFreeChannel {
+		t.Errorf("unexpected error %v", err)
+	}
+	delete(a.channels, MaxChannelNumber)
+	if n, err := a.freeChannel(peer, now); err != nil || n != MaxChannelNumber {
+		t.Errorf("unexpected result %s, %v", n, err)
+	}
+	a.cooldowns[MaxChannelNumber] = cooldown{until: now.Add(time.Second)}
+	if _, err := a.freeChannel(peer, now); err != ErrNoFreeChannel {
+		t.Errorf("unexpected error %v", err)
+	}
+	// Cooldown is over.
+	if n, err := a.freeChannel(peer, now.Add(time.Second*2)); err != nil || n != MaxChannelNumber {
+		t.Errorf("unexpected result %s, %v", n, err)
+	}
+}
+
+func TestAllocation_maintainChannels(t *testing.T) {
+	bs := new(bindServer)
+	s := newFakeServer(t, func(req *stun.Message, addr net.Addr) *stun.Message {
+		return bs.handle(t, req, addr)
+	})
+	defer s.Close()
+	c, a := newTestAllocation(t, s)
+	defer c.Close()
+	close(a.closed)
+	<-a.done
+	peer := &net.UDPAddr{IP: net.IPv4(10, 0, 0, 2), Port: 1001}
+	n, err := a.BindChannel(peer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	next, err := a.maintainChannels(now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if next.Sub(now) > refreshDelay(ChannelLifetime) {
+		t.Errorf("unexpected next refresh %s", next.Sub(now))
+	}
+	now = now.Add(ChannelLifetime - time.Second)
+	if _, err = a.maintainChannels(now); err != nil {
+		t.Fatal(err)
+	}
+	if bs.count() != 2 {
+		t.Error("channel should be re-bound")
+	}
+	// Failed refresh is retried until expiration.
+	bs.setCode(stun.CodeServerError)
+	now = now.Add(refreshDelay(ChannelLifetime))
+	if next, err = a.maintainChannels(now); err == nil {
+		t.Error("should error")
+	}
+	if !next.Equal(now.Add(refreshRetryInterval)) {
+		t.Errorf("unexpected retry %s", next.Sub(now))
+	}
+	if _, err = a.maintainChannels(now.Add(ChannelLifetime)); err != nil {
+		t.Error(err)
+	}
+	if len(a.Channels()) != 0 {
+		t.Error("channel should be expired")
+	}
+	a.mux.Lock()
+	cd, ok := a.cooldowns[n]
+	a.mux.Unlock()
+	if !ok || cd.until.Sub(now) != ChannelLifetime-refreshDelay(ChannelLifetime)+channelCooldown {
+		t.Errorf("unexpected cooldown %v", cd)
+	}
+}
@@ -0,0 +1,124 @@
+package turn
+
+import (
+	"errors"
+	"net"
+	"sync"
+	"time"
+)
+
+// errChannelConflict means that channel number or peer address is
+// already bound to other peer address or channel number.
+var errChannelConflict = errors.New("channel binding conflict")
+
+// serverBinding is channel binding on server.
+type serverBinding struct {
+	number    ChannelNumber
+	peer      *net.UDPAddr
+	key       addrKey
+	expiresAt time.Time
+}
+
+// channelTable is table of channel bindings of server allocation that
+// maps channel numbers to peer addresses and vice versa. Safe for
+// concurrent use.
+//
+// RFC 5766 Section 11
+type channelTable struct {
+	mux      sync.RWMutex
+	channels map[ChannelNumber]*serverBinding
+	peers    map[addrKey]*serverBinding
+}
+
+func newChannelTable() *channelTable {
+	return &channelTable{
+		channels: make(map[ChannelNumber]*serverBinding),
+		peers:    make(map[addrKey]*serverBinding),
+	}
+}
+
+// bind binds channel number to peer for ChannelLifetime or refreshes
+// existing binding. Returns errChannelConflict if number or peer is
+// bound to other peer or number.
+//
+// RFC 5766 Section 11.2
+func (t *channelTable) bind(n ChannelNumber, peer Addr, now time.Time) error {
+	k := newAddrKey(peer.IP, peer.Port)
+	t.mux.Lock()
+	defer t.mux.Unlock()
+	b := t.lookup(t.channels[n], now)
+	byPeer := t.lookup(t.peers[k], now)
+	if b != byPeer {
+		return errChannelConflict
+	}
+	if b == nil {
+		b = &serverBinding{
+			number: n,
+			peer:   &net.UDPAddr{IP: peer.IP, Port: peer.Port},
+			key:    k,
+		}
+		t.channels[n] = b
+		t.peers[k] = b
+	}
+	b.expiresAt = now.Add(ChannelLifetime)
+	return nil
+}
+
+// lookup returns b if it is not expired, otherwise deletes it and
+// returns nil. Should be called with write lock.
+func (t *channelTable) lookup(b *serverBinding, now time.Time) *serverBinding {
+	if b == nil || now.Before(b.expiresAt) {
+		return b
+	}
+	t.delete(b)
+	return nil
+}
+
+func (t *channelTable) delete(b *serverBinding) {
+	delete(t.channels, b.number)
+	delete(t.peers, b.key)
+}
+
+// peer returns peer address that is bound to channel number.
+func (t *channelTable) peer(n ChannelNumber, now time.Time) (*net.UDPAddr, bool) {
+	t.mux.RLock()
+	b, ok := t.channels[n]
+	ok = ok && now.Before(b.expiresAt)
+	t.mux.RUnlock()
+	if !ok {
+		return nil, false
+	}
+	return b.peer, true
+}
+
+// number returns channel number that is bound to peer address.
+func (t *channelTable) number(peer *net.UDPAddr, now time.Time) (ChannelNumber, bool) {
+	t.mux.RLock()
+	b, ok := t.peers[newAddrKey(peer.IP, peer.Port)]
+	ok = ok && now.Before(b.expiresAt)
+	t.mux.RUnlock()
+	if !ok {
+		return 0, false
+	}
+	return b.number, true
+}
+
+// len returns count of bindings, including expired ones that are not
+// collected yet.
+func (t *channelTable) len() int {
+	t.mux.RLock()
+	n := len(t.channels)
+	t.mux.RUnlock()
+	return n
+}
+
+// collect deletes expired bindings.
+func (t *channelTable) collect(now time.Time) {
+	t.mux.Lock()
+	for _, b := range t.channels {
+		if !now.Before(b.expiresAt) {
+			t.delete(b)
+		}
+	}
+	t.mux.Unlock()
+}
@@ -0,0 +1,122 @@
+package turn
+
+import (
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestChannelTable(t *testing.T) {
+	now := time.Now()
+	c := newChannelTable()
+	peer := Addr{IP: net.IPv4(10, 0, 0, 1), Port: 1000}
+	udpPeer := &net.UDPAddr{IP: peer.IP, Port: peer.Port}
+	if err := c.bind(MinChannelNumber, peer, now); err != nil {
+		t.Fatal(err)
+	}
+	if addr, ok := c.peer(MinChannelNumber, now); !ok || addr.String() != udpPeer.String() {
+		t.Errorf("unexpected peer %v", addr)
+	}
+	if n, ok := c.number(udpPeer, now); !ok || n != MinChannelNumber {
+		t.Errorf("unexpected number %v", n)
+	}
+	t.Run("Conflict", func(t *testing.T) {
+		if err := c.bind(MinChannelNumber+1, peer, now); err != errChannelConflict {
+			t.Errorf("peer rebound to other number: %v", err)
+		}
+		if err := c.bind(MinChannelNumber, Addr{IP: peer.IP, Port: 1001}, now); err != errChannelConflict {
+			t.Errorf("number rebound to other peer: %v", err)
+		}
+		other := Addr{IP: net.IPv4(10, 0, 0, 2), Port: 1000}
+		if err := c.bind(MinChannelNumber+1, other, now); err != nil {
+			t.Fatal(err)
+		}
+		if err := c.bind(MinChannelNumber+1, peer, now); err != errChannelConflict {
+			t.Errorf("unexpected error %v", err)
+		}
+	})
+	t.Run("Refresh", func(t *testing.T) {
+		if err := c.bind(MinChannelNumber, peer, now.Add(time.Minute)); err != nil {
+			t.Fatal(err)
+		}
+		if _, ok := c.peer(MinChannelNumber, now.Add(ChannelLifetime)); !ok {
+			t.Error("binding should be refreshed")
+		}
+	})
+	t.Run("Expire", func(t *testing.T) {
+		expired := now.Add(ChannelLifetime + time.Minute)
+		if _, ok := c.peer(MinChannelNumber, expired); ok {
+			t.Error("binding should expire after 600 seconds")
+		}
+		if _, ok := c.number(udpPeer, expired); ok {
+			t.Error("binding should expire after 600 seconds")
+		}
+		// Expired binding does not conflict.
+		if err := c.bind(MinChannelNumber, Addr{IP: peer.IP, Port: 1001}, expired); err != nil {
+			t.Error(err)
+		}
+		c.collect(expired)
+		if c.len() != 1 {
+			t.Errorf("unexpected length %d", c.len())
+		}
+	})
+}
+
+func TestChannelTable_Concurrent(t *testing.T) {
+	now := time.Now()
+	c := newChannelTable()
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			peer := Addr{IP: net.IPv4(10, 0, 0, byte(i)), Port: 1000}
+			n := MinChannelNumber + ChannelNumber(i)
+			for j := 0; j < 100; j++ {
+				if err := c.bind(n, peer, now); err != nil {
+					t.Error(err)
+					return
+				}
+				if _, ok := c.peer(n, now); !ok {
+					t.Error("not bound")
+					return
+				}
+				c.collect(now)
+			}
+		}(i)
+	}
+	wg.Wait()
+	if c.len() != 8 {
+		t.Errorf("unexpected length %d", c.len())
+	}
+}
+
+func BenchmarkChannelTable(b *testing.B) {
+	now := time.Now()
+	c := newChannelTable()
+	peer := &net.UDPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 1000}
+	if err := c.bind(MinChannelNumber, Addr{IP: peer.IP, Port: peer.Port}, now); err != nil {
+		b.Fatal(err)
+	}
+	b.Run("peer", func(b *testing.B) {
+		b.ReportAllocs()
+		b.RunParallel(func(pb *testing.PB) {
+			for pb.Next() {
+				if _, ok := c.peer(MinChannelNumber, now); !ok {
+					b.Fatal("not bound")
+				}
+			}
+		})
+	})
+	b.Run("number", func(b *testing.B) {
+		b.ReportAllocs()
+		b.RunParallel(func(pb *testing.PB) {
+			for pb.Next() {
+				if _, ok := c.number(peer, now); !ok {
+					b.Fatal("not bound")
+				}
+			}
+		})
+	})
+}
@@ -0,0 +1,477 @@
+package turn
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"gortc.io/stun"
+)
+
+// DefaultRTO is default retransmission timeout for requests over
+// unreliable transport as recommended by RFC 5389 Section 7.2.1.
+const DefaultRTO = time.Millisecond * 500
+
+// maxTransmissions is the Rc value from RFC 5389 Section 7.2.1.
+const maxTransmissions = 7
+
+// maxPacketSize is maximum size of UDP datagram or stream frame.
+const maxPacketSize = stunHeaderSize + 1<<16
+
+// maxAuthAttempts limits count of requests for single transaction that
+// are retried due to 401 Unauthorized or 438 Stale Nonce responses.
+const maxAuthAttempts = 3
+
+var (
+	// ErrClientClosed means that client is closed and no more requests
+	// can be performed.
+	ErrClientClosed = errors.New("client is closed")
+	// ErrTransactionTimeout means that no response was received
+	// after all retransmissions of request.
+	ErrTransactionTimeout = errors.New("transaction timed out")
+	// ErrAllocationExists means that allocation for client 5-tuple
+	// already exists.
+	ErrAllocationExists = errors.New("allocation already exists")
+	// ErrUnauthorized means that server rejected provided credentials.
+	ErrUnauthorized = errors.New("unauthorized")
+)
+
+// ResponseError is returned when server responds with error response.
+type ResponseError struct {
+	Type stun.MessageType
+	Code stun.ErrorCodeAttribute
+}
+
+func (e *ResponseError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Type, e.Code)
+}
+
+// ClientOptions contains options for NewClient.
+type ClientOptions struct {
+	Conn   net.PacketConn // required
+	Server net.Addr       // required, address of TURN server
+
+	// Long-term credentials. Client will perform requests without
+	// MESSAGE-INTEGRITY if Username is blank.
+	Username string
+	Password string
+
+	// OAuth credentials for third-party authorization as defined by
+	// RFC 7635. If set, Username and Password are ignored.
+	OAuth *OAuthCredentials
+
+	// Mobility enables requesting mobility ticket on Allocate, so
+	// allocation can be moved to new local address with Migrate.
+	// Allocation is created without mobility if server forbids it.
+	Mobility bool
+
+	// RTO is initial retransmission timeout, DefaultRTO if zero.
+	RTO time.Duration
+
+	// OnRefreshError is called in separate goroutine when automatic
+	// refresh fails. Optional. Allocation is already closed if err is
+	// ErrAllocationExpired, and it can be closed from callback.
+	OnRefreshError func(err error)
+
+	// DialData opens new data connection to server for TCP allocations
+	// as defined by RFC 6062. Set by Dial for TCP and TLS transports.
+	DialData func() (net.Conn, error)
+}
+
+// Client is TURN client that performs requests to single TURN server
+// over net.PacketConn.
+//
+// The Client owns the provided connection and closes it on Close.
+type Client struct {
+	server   net.Addr
+	rto      time.Duration
+	mobility bool
+
+	onRefreshError func(err error)
+	dialData       func() (net.Conn, error)
+
+	mux          sync.Mutex
+	conn         net.PacketConn // changed by Migrate
+	username     stun.Username
+	password     string
+	oauth        *OAuthCredentials
+	realm        stun.Realm
+	nonce        stun.Nonce
+	integrity    stun.MessageIntegrity
+	transactions map[transactionID]chan *stun.Message
+	alloc        *Allocation
+	allocating   bool // Allocate request is in progress
+
+	closeOnce sync.Once
+	closed    chan struct{}
+	done      chan struct{}
+}
+
+type transactionID [stun.TransactionIDSize]byte
+
+// NewClient creates and initializes new TURN client, starting
+// read loop on provided connection.
+func NewClient(o ClientOptions) (*Client, error) {
+	if o.Conn == nil {
+		return nil, errors.New("connection not provided")
+	}
+	if o.Server == nil {
+		return nil, errors.New("server address not provided")
+	}
+	if o.RTO == 0 {
+		o.RTO = DefaultRTO
+	}
+	c := &Client{
+		conn:           o.Conn,
+		server:         o.Server,
+		rto:            o.RTO,
+		mobility:       o.Mobility,
+		onRefreshError: o.OnRefreshError,
+		dialData:       o.DialData,
+		password:       o.Password,
+		oauth:          o.OAuth,
+		transactions:   make(map[transactionID]chan *stun.Message),
+		closed:         make(chan struct{}),
+		done:           make(chan struct{}),
+	}
+	if o.Username != "" {
+		c.username = stun.NewUsername(o.Username)
+	}
+	if o.OAuth != nil {
+		// USERNAME is added by OAuthCredentials.
+		c.username = stun.NewUsername(o.OAuth.KeyID)
+	}
+	go c.readUntilClosed(c.conn)
+	return c, nil
+}
+
+// Close stops client and closes underlying connection.
+func (c *Client) Close() error {
+	var err error
+	c.closeOnce.Do(func() {
+		close(c.closed)
+		err = c.connection().Close()
+		<-c.done
+	})
+	return err
+}
+
+// connection returns current connection to server.
+func (c *Client) connection() net.PacketConn {
+	c.mux.Lock()
+	conn := c.conn
+	c.mux.Unlock()
+	return conn
+}
+
+// readUntilClosed reads from conn until it is closed. The done channel
+// is closed only if conn is still current connection of client, so
+// loops of connections that were replaced by Migrate stop silently.
+func (c *Client) readUntilClosed(conn net.PacketConn) {
+	defer func() {
+		if c.connection() == conn {
+			close(c.done)
+		}
+	}()
+	buf := make([]byte, maxPacketSize)
+	for {
+		n, addr, err := conn.ReadFrom(buf)
+		if err != nil {
+			select {
+			case <-c.closed:
+				return
+			default:
+			}
+			if ne, ok := err.(net.Error); ok && ne.Temporary() {
+				continue
+			}
+			return
+		}
+		if addr.String() != c.server.String() {
+			// Ignoring packets not from the server.
+			continue
+		}
+		c.handle(buf[:n])
+	}
+}
+
+// allocation returns current allocation or nil.
+func (c *Client) allocation() *Allocation {
+	c.mux.Lock()
+	a := c.alloc
+	c.mux.Unlock()
+	return a
+}
+
+func (c *Client) handle(buf []byte) {
+	if IsChannelData(buf) {
+		if a := c.allocation(); a != nil {
+			a.handleChannelData(buf)
+		}
+		return
+	}
+	if !stun.IsMessage(buf) {
+		return
+	}
+	m := &stun.Message{
+		Raw: append([]byte(nil), buf...),
+	}
+	if err := m.Decode(); err != nil {
+		return
+	}
+	switch m.Type.Class {
+	case stun.ClassSuccessResponse, stun.ClassErrorResponse:
+		c.mux.Lock()
+		ch, ok := c.transactions[m.TransactionID]
+		c.mux.Unlock()
+		if !ok {
+			return
+		}
+		select {
+		case ch <- m:
+		default:
+			// Duplicate response.
+		}
+	case stun.ClassIndication:
+		a := c.allocation()
+		if a == nil {
+			return
+		}
+		switch m.Type.Method {
+		case stun.MethodData:
+			a.handleData(m)
+		case stun.MethodConnectionAttempt:
+			a.handleConnectionAttempt(m)
+		}
+	}
+}
+
+// do performs transaction, writing response to res.
+func (c *Client) do(req, res *stun.Message) error {
+	ch := make(chan *stun.Message, 1)
+	c.mux.Lock()
+	c.transactions[req.TransactionID] = ch
+	c.mux.Unlock()
+	defer func() {
+		c.mux.Lock()
+		delete(c.transactions, req.TransactionID)
+		c.mux.Unlock()
+	}()
+	rto, transmissions := c.rto, maxTransmissions
+	conn := c.connection()
+	if isReliable(conn) {
+		// No retransmissions over reliable transport.
+		rto, transmissions = reliableTimeout, 1
+	}
+	for i := 0; i < transmissions; i++ {
+		if _, err := conn.WriteTo(req.Raw, c.server); err != nil {
+			return err
+		}
+		timer := time.NewTimer(rto)
+		select {
+		case m := <-ch:
+			timer.Stop()
+			return m.CloneTo(res)
+		case <-c.closed:
+			timer.Stop()
+			return ErrClientClosed
+		case <-c.done:
+			// Read loop is stopped, so no responses can be received.
+			timer.Stop()
+			return ErrClientClosed
+		case <-timer.C:
+			rto *= 2
+		}
+	}
+	return ErrTransactionTimeout
+}
+
+// credentials returns setters for long-term credentials if they are
+// already known.
+func (c *Client) credentials() []stun.Setter {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	if c.integrity == nil {
+		return nil
+	}
+	if c.oauth != nil {
+		return []stun.Setter{*c.oauth, c.realm, c.nonce, c.integrity}
+	}
+	return []stun.Setter{c.username, c.realm, c.nonce, c.integrity}
+}
+
+// request performs request with provided type and attributes, handling
+// long-term credential challenge (401) and stale nonce (438) responses.
+//
+// Returns success response or error. Error responses are returned
+// as *ResponseError.
+func (c *Client) request(t stun.MessageType, setters ...stun.Setter) (*stun.Message, error) {
+	return c.requestWith(c.do, t, setters...)
+}
+
+// requestWith is request that uses do to perform transactions.
+func (c *Client) requestWith(
+	do func(req, res *stun.Message) error, t stun.MessageType, setters ...stun.Setter,
+) (*stun.Message, error) {
+	res := new(stun.Message)
+	for attempt := 0; attempt < maxAuthAttempts; attempt++ {
+		s := make([]stun.Setter, 0, len(setters)+6)
+		s = append(s, stun.TransactionID, t)
+		s = append(s, setters...)
+		creds := c.credentials()
+		s = append(s, creds...)
+		s = append(s, stun.Fingerprint)
+		req, err := stun.Build(s...)
+		if err != nil {
+			return nil, err
+		}
+		if err = do(req, res); err != nil {
+			return nil, err
+		}
+		if res.Type.Class != stun.ClassErrorResponse {
+			if creds != nil && res.Contains(stun.AttrMessageIntegrity) {
+				c.mux.Lock()
+				integrity := c.integrity
+				c.mux.Unlock()
+				if err = integrity.Check(res); err != nil {
+					return nil, err
+				}
+			}
+			return res, nil
+		}
+		var code stun.ErrorCodeAttribute
+		if err = code.GetFrom(res); err != nil {
+			return nil, err
+		}
+		switch code.Code {
+		case stun.CodeUnauthorized:
+			if creds != nil || len(c.username) == 0 {
+				// Credentials were rejected or not provided.
+				return nil, ErrUnauthorized
+			}
+			if err = c.challenge(res); err != nil {
+				return nil, err
+			}
+		case stun.CodeStaleNonce:
+			if err = c.updateNonce(res); err != nil {
+				return nil, err
+			}
+		default:
+			return nil, &ResponseError{Type: res.Type, Code: code}
+		}
+	}
+	return nil, ErrUnauthorized
+}
+
+// challenge handles 401 response, setting realm, nonce and integrity.
+func (c *Client) challenge(res *stun.Message) error {
+	var (
+		realm stun.Realm
+		nonce stun.Nonce
+	)
+	if err := res.Parse(&realm, &nonce); err != nil {
+		return err
+	}
+	c.mux.Lock()
+	// Copying, because attributes are sub-slices of res.Raw that is
+	// reused by subsequent transactions.
+	c.realm = append(stun.Realm(nil), realm...)
+	c.nonce = append(stun.Nonce(nil), nonce...)
+	if c.oauth != nil {
+		c.integrity = c.oauth.Integrity()
+	} else {
+		c.integrity = stun.NewLongTermIntegrity(c.username.String(), realm.String(), c.password)
+	}
+	c.mux.Unlock()
+	return nil
+}
+
+// updateNonce handles 438 response, updating nonce.
+func (c *Client) updateNonce(res *stun.Message) error {
+	var nonce stun.Nonce
+	if err := nonce.GetFrom(res); err != nil {
+		return err
+	}
+	c.mux.Lock()
+	c.nonce = append(stun.Nonce(nil), nonce...)
+	c.mux.Unlock()
+	return nil
+}
+
+// Allocate performs Allocate request, creating allocation on server
+// for the client 5-tuple.
+//
+// Only one allocation can exist per client.
+func (c *Client) Allocate() (*Allocation, error) {
+	return c.allocate(RequestedTransport{Protocol: ProtoUDP})
+}
+
+// AllocateDualStack performs Allocate request with ADDITIONAL-ADDRESS-FAMILY,
+// requesting both IPv4 and IPv6 relayed addresses. If server allocated only
+// IPv4 address, the AddressError field of allocation describes the reason.
+//
+// RFC 8656 Section 7.1
+func (c *Client) AllocateDualStack() (*Allocation, error) {
+	return c.allocate(RequestedTransport{Protocol: ProtoUDP},
+		AdditionalAddressFamily(RequestedFamilyIPv6),
+	)
+}
+
+func (c *Client) allocate(transport RequestedTransport, setters ...stun.Setter) (*Allocation, error) {
+	// Reserving allocation, so concurrent calls fail instead of
+	// performing another Allocate request.
+	c.mux.Lock()
+	if c.alloc != nil || c.allocating {
+		c.mux.Unlock()
+		return nil, ErrAllocationExists
+	}
+	c.allocating = true
+	c.mux.Unlock()
+	defer func() {
+		c.mux.Lock()
+		c.allocating = false
+		c.mux.Unlock()
+	}()
+	setters = append([]stun.Setter{transport}, setters...)
+	var (
+		res *stun.Message
+		err error
+	)
+	if c.mobility {
+		// Empty MOBILITY-TICKET requests mobility, RFC 8016 Section 3.1.
+		res, err = c.request(AllocateRequest, append(setters, MobilityTicket{})...)
+		if e, ok := err.(*ResponseError); ok && e.Code.Code == CodeMobilityForbidden {
+			res, err = c.request(AllocateRequest, setters...)
+		}
+	} else {
+		res, err = c.request(AllocateRequest, setters...)
+	}
+	if err != nil {
+		return nil, err
+	}
+	a := newAllocation(c)
+	if transport.Protocol == ProtoTCP {
+		a.attempts = make(chan connectionAttempt, allocationQueueSize)
+	}
+	var relayed RelayedAddresses
+	if err = res.Parse(&relayed, &a.Lifetime); err != nil {
+		return nil, err
+	}
+	a.Relayed = relayed[0]
+	if len(relayed) > 1 {
+		a.AdditionalRelayed = relayed[1]
+	}
+	// ADDRESS-ERROR-CODE is present only if some family was not allocated.
+	_ = a.AddressError.GetFrom(res)
+	a.updateTicket(res)
+	// XOR-MAPPED-ADDRESS is optional.
+	_ = a.Reflexive.GetFrom(res)
+	a.setLifetime(time.Now(), a.Lifetime)
+	c.mux.Lock()
+	c.alloc = a
+	c.mux.Unlock()
+	go a.refreshUntilClosed()
+	return a, nil
+}
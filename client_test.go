@@ -0,0 +1,408 @@
+package turn
+
+import (
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"gortc.io/stun"
+)
+
+// fakeServer is in-process TURN server stub that calls handler
+// on every received STUN message and writes returned message back.
+type fakeServer struct {
+	t       testing.TB
+	conn    net.PacketConn
+	handler func(req *stun.Message, addr net.Addr) *stun.Message
+	wg      sync.WaitGroup
+
+	mux sync.Mutex
+	raw func(buf []byte, addr net.Addr) // called for non-STUN packets
+}
+
+func newFakeServer(t testing.TB, handler func(req *stun.Message, addr net.Addr) *stun.Message) *fakeServer {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &fakeServer{
+		t:       t,
+		conn:    conn,
+		handler: handler,
+	}
+	s.wg.Add(1)
+	go s.serve()
+	return s
+}
+
+func (s *fakeServer) serve() {
+	defer s.wg.Done()
+	buf := make([]byte, 2048)
+	for {
+		n, addr, err := s.conn.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		if !stun.IsMessage(buf[:n]) {
+			s.mux.Lock()
+			raw := s.raw
+			s.mux.Unlock()
+			if raw != nil {
+				raw(buf[:n], addr)
+			}
+			continue
+		}
+		req := &stun.Message{Raw: append([]byte(nil), buf[:n]...)}
+		if err = req.Decode(); err != nil {
+			s.t.Error(err)
+			continue
+		}
+		res := s.handler(req, addr)
+		if res == nil {
+			continue
+		}
+		if _, err = s.conn.WriteTo(res.Raw, addr); err != nil {
+			s.t.Error(err)
+		}
+	}
+}
+
+func (s *fakeServer) setRaw(raw func(buf []byte, addr net.Addr)) {
+	s.mux.Lock()
+	s.raw = raw
+	s.mux.Unlock()
+}
+
+func (s *fakeServer) send(m []byte, addr net.Addr) {
+	if _, err := s.conn.WriteTo(m, addr); err != nil {
+		s.t.Fatal(err)
+	}
+}
+
+func (s *fakeServer) Close() {
+	if err := s.conn.Close(); err != nil {
+		s.t.Error(err)
+	}
+	s.wg.Wait()
+}
+
+// newTestClient returns client that is connected to server.
+func newTestClient(t testing.TB, s *fakeServer) *Client {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := NewClient(ClientOptions{
+		Conn:     conn,
+		Server:   s.conn.LocalAddr(),
+		Username: "user",
+		Password: "secret",
+		RTO:      time.Millisecond * 50,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+const (
+	testRealm = "realm"
+	testNonce = "nonce"
+)
+
+var testIntegrity = stun.NewLongTermIntegrity("user", testRealm, "secret")
+
+// authenticate checks long-term credentials in request and
+// returns error response if they are not valid.
+func authenticate(t testing.TB, req *stun.Message) *stun.Message {
+	if !req.Contains(stun.AttrMessageIntegrity) {
+		return stun.MustBuild(req, stun.NewType(req.Type.Method, stun.ClassErrorResponse),
+			stun.CodeUnauthorized, stun.NewRealm(testRealm), stun.NewNonce(testNonce),
+		)
+	}
+	var nonce stun.Nonce
+	if err := nonce.GetFrom(req); err != nil {
+		t.Error(err)
+	}
+	if nonce.String() != testNonce {
+		return stun.MustBuild(req, stun.NewType(req.Type.Method, stun.ClassErrorResponse),
+			stun.CodeStaleNonce, stun.NewNonce(testNonce),
+		)
+	}
+	if err := testIntegrity.Check(req); err != nil {
+		t.Error(err)
+	}
+	return nil
+}
+
+// allocateHandler handles Allocate requests with authentication.
+func allocateHandler(t testing.TB, req *stun.Message, addr net.Addr) *stun.Message {
+	if res := authenticate(t, req); res != nil {
+		return res
+	}
+	if req.Type != AllocateRequest {
+		return stun.MustBuild(req, stun.NewType(req.Type.Method, stun.ClassSuccessResponse),
+			testIntegrity, stun.Fingerprint,
+		)
+	}
+	var transport RequestedTransport
+	if err := transport.GetFrom(req); err != nil {
+		t.Error(err)
+	}
+	if transport.Protocol != ProtoUDP {
+		t.Errorf("unexpected protocol %s", transport.Protocol)
+	}
+	udpAddr := addr.(*net.UDPAddr)
+	return stun.MustBuild(req, stun.NewType(stun.MethodAllocate, stun.ClassSuccessResponse),
+		RelayedAddress{IP: net.IPv4(10, 0, 0, 1), Port: 5000},
+		&stun.XORMappedAddress{IP: udpAddr.IP, Port: udpAddr.Port},
+		Lifetime{time.Minute * 5},
+		testIntegrity, stun.Fingerprint,
+	)
+}
+
+func TestClient_Allocate(t *testing.T) {
+	var requests int32
+	s := newFakeServer(t, func(req *stun.Message, addr net.Addr) *stun.Message {
+		atomic.AddInt32(&requests, 1)
+		return allocateHandler(t, req, addr)
+	})
+	defer s.Close()
+	c := newTestClient(t, s)
+	defer c.Close()
+	a, err := c.Allocate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := atomic.LoadInt32(&requests); n < 2 {
+		t.Errorf("unexpected requests count: %d", n)
+	}
+	if !a.Relayed.IP.Equal(net.IPv4(10, 0, 0, 1)) || a.Relayed.Port != 5000 {
+		t.Errorf("unexpected relayed address %s", a.Relayed)
+	}
+	if a.Lifetime.Duration != time.Minute*5 {
+		t.Errorf("unexpected lifetime %s", a.Lifetime)
+	}
+	if a.Reflexive.Port != c.conn.LocalAddr().(*net.UDPAddr).Port {
+		t.Errorf("unexpected reflexive address %s", a.Reflexive)
+	}
+	if _, err = c.Allocate(); err != ErrAllocationExists {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestClient_Allocate_Concurrent(t *testing.T) {
+	var (
+		received = make(chan struct{}, 1)
+		release  = make(chan struct{})
+		requests int32
+	)
+	s := newFakeServer(t, func(req *stun.Message, addr net.Addr) *stun.Message {
+		if req.Contains(stun.AttrMessageIntegrity) && atomic.AddInt32(&requests, 1) == 1 {
+			// Holding response until concurrent call is done.
+			received <- struct{}{}
+			<-release
+		}
+		return allocateHandler(t, req, addr)
+	})
+	defer s.Close()
+	c := newTestClient(t, s)
+	defer c.Close()
+	done := make(chan error, 1)
+	go func() {
+		_, err := c.Allocate()
+		done <- err
+	}()
+	<-received
+	if _, err := c.Allocate(); err != ErrAllocationExists {
+		t.Errorf("unexpected error: %v", err)
+	}
+	close(release)
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestClient_Allocate_StaleNonce(t *testing.T) {
+	var stale int32
+	s := newFakeServer(t, func(req *stun.Message, addr net.Addr) *stun.Message {
+		if req.Contains(stun.AttrMessageIntegrity) && atomic.CompareAndSwapInt32(&stale, 0, 1) {
+			return stun.MustBuild(req, stun.NewType(req.Type.Method, stun.ClassErrorResponse),
+				stun.CodeStaleNonce, stun.NewNonce(testNonce),
+			)
+		}
+		return allocateHandler(t, req, addr)
+	})
+	defer s.Close()
+	c := newTestClient(t, s)
+	defer c.Close()
+	if _, err := c.Allocate(); err != nil {
+		t.Fatal(err)
+	}
+	if atomic.LoadInt32(&stale) == 0 {
+		t.Error("stale nonce not returned")
+	}
+}
+
+func TestClient_request_Nonce(t *testing.T) {
+	// Nonce from 401 response should be used by subsequent transactions
+	// as is, without 438 (Stale Nonce) round trip.
+	s := newFakeServer(t, func(req *stun.Message, addr net.Addr) *stun.Message {
+		if !req.Contains(stun.AttrMessageIntegrity) {
+			// Padding, so success response fits into the same buffer
+			// and overwrites nonce.
+			return stun.MustBuild(req, stun.NewType(req.Type.Method, stun.ClassErrorResponse),
+				stun.CodeUnauthorized, stun.NewRealm(testRealm), stun.NewNonce(testNonce),
+				stun.NewSoftware(strings.Repeat("a", 128)),
+			)
+		}
+		var nonce stun.Nonce
+		if err := nonce.GetFrom(req); err != nil || nonce.String() != testNonce {
+			t.Errorf("unexpected nonce %q in %s", nonce, req.Type)
+		}
+		return stun.MustBuild(req, stun.NewType(req.Type.Method, stun.ClassSuccessResponse),
+			stun.NewSoftware(strings.Repeat("b", 64)), testIntegrity, stun.Fingerprint,
+		)
+	})
+	defer s.Close()
+	c := newTestClient(t, s)
+	defer c.Close()
+	for i := 0; i < 2; i++ {
+		if _, err := c.request(RefreshRequest); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestClient_Allocate_Errors(t *testing.T) {
+	t.Run("Unauthorized", func(t *testing.T) {
+		s := newFakeServer(t, func(req *stun.Message, addr net.Addr) *stun.Message {
+			return stun.MustBuild(req, stun.NewType(req.Type.Method, stun.ClassErrorResponse),
+				stun.CodeUnauthorized, stun.NewRealm(testRealm), stun.NewNonce(testNonce),
+			)
+		})
+		defer s.Close()
+		c := newTestClient(t, s)
+		defer c.Close()
+		if _, err := c.Allocate(); err != ErrUnauthorized {
+			t.Errorf("unexpected error: %v", err)
+		}
+	})
+	t.Run("Response", func(t *testing.T) {
+		s := newFakeServer(t, func(req *stun.Message, addr net.Addr) *stun.Message {
+			return stun.MustBuild(req, stun.NewType(req.Type.Method, stun.ClassErrorResponse),
+				stun.CodeAllocQuotaReached,
+			)
+		})
+		defer s.Close()
+		c := newTestClient(t, s)
+		defer c.Close()
+		_, err := c.Allocate()
+		resErr, ok := err.(*ResponseError)
+		if !ok {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if resErr.Code.Code != stun.CodeAllocQuotaReached {
+			t.Errorf("unexpected code: %v", resErr.Code)
+		}
+		if resErr.Error() == "" {
+			t.Error("blank error string")
+		}
+	})
+	t.Run("Timeout", func(t *testing.T) {
+		s := newFakeServer(t, func(req *stun.Message, addr net.Addr) *stun.Message {
+			return nil
+		})
+		defer s.Close()
+		c := newTestClient(t, s)
+		c.rto = time.Millisecond
+		defer c.Close()
+		if _, err := c.Allocate(); err != ErrTransactionTimeout {
+			t.Errorf("unexpected error: %v", err)
+		}
+	})
+}
+
+func TestNewClient(t *testing.T) {
+	if _, err := NewClient(ClientOptions{}); err == nil {
+		t.Error("should error")
+	}
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if _, err := NewClient(ClientOptions{Conn: conn}); err == nil {
+		t.Error("should error")
+	}
+}
+
+func TestClient_AllocateDualStack(t *testing.T) {
+	t.Run("Both", func(t *testing.T) {
+		s := newFakeServer(t, func(req *stun.Message, addr net.Addr) *stun.Message {
+			if res := authenticate(t, req); res != nil {
+				return res
+			}
+			var family AdditionalAddressFamily
+			if err := family.GetFrom(req); err != nil || family != AdditionalAddressFamily(RequestedFamilyIPv6) {
+				t.Errorf("unexpected family %s: %v", family, err)
+			}
+			return stun.MustBuild(req, stun.NewType(stun.MethodAllocate, stun.ClassSuccessResponse),
+				RelayedAddresses{
+					{IP: net.IPv4(10, 0, 0, 1), Port: 5000},
+					{IP: net.ParseIP("2001:db8::1"), Port: 5001},
+				},
+				Lifetime{time.Minute * 5},
+				testIntegrity, stun.Fingerprint,
+			)
+		})
+		defer s.Close()
+		c := newTestClient(t, s)
+		defer c.Close()
+		a, err := c.AllocateDualStack()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if a.Relayed.String() != "10.0.0.1:5000" {
+			t.Errorf("unexpected relayed address %s", a.Relayed)
+		}
+		if a.AdditionalRelayed.Port != 5001 || !a.AdditionalRelayed.IP.Equal(net.ParseIP("2001:db8::1")) {
+			t.Errorf("unexpected additional relayed address %s", a.AdditionalRelayed)
+		}
+		if a.AddressError.Code != 0 {
+			t.Errorf("unexpected address error %s", a.AddressError)
+		}
+	})
+	t.Run("AddressError", func(t *testing.T) {
+		s := newFakeServer(t, func(req *stun.Message, addr net.Addr) *stun.Message {
+			if res := authenticate(t, req); res != nil {
+				return res
+			}
+			return stun.MustBuild(req, stun.NewType(stun.MethodAllocate, stun.ClassSuccessResponse),
+				RelayedAddress{IP: net.IPv4(10, 0, 0, 1), Port: 5000},
+				AddressErrorCode{
+					Family: RequestedFamilyIPv6,
+					Code:   stun.CodeAddrFamilyNotSupported,
+				},
+				Lifetime{time.Minute * 5},
+				testIntegrity, stun.Fingerprint,
+			)
+		})
+		defer s.Close()
+		c := newTestClient(t, s)
+		defer c.Close()
+		a, err := c.AllocateDualStack()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if a.AdditionalRelayed.IP != nil {
+			t.Errorf("unexpected additional relayed address %s", a.AdditionalRelayed)
+		}
+		if a.AddressError.Family != RequestedFamilyIPv6 || a.AddressError.Code != stun.CodeAddrFamilyNotSupported {
+			t.Errorf("unexpected address error %s", a.AddressError)
+		}
+	})
+}
@@ -0,0 +1,99 @@
+package turn
+
+import (
+	"testing"
+
+	"gortc.io/stun"
+)
+
+func BenchmarkConnectionID(b *testing.B) {
+	b.Run("AddTo", func(b *testing.B) {
+		b.ReportAllocs()
+		m := new(stun.Message)
+		for i := 0; i < b.N; i++ {
+			c := ConnectionID(12)
+			if err := c.AddTo(m); err != nil {
+				b.Fatal(err)
+			}
+			m.Reset()
+		}
+	})
+	b.Run("GetFrom", func(b *testing.B) {
+		m := new(stun.Message)
+		ConnectionID(12).AddTo(m)
+		for i := 0; i < b.N; i++ {
+			var c ConnectionID
+			if err := c.GetFrom(m); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+func TestConnectionID(t *testing.T) {
+	t.Run("String", func(t *testing.T) {
+		c := ConnectionID(112)
+		if c.String() != "112" {
+			t.Errorf("bad string %s, expected 112", c.String())
+		}
+	})
+	t.Run("NoAlloc", func(t *testing.T) {
+		m := &stun.Message{}
+		if wasAllocs(func() {
+			// On stack.
+			c := ConnectionID(6)
+			c.AddTo(m)
+			m.Reset()
+		}) {
+			t.Error("Unexpected allocations")
+		}
+
+		c := ConnectionID(12)
+		cP := &c
+		if wasAllocs(func() {
+			// On heap.
+			cP.AddTo(m)
+			m.Reset()
+		}) {
+			t.Error("Unexpected allocations")
+		}
+	})
+	t.Run("AddTo", func(t *testing.T) {
+		m := new(stun.Message)
+		c := ConnectionID(0xdeadbeef)
+		if err := c.AddTo(m); err != nil {
+			t.Error(err)
+		}
+		m.WriteHeader()
+		t.Run("GetFrom", func(t *testing.T) {
+			decoded := new(stun.Message)
+			if _, err := decoded.Write(m.Raw); err != nil {
+				t.Fatal("failed to decode message:", err)
+			}
+			var cDecoded ConnectionID
+			if err := cDecoded.GetFrom(decoded); err != nil {
+				t.Fatal(err)
+			}
+			if cDecoded != c {
+				t.Errorf("Decoded %d, expected %d", cDecoded, c)
+			}
+			if wasAllocs(func() {
+				var id ConnectionID
+				id.GetFrom(decoded)
+			}) {
+				t.Error("Unexpected allocations")
+			}
+			t.Run("HandleErr", func(t *testing.T) {
+				m := new(stun.Message)
+				cHandle := new(ConnectionID)
+				if err := cHandle.GetFrom(m); err != stun.ErrAttributeNotFound {
+					t.Errorf("%v should be not found", err)
+				}
+				m.Add(stun.AttrConnectionID, []byte{1, 2, 3})
+				if !stun.IsAttrSizeInvalid(cHandle.GetFrom(m)) {
+					t.Error("IsAttrSizeInvalid should be true")
+				}
+			})
+		})
+	})
+}
@@ -0,0 +1,80 @@
+package turn
+
+import (
+	"sync"
+	"time"
+)
+
+// deadline implements deadline for blocking operations like
+// net.Conn read or write deadline.
+type deadline struct {
+	mux   sync.Mutex
+	timer *time.Timer
+	gen   int
+	ch    chan struct{} // closed when deadline is exceeded
+}
+
+func newDeadline() *deadline {
+	return &deadline{
+		ch: make(chan struct{}),
+	}
+}
+
+// set sets deadline to t, zero value means no deadline.
+func (d *deadline) set(t time.Time) {
+	d.mux.Lock()
+	defer d.mux.Unlock()
+	d.gen++
+	if d.timer != nil {
+		d.timer.Stop()
+		d.timer = nil
+	}
+	select {
+	case <-d.ch:
+		// Deadline was exceeded, re-arming.
+		d.ch = make(chan struct{})
+	default:
+	}
+	if t.IsZero() {
+		return
+	}
+	dur := time.Until(t)
+	if dur <= 0 {
+		close(d.ch)
+		return
+	}
+	gen := d.gen
+	d.timer = time.AfterFunc(dur, func() {
+		d.mux.Lock()
+		defer d.mux.Unlock()
+		if d.gen != gen {
+			// Deadline was changed.
+			return
+		}
+		close(d.ch)
+	})
+}
+
+// done returns channel that is closed when deadline is exceeded.
+func (d *deadline) done() <-chan struct{} {
+	d.mux.Lock()
+	defer d.mux.Unlock()
+	return d.ch
+}
+
+// exceeded returns true if deadline is exceeded.
+func (d *deadline) exceeded() bool {
+	select {
+	case <-d.done():
+		return true
+	default:
+		return false
+	}
+}
+
+// timeoutError implements net.Error for exceeded deadlines.
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
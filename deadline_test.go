@@ -0,0 +1,34 @@
+package turn
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDeadline(t *testing.T) {
+	d := newDeadline()
+	if d.exceeded() {
+		t.Error("should not be exceeded without deadline")
+	}
+	d.set(time.Now().Add(-time.Second))
+	if !d.exceeded() {
+		t.Error("should be exceeded")
+	}
+	d.set(time.Now().Add(time.Hour))
+	if d.exceeded() {
+		t.Error("should be re-armed")
+	}
+	d.set(time.Now().Add(time.Millisecond))
+	select {
+	case <-d.done():
+	case <-time.After(time.Second):
+		t.Error("deadline not exceeded")
+	}
+	d.set(time.Time{})
+	if d.exceeded() {
+		t.Error("should not be exceeded after reset")
+	}
+	if err := (timeoutError{}); !err.Timeout() || !err.Temporary() || err.Error() == "" {
+		t.Error("bad timeout error")
+	}
+}
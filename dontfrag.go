@@ -1,6 +1,10 @@
 package turn
 
-import "gortc.io/stun"
+import (
+	"errors"
+
+	"gortc.io/stun"
+)
 
 // DontFragmentAttr represents DONT-FRAGMENT attribute.
 type DontFragmentAttr struct{}
@@ -19,3 +23,6 @@ func (DontFragmentAttr) IsSet(m *stun.Message) bool {
 
 // DontFragment is shorthand for DontFragmentAttr.
 var DontFragment DontFragmentAttr
+
+// errDontFragmentUnsupported means that DF bit can't be set on socket.
+var errDontFragmentUnsupported = errors.New("setting DF bit is not supported")
@@ -0,0 +1,38 @@
+package turn
+
+import (
+	"net"
+	"syscall"
+)
+
+// supportsDontFragment is true if server can set DF bit on data that is
+// relayed to peer.
+const supportsDontFragment = true
+
+// setDontFragment enables or disables DF bit on packets sent from conn.
+// When disabled, kernel default path MTU discovery mode is restored.
+func setDontFragment(conn net.PacketConn, ipv6 bool, enabled bool) error {
+	sc, ok := conn.(syscall.Conn)
+	if !ok {
+		return errDontFragmentUnsupported
+	}
+	raw, err := sc.SyscallConn()
+	if err != nil {
+		return err
+	}
+	level, opt := syscall.IPPROTO_IP, syscall.IP_MTU_DISCOVER
+	if ipv6 {
+		level, opt = syscall.IPPROTO_IPV6, syscall.IPV6_MTU_DISCOVER
+	}
+	mode := syscall.IP_PMTUDISC_WANT
+	if enabled {
+		mode = syscall.IP_PMTUDISC_DO
+	}
+	var sockErr error
+	if err = raw.Control(func(fd uintptr) {
+		sockErr = syscall.SetsockoptInt(int(fd), level, opt, mode)
+	}); err != nil {
+		return err
+	}
+	return sockErr
+}
@@ -0,0 +1,61 @@
+package turn
+
+import (
+	"net"
+	"syscall"
+	"testing"
+)
+
+func TestSetDontFragment(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		network string
+		addr    string
+		ipv6    bool
+	}{
+		{name: "IPv4", network: "udp4", addr: "127.0.0.1:0"},
+		{name: "IPv6", network: "udp6", addr: "[::1]:0", ipv6: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			conn, err := net.ListenPacket(tc.network, tc.addr)
+			if err != nil {
+				t.Skip(err)
+			}
+			defer conn.Close()
+			level, opt := syscall.IPPROTO_IP, syscall.IP_MTU_DISCOVER
+			if tc.ipv6 {
+				level, opt = syscall.IPPROTO_IPV6, syscall.IPV6_MTU_DISCOVER
+			}
+			mode := func() int {
+				raw, rawErr := conn.(*net.UDPConn).SyscallConn()
+				if rawErr != nil {
+					t.Fatal(rawErr)
+				}
+				var v int
+				if rawErr = raw.Control(func(fd uintptr) {
+					v, rawErr = syscall.GetsockoptInt(int(fd), level, opt)
+				}); rawErr != nil {
+					t.Fatal(rawErr)
+				}
+				return v
+			}
+			if err = setDontFragment(conn, tc.ipv6, true); err != nil {
+				t.Fatal(err)
+			}
+			if v := mode(); v != syscall.IP_PMTUDISC_DO {
+				t.Errorf("unexpected mode %d", v)
+			}
+			if err = setDontFragment(conn, tc.ipv6, false); err != nil {
+				t.Fatal(err)
+			}
+			if v := mode(); v != syscall.IP_PMTUDISC_WANT {
+				t.Errorf("unexpected mode %d", v)
+			}
+		})
+	}
+	t.Run("Unsupported", func(t *testing.T) {
+		if err := setDontFragment(nil, false, true); err != errDontFragmentUnsupported {
+			t.Errorf("unexpected error %v", err)
+		}
+	})
+}
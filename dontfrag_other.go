@@ -0,0 +1,15 @@
+//go:build !linux
+// +build !linux
+
+package turn
+
+import "net"
+
+// supportsDontFragment is true if server can set DF bit on data that is
+// relayed to peer.
+const supportsDontFragment = false
+
+// setDontFragment enables or disables DF bit on packets sent from conn.
+func setDontFragment(conn net.PacketConn, ipv6 bool, enabled bool) error {
+	return errDontFragmentUnsupported
+}
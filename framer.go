@@ -0,0 +1,144 @@
+package turn
+
+import (
+	"io"
+
+	"gortc.io/stun"
+)
+
+// FrameType is type of frame in stream.
+type FrameType byte
+
+// Possible frame types.
+const (
+	FrameSTUN        FrameType = iota + 1 // STUN message
+	FrameChannelData                      // ChannelData message
+)
+
+func (t FrameType) String() string {
+	switch t {
+	case FrameSTUN:
+		return "STUN"
+	case FrameChannelData:
+		return "ChannelData"
+	default:
+		return "unknown"
+	}
+}
+
+// DefaultFrameBufferSize fits any STUN or ChannelData message.
+const DefaultFrameBufferSize = stunHeaderSize + 1<<16
+
+// FrameReader reads STUN and ChannelData messages from stream one frame
+// at a time, using fixed-size buffer.
+//
+// Over stream transports ChannelData messages are padded to multiple of
+// four bytes, while STUN messages are always multiple of four bytes.
+//
+// RFC 5766 Section 11.5
+type FrameReader struct {
+	r     io.Reader
+	buf   []byte
+	start int // start of unread data in buf
+	end   int // end of unread data in buf
+	frame []byte
+	t     FrameType
+}
+
+// NewFrameReader returns FrameReader with buffer of provided size,
+// DefaultFrameBufferSize is used if size is zero. Frames that do not
+// fit into buffer can't be read.
+func NewFrameReader(r io.Reader, size int) *FrameReader {
+	if size <= 0 {
+		size = DefaultFrameBufferSize
+	}
+	return &FrameReader{
+		r:   r,
+		buf: make([]byte, size),
+	}
+}
+
+// fill reads from underlying reader until at least n bytes are buffered.
+func (f *FrameReader) fill(n int) error {
+	if f.end-f.start >= n {
+		return nil
+	}
+	if f.start+n > len(f.buf) {
+		// Moving unread data to the beginning of buffer.
+		f.end = copy(f.buf, f.buf[f.start:f.end])
+		f.start = 0
+	}
+	for f.end-f.start < n {
+		read, err := f.r.Read(f.buf[f.end:])
+		f.end += read
+		if err != nil {
+			if f.end-f.start >= n {
+				return nil
+			}
+			if err == io.EOF && f.end-f.start > 0 {
+				return io.ErrUnexpectedEOF
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// Next reads next frame from stream and returns its type. The frame
+// is valid until next call of Next.
+func (f *FrameReader) Next() (FrameType, error) {
+	f.frame, f.t = nil, 0
+	if err := f.fill(channelDataHeaderSize); err != nil {
+		return 0, err
+	}
+	header := f.buf[f.start : f.start+channelDataHeaderSize]
+	length := int(bin.Uint16(header[channelDataNumberSize:channelDataHeaderSize]))
+	var (
+		size int
+		t    FrameType
+	)
+	switch {
+	case IsChannelData(header):
+		size, t = nearestPaddedValueLength(channelDataHeaderSize+length), FrameChannelData
+	case header[0]>>6 == 0:
+		// Two most significant bits of STUN message are zeroes.
+		size, t = stunHeaderSize+length, FrameSTUN
+	default:
+		return 0, ErrInvalidFrame
+	}
+	if size > len(f.buf) {
+		return 0, ErrFrameTooBig
+	}
+	if err := f.fill(size); err != nil {
+		return 0, err
+	}
+	f.frame = f.buf[f.start : f.start+size]
+	f.start += size
+	f.t = t
+	return t, nil
+}
+
+// buffered returns data that was read from underlying reader after
+// current frame.
+func (f *FrameReader) buffered() []byte { return f.buf[f.start:f.end] }
+
+// Bytes returns current frame. The ChannelData frame includes padding.
+func (f *FrameReader) Bytes() []byte { return f.frame }
+
+// Message decodes current STUN frame to m, reusing m.Raw.
+func (f *FrameReader) Message(m *stun.Message) error {
+	if f.t != FrameSTUN {
+		return ErrInvalidFrame
+	}
+	m.Raw = append(m.Raw[:0], f.frame...)
+	return m.Decode()
+}
+
+// ChannelData decodes current ChannelData frame to d, reusing d.Raw.
+func (f *FrameReader) ChannelData(d *ChannelData) error {
+	if f.t != FrameChannelData {
+		return ErrInvalidFrame
+	}
+	d.Raw = append(d.Raw[:0], f.frame...)
+	return d.Decode()
+}
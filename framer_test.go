@@ -0,0 +1,184 @@
+package turn
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	"gortc.io/stun"
+)
+
+// loopReader infinitely reads data.
+type loopReader struct {
+	data []byte
+	off  int
+}
+
+func (r *loopReader) Read(b []byte) (int, error) {
+	n := copy(b, r.data[r.off:])
+	r.off = (r.off + n) % len(r.data)
+	return n, nil
+}
+
+// oneByteReader reads single byte at time.
+type oneByteReader struct {
+	r io.Reader
+}
+
+func (r oneByteReader) Read(b []byte) (int, error) {
+	if len(b) == 0 {
+		return 0, nil
+	}
+	return r.r.Read(b[:1])
+}
+
+func testStream() (stream []byte, m *stun.Message, d *ChannelData) {
+	m = stun.MustBuild(stun.TransactionID, AllocateRequest, RequestedTransportUDP)
+	d = &ChannelData{
+		Number:  MinChannelNumber + 1,
+		Data:    []byte{1, 2, 3, 4, 5, 6},
+		Padding: true,
+	}
+	d.Encode()
+	stream = append(stream, d.Raw...)
+	stream = append(stream, m.Raw...)
+	return stream, m, d
+}
+
+func TestFrameReader(t *testing.T) {
+	stream, m, d := testStream()
+	for _, tc := range []struct {
+		name string
+		r    io.Reader
+	}{
+		{"Whole", bytes.NewReader(stream)},
+		{"ByteByByte", oneByteReader{bytes.NewReader(stream)}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			f := NewFrameReader(tc.r, 32)
+			ft, err := f.Next()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if ft != FrameChannelData {
+				t.Fatalf("unexpected frame type %s", ft)
+			}
+			if len(f.Bytes()) != 12 {
+				t.Errorf("unexpected frame length %d", len(f.Bytes()))
+			}
+			if err = f.Message(new(stun.Message)); err != ErrInvalidFrame {
+				t.Errorf("unexpected error %v", err)
+			}
+			decoded := new(ChannelData)
+			if err = f.ChannelData(decoded); err != nil {
+				t.Fatal(err)
+			}
+			if !decoded.Equal(d) {
+				t.Error("ChannelData not equal")
+			}
+			if ft, err = f.Next(); err != nil {
+				t.Fatal(err)
+			}
+			if ft != FrameSTUN {
+				t.Fatalf("unexpected frame type %s", ft)
+			}
+			if err = f.ChannelData(decoded); err != ErrInvalidFrame {
+				t.Errorf("unexpected error %v", err)
+			}
+			mDecoded := new(stun.Message)
+			if err = f.Message(mDecoded); err != nil {
+				t.Fatal(err)
+			}
+			if !mDecoded.Equal(m) {
+				t.Error("message not equal")
+			}
+			if _, err = f.Next(); err != io.EOF {
+				t.Errorf("unexpected error %v", err)
+			}
+		})
+	}
+	t.Run("TooBig", func(t *testing.T) {
+		f := NewFrameReader(bytes.NewReader(stream), 8)
+		if _, err := f.Next(); err != ErrFrameTooBig {
+			t.Errorf("unexpected error %v", err)
+		}
+	})
+	t.Run("Invalid", func(t *testing.T) {
+		f := NewFrameReader(bytes.NewReader([]byte{0xff, 0, 0, 0}), 0)
+		if _, err := f.Next(); err != ErrInvalidFrame {
+			t.Errorf("unexpected error %v", err)
+		}
+	})
+	t.Run("UnexpectedEOF", func(t *testing.T) {
+		f := NewFrameReader(bytes.NewReader(stream[:10]), 0)
+		if _, err := f.Next(); err != io.ErrUnexpectedEOF {
+			t.Errorf("unexpected error %v", err)
+		}
+	})
+	t.Run("NoAlloc", func(t *testing.T) {
+		f := NewFrameReader(&loopReader{data: stream}, 0)
+		var (
+			m = new(stun.Message)
+			d = new(ChannelData)
+		)
+		read := func() {
+			ft, err := f.Next()
+			if err != nil {
+				t.Fatal(err)
+			}
+			switch ft {
+			case FrameSTUN:
+				err = f.Message(m)
+			case FrameChannelData:
+				err = f.ChannelData(d)
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		// Warming up buffers.
+		read()
+		read()
+		if wasAllocs(read) {
+			t.Error("Unexpected allocations")
+		}
+	})
+}
+
+func TestFrameType_String(t *testing.T) {
+	for ft, s := range map[FrameType]string{
+		FrameSTUN:        "STUN",
+		FrameChannelData: "ChannelData",
+		0:                "unknown",
+	} {
+		if ft.String() != s {
+			t.Errorf("%d: %s != %s", ft, ft, s)
+		}
+	}
+}
+
+func BenchmarkFrameReader_Next(b *testing.B) {
+	stream, _, _ := testStream()
+	f := NewFrameReader(&loopReader{data: stream}, 0)
+	var (
+		m = new(stun.Message)
+		d = new(ChannelData)
+	)
+	b.ReportAllocs()
+	b.SetBytes(int64(len(stream) / 2))
+	for i := 0; i < b.N; i++ {
+		ft, err := f.Next()
+		if err != nil {
+			b.Fatal(err)
+		}
+		switch ft {
+		case FrameSTUN:
+			err = f.Message(m)
+		case FrameChannelData:
+			err = f.ChannelData(d)
+		}
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -46,6 +46,13 @@ func FuzzSetters(data []byte) int {
 		{new(EvenPort), stun.AttrEvenPort},
 		{new(Lifetime), stun.AttrLifetime},
 		{new(ReservationToken), stun.AttrReservationToken},
+		{new(ConnectionID), stun.AttrConnectionID},
+		{new(AdditionalAddressFamily), AttrAdditionalAddressFamily},
+		{new(AddressErrorCode), AttrAddressErrorCode},
+		{new(ICMP), AttrICMP},
+		{new(AccessToken), AttrAccessToken},
+		{new(ThirdPartyAuthorization), AttrThirdPartyAuthorization},
+		{new(MobilityTicket), AttrMobilityTicket},
 	}
 	var firstByte = byte(0)
 	if len(data) > 0 {
@@ -0,0 +1,117 @@
+package turn
+
+import (
+	"fmt"
+	"net"
+
+	"gortc.io/stun"
+)
+
+// ICMP represents ICMP attribute.
+//
+// The ICMP attribute is used by server in Data indication to inform
+// client about ICMP packet that was received from peer in response to
+// data sent by client. The Type and Code fields are ICMPv4 or ICMPv6
+// values, depending on family of XOR-PEER-ADDRESS.
+//
+// RFC 8656 Section 18.13
+type ICMP struct {
+	Type byte
+	Code byte
+	Data uint32 // error data, e.g. MTU for "packet too big"
+}
+
+func (i ICMP) String() string {
+	return fmt.Sprintf("type %d code %d data %d", i.Type, i.Code, i.Data)
+}
+
+const (
+	icmpSize     = 8
+	icmpTypeByte = 2
+	icmpCodeByte = 3
+	icmpDataByte = 4
+)
+
+// AddTo adds ICMP to message.
+func (i ICMP) AddTo(m *stun.Message) error {
+	v := make([]byte, icmpSize)
+	// v[0:2] is reserved and set to zero.
+	v[icmpTypeByte] = i.Type
+	v[icmpCodeByte] = i.Code
+	bin.PutUint32(v[icmpDataByte:], i.Data)
+	m.Add(AttrICMP, v)
+	return nil
+}
+
+// GetFrom decodes ICMP from message.
+func (i *ICMP) GetFrom(m *stun.Message) error {
+	v, err := m.Get(AttrICMP)
+	if err != nil {
+		return err
+	}
+	if err = stun.CheckSize(AttrICMP, len(v), icmpSize); err != nil {
+		return err
+	}
+	_ = v[icmpSize-1] // asserting length
+	i.Type = v[icmpTypeByte]
+	i.Code = v[icmpCodeByte]
+	i.Data = bin.Uint32(v[icmpDataByte:])
+	return nil
+}
+
+// ICMP types and codes that are reported as errors.
+const (
+	icmpv4DestinationUnreachable = 3
+	icmpv4PortUnreachable        = 3
+	icmpv4FragmentationNeeded    = 4
+	icmpv6DestinationUnreachable = 1
+	icmpv6PortUnreachable        = 4
+	icmpv6PacketTooBig           = 2
+)
+
+// PortUnreachableError means that peer responded with ICMP "port
+// unreachable" to data sent by client.
+type PortUnreachableError struct {
+	Peer net.Addr
+}
+
+func (e *PortUnreachableError) Error() string {
+	return "port unreachable: " + e.Peer.String()
+}
+
+// FragmentationNeededError means that data sent by client to peer was too
+// big to pass without fragmentation, i.e. ICMPv4 "fragmentation needed"
+// or ICMPv6 "packet too big" was received. The MTU field is next-hop MTU
+// and can be used for path MTU discovery along with DONT-FRAGMENT.
+type FragmentationNeededError struct {
+	Peer net.Addr
+	MTU  int
+}
+
+func (e *FragmentationNeededError) Error() string {
+	return fmt.Sprintf("fragmentation needed for %s, mtu %d", e.Peer, e.MTU)
+}
+
+// Err returns error that is represented by ICMP received from peer or
+// nil if ICMP type is not reported as error.
+func (i ICMP) Err(peer net.Addr) error {
+	var ipv6 bool
+	switch a := peer.(type) {
+	case *net.UDPAddr:
+		ipv6 = a.IP.To4() == nil
+	case *net.TCPAddr:
+		ipv6 = a.IP.To4() == nil
+	}
+	switch {
+	case !ipv6 && i.Type == icmpv4DestinationUnreachable && i.Code == icmpv4PortUnreachable,
+		ipv6 && i.Type == icmpv6DestinationUnreachable && i.Code == icmpv6PortUnreachable:
+		return &PortUnreachableError{Peer: peer}
+	case !ipv6 && i.Type == icmpv4DestinationUnreachable && i.Code == icmpv4FragmentationNeeded:
+		// Next-hop MTU is in the low-order 16 bits, RFC 1191 Section 4.
+		return &FragmentationNeededError{Peer: peer, MTU: int(i.Data & 0xffff)}
+	case ipv6 && i.Type == icmpv6PacketTooBig:
+		return &FragmentationNeededError{Peer: peer, MTU: int(i.Data)}
+	default:
+		return nil
+	}
+}
@@ -0,0 +1,104 @@
+package turn
+
+import (
+	"net"
+	"testing"
+
+	"gortc.io/stun"
+)
+
+func TestICMP(t *testing.T) {
+	i := ICMP{Type: 3, Code: 4, Data: 1400}
+	t.Run("String", func(t *testing.T) {
+		if i.String() != "type 3 code 4 data 1400" {
+			t.Errorf("bad string %q", i)
+		}
+	})
+	t.Run("NoAlloc", func(t *testing.T) {
+		m := &stun.Message{}
+		if wasAllocs(func() {
+			i.AddTo(m)
+			m.Reset()
+		}) {
+			t.Error("Unexpected allocations")
+		}
+	})
+	t.Run("AddTo", func(t *testing.T) {
+		m := new(stun.Message)
+		if err := i.AddTo(m); err != nil {
+			t.Error(err)
+		}
+		m.WriteHeader()
+		t.Run("GetFrom", func(t *testing.T) {
+			decoded := new(stun.Message)
+			if _, err := decoded.Write(m.Raw); err != nil {
+				t.Fatal("failed to decode message:", err)
+			}
+			var got ICMP
+			if err := got.GetFrom(decoded); err != nil {
+				t.Fatal(err)
+			}
+			if got != i {
+				t.Errorf("Decoded %s, expected %s", got, i)
+			}
+			if wasAllocs(func() {
+				got.GetFrom(decoded)
+			}) {
+				t.Error("Unexpected allocations")
+			}
+			t.Run("HandleErr", func(t *testing.T) {
+				m := new(stun.Message)
+				var handle ICMP
+				if err := handle.GetFrom(m); err != stun.ErrAttributeNotFound {
+					t.Errorf("%v should be not found", err)
+				}
+				m.Add(AttrICMP, []byte{1, 2, 3})
+				if !stun.IsAttrSizeInvalid(handle.GetFrom(m)) {
+					t.Error("IsAttrSizeInvalid should be true")
+				}
+			})
+		})
+	})
+}
+
+func TestICMP_Err(t *testing.T) {
+	v4 := &net.UDPAddr{IP: net.IPv4(10, 0, 0, 2), Port: 3478}
+	v6 := &net.UDPAddr{IP: net.ParseIP("2001:db8::2"), Port: 3478}
+	for _, tc := range []struct {
+		name string
+		icmp ICMP
+		peer net.Addr
+		mtu  int  // expected MTU for FragmentationNeededError
+		port bool // expecting PortUnreachableError
+	}{
+		{name: "PortUnreachableIPv4", icmp: ICMP{Type: 3, Code: 3}, peer: v4, port: true},
+		{name: "PortUnreachableIPv6", icmp: ICMP{Type: 1, Code: 4}, peer: v6, port: true},
+		{name: "FragmentationNeeded", icmp: ICMP{Type: 3, Code: 4, Data: 0x10000 | 1400}, peer: v4, mtu: 1400},
+		{name: "PacketTooBig", icmp: ICMP{Type: 2, Data: 1280}, peer: v6, mtu: 1280},
+		{name: "HostUnreachable", icmp: ICMP{Type: 3, Code: 1}, peer: v4},
+		{name: "WrongFamily", icmp: ICMP{Type: 3, Code: 3}, peer: v6},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			err := tc.icmp.Err(tc.peer)
+			switch e := err.(type) {
+			case *PortUnreachableError:
+				if !tc.port || e.Peer != tc.peer {
+					t.Errorf("unexpected error %v", err)
+				}
+			case *FragmentationNeededError:
+				if e.MTU != tc.mtu || e.Peer != tc.peer {
+					t.Errorf("unexpected error %v", err)
+				}
+			case nil:
+				if tc.port || tc.mtu != 0 {
+					t.Error("error expected")
+				}
+			default:
+				t.Errorf("unexpected error %v", err)
+			}
+			if err != nil && err.Error() == "" {
+				t.Error("blank error string")
+			}
+		})
+	}
+}
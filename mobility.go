@@ -0,0 +1,225 @@
+package turn
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"errors"
+	"net"
+	"time"
+
+	"gortc.io/stun"
+)
+
+// MobilityTicket represents MOBILITY-TICKET attribute.
+//
+// The MOBILITY-TICKET attribute is used to retain an allocation on
+// the TURN server when client changes its IP address or port. Client
+// requests mobility with empty ticket in Allocate request, and server
+// returns opaque ticket in Allocate and Refresh responses.
+//
+// RFC 8016 Section 3
+type MobilityTicket []byte
+
+// AddTo adds MOBILITY-TICKET to message.
+func (t MobilityTicket) AddTo(m *stun.Message) error {
+	m.Add(AttrMobilityTicket, t)
+	return nil
+}
+
+// GetFrom decodes MOBILITY-TICKET from message.
+func (t *MobilityTicket) GetFrom(m *stun.Message) error {
+	v, err := m.Get(AttrMobilityTicket)
+	if err != nil {
+		return err
+	}
+	*t = v
+	return nil
+}
+
+// ErrNoMobility means that allocation has no mobility ticket, so it
+// can't be moved to new 5-tuple.
+var ErrNoMobility = errors.New("no mobility ticket")
+
+// updateTicket stores mobility ticket from response if any.
+func (a *Allocation) updateTicket(res *stun.Message) {
+	var ticket MobilityTicket
+	if err := ticket.GetFrom(res); err != nil || len(ticket) == 0 {
+		return
+	}
+	a.mux.Lock()
+	// Copying because response buffer can be reused.
+	a.ticket = append(a.ticket[:0], ticket...)
+	a.mux.Unlock()
+}
+
+// Migrate moves allocation to new connection, e.g. after change of
+// local address, by sending Refresh request with MOBILITY-TICKET from
+// new 5-tuple. The previous connection is closed on success, otherwise
+// the new one is closed and client continues to use previous one.
+//
+// Requires allocation that was created with Mobility option.
+//
+// RFC 8016 Section 3.2
+func (c *Client) Migrate(conn net.PacketConn) error {
+	a := c.allocation()
+	if a == nil {
+		return ErrNoMobility
+	}
+	a.mux.Lock()
+	ticket := append(MobilityTicket(nil), a.ticket...)
+	lifetime := a.lifetime
+	a.mux.Unlock()
+	if len(ticket) == 0 {
+		return ErrNoMobility
+	}
+	c.mux.Lock()
+	prev := c.conn
+	c.conn = conn
+	c.mux.Unlock()
+	go c.readUntilClosed(conn)
+	res, err := c.request(RefreshRequest, lifetime, ticket)
+	if err != nil {
+		c.mux.Lock()
+		select {
+		case <-c.closed:
+			// New connection is closed by Close.
+			_ = prev.Close()
+		default:
+			c.conn = prev
+			_ = conn.Close()
+		}
+		c.mux.Unlock()
+		return err
+	}
+	_ = prev.Close()
+	if err = lifetime.GetFrom(res); err != nil {
+		return err
+	}
+	a.setLifetime(time.Now(), lifetime)
+	a.updateTicket(res)
+	return nil
+}
+
+// TicketInfo is content of mobility ticket that binds it to the
+// allocation.
+type TicketInfo struct {
+	Tuple    FiveTuple // 5-tuple of allocation when ticket was issued
+	Username string
+	Expires  time.Time
+}
+
+// TicketIssuer issues and verifies encrypted mobility tickets on server.
+//
+// Ticket is bound to the 5-tuple of allocation at the moment of issuance,
+// so server should issue new ticket in each Refresh response and lookup
+// allocation by the ticket 5-tuple on mobility Refresh.
+//
+// RFC 8016 Section 4
+type TicketIssuer struct {
+	aead cipher.AEAD
+	now  func() time.Time
+}
+
+// NewTicketIssuer returns TicketIssuer that uses AES-GCM with provided
+// key of 16, 24 or 32 bytes.
+func NewTicketIssuer(key []byte) (*TicketIssuer, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &TicketIssuer{aead: aead, now: time.Now}, nil
+}
+
+var (
+	// ErrInvalidTicket means that mobility ticket can't be decrypted.
+	ErrInvalidTicket = errors.New("invalid mobility ticket")
+	// ErrTicketWrongUser means that mobility ticket was issued for
+	// another user.
+	ErrTicketWrongUser = errors.New("mobility ticket of another user")
+	// ErrTicketExpired means that mobility ticket is expired.
+	ErrTicketExpired = errors.New("mobility ticket expired")
+)
+
+// ticket plaintext sizes.
+const (
+	ticketExpiresSize = 8
+	ticketAddrSize    = 1 + net.IPv6len + 2 // length, IP, port
+)
+
+func putTicketAddr(b []byte, a Addr) []byte {
+	ip := a.IP.To4()
+	if ip == nil {
+		ip = a.IP.To16()
+	}
+	b = append(b, byte(len(ip)))
+	b = append(b, ip...)
+	return append(b, byte(a.Port>>8), byte(a.Port))
+}
+
+func readTicketAddr(b []byte) (Addr, []byte, bool) {
+	if len(b) < 1 {
+		return Addr{}, nil, false
+	}
+	n := int(b[0])
+	if (n != 0 && n != net.IPv4len && n != net.IPv6len) || len(b) < 1+n+2 {
+		return Addr{}, nil, false
+	}
+	a := Addr{Port: int(bin.Uint16(b[1+n:]))}
+	if n > 0 {
+		a.IP = append(net.IP(nil), b[1:1+n]...)
+	}
+	return a, b[1+n+2:], true
+}
+
+// Issue returns encrypted mobility ticket with provided content.
+func (i *TicketIssuer) Issue(info TicketInfo) (MobilityTicket, error) {
+	nonce := make([]byte, i.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	plaintext := make([]byte, ticketExpiresSize, ticketExpiresSize+1+2*ticketAddrSize+len(info.Username))
+	bin.PutUint64(plaintext, uint64(info.Expires.Unix()))
+	plaintext = append(plaintext, byte(info.Tuple.Proto))
+	plaintext = putTicketAddr(plaintext, info.Tuple.Client)
+	plaintext = putTicketAddr(plaintext, info.Tuple.Server)
+	plaintext = append(plaintext, info.Username...)
+	return i.aead.Seal(nonce, nonce, plaintext, nil), nil
+}
+
+// Verify decrypts mobility ticket and checks that it is not expired
+// and was issued for user with provided name.
+func (i *TicketIssuer) Verify(ticket MobilityTicket, username string) (TicketInfo, error) {
+	n := i.aead.NonceSize()
+	if len(ticket) < n {
+		return TicketInfo{}, ErrInvalidTicket
+	}
+	plaintext, err := i.aead.Open(nil, ticket[:n], ticket[n:], nil)
+	if err != nil || len(plaintext) < ticketExpiresSize+1 {
+		return TicketInfo{}, ErrInvalidTicket
+	}
+	info := TicketInfo{
+		Expires: time.Unix(int64(bin.Uint64(plaintext)), 0),
+	}
+	info.Tuple.Proto = Protocol(plaintext[ticketExpiresSize])
+	rest := plaintext[ticketExpiresSize+1:]
+	var ok bool
+	if info.Tuple.Client, rest, ok = readTicketAddr(rest); !ok {
+		return TicketInfo{}, ErrInvalidTicket
+	}
+	if info.Tuple.Server, rest, ok = readTicketAddr(rest); !ok {
+		return TicketInfo{}, ErrInvalidTicket
+	}
+	info.Username = string(rest)
+	if info.Username != username {
+		return TicketInfo{}, ErrTicketWrongUser
+	}
+	if i.now().After(info.Expires) {
+		return TicketInfo{}, ErrTicketExpired
+	}
+	return info, nil
+}
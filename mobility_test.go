@@ -0,0 +1,252 @@
+package turn
+
+import (
+	"bytes"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"gortc.io/stun"
+)
+
+func TestMobilityTicket(t *testing.T) {
+	for _, ticket := range []MobilityTicket{{}, {1, 2, 3, 4}} {
+		m := new(stun.Message)
+		if err := ticket.AddTo(m); err != nil {
+			t.Fatal(err)
+		}
+		var got MobilityTicket
+		if err := got.GetFrom(m); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, ticket) {
+			t.Errorf("%x (got) != %x (expected)", got, ticket)
+		}
+	}
+	var ticket MobilityTicket
+	if err := ticket.GetFrom(new(stun.Message)); err != stun.ErrAttributeNotFound {
+		t.Errorf("unexpected error %v", err)
+	}
+}
+
+func newTestTicketIssuer(t testing.TB) *TicketIssuer {
+	i, err := NewTicketIssuer(bytes.Repeat([]byte{1}, 16))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return i
+}
+
+func TestTicketIssuer(t *testing.T) {
+	if _, err := NewTicketIssuer([]byte{1, 2, 3}); err == nil {
+		t.Error("should error on invalid key")
+	}
+	i := newTestTicketIssuer(t)
+	now := time.Unix(1234567890, 0)
+	i.now = func() time.Time { return now }
+	info := TicketInfo{
+		Tuple: FiveTuple{
+			Client: Addr{IP: net.IPv4(10, 0, 0, 1), Port: 1000},
+			Server: Addr{IP: net.ParseIP("2001:db8::1"), Port: 3478},
+			Proto:  ProtoUDP,
+		},
+		Username: "user",
+		Expires:  now.Add(time.Minute),
+	}
+	ticket, err := i.Issue(info)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := i.Verify(ticket, "user")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !got.Tuple.Equal(info.Tuple) || got.Username != info.Username || !got.Expires.Equal(info.Expires) {
+		t.Errorf("unexpected info %+v", got)
+	}
+	if _, err = i.Verify(ticket, "other"); err != ErrTicketWrongUser {
+		t.Errorf("unexpected error %v", err)
+	}
+	tampered := append(MobilityTicket(nil), ticket...)
+	tampered[len(tampered)-1] ^= 1
+	for _, invalid := range []MobilityTicket{nil, ticket[:10], tampered} {
+		if _, err = i.Verify(invalid, "user"); err != ErrInvalidTicket {
+			t.Errorf("unexpected error %v", err)
+		}
+	}
+	now = now.Add(time.Minute * 2)
+	if _, err = i.Verify(ticket, "user"); err != ErrTicketExpired {
+		t.Errorf("unexpected error %v", err)
+	}
+	t.Run("Malformed", func(t *testing.T) {
+		for _, plaintext := range [][]byte{
+			{0, 0, 0, 0, 0, 0, 0, 0},
+			{0, 0, 0, 0, 0, 0, 0, 0, 17, 5},
+			{0, 0, 0, 0, 0, 0, 0, 0, 17, 4, 1, 1, 1, 1, 0, 1, 3},
+		} {
+			nonce := make([]byte, i.aead.NonceSize())
+			ticket := i.aead.Seal(nonce, nonce, plaintext, nil)
+			if _, err := i.Verify(ticket, ""); err != ErrInvalidTicket {
+				t.Errorf("unexpected error %v", err)
+			}
+		}
+	})
+}
+
+// mobilityServer is fake TURN server that issues mobility tickets and
+// moves allocation on Refresh with ticket.
+type mobilityServer struct {
+	t      testing.TB
+	issuer *TicketIssuer
+
+	mux    sync.Mutex
+	client net.Addr // current 5-tuple client address
+	forbid bool
+}
+
+func (s *mobilityServer) ticket(addr net.Addr) MobilityTicket {
+	udpAddr := addr.(*net.UDPAddr)
+	ticket, err := s.issuer.Issue(TicketInfo{
+		Tuple:    FiveTuple{Client: Addr{IP: udpAddr.IP, Port: udpAddr.Port}, Proto: ProtoUDP},
+		Username: "user",
+		Expires:  time.Now().Add(time.Minute * 5),
+	})
+	if err != nil {
+		s.t.Fatal(err)
+	}
+	return ticket
+}
+
+func (s *mobilityServer) handle(req *stun.Message, addr net.Addr) *stun.Message {
+	if res := authenticate(s.t, req); res != nil {
+		return res
+	}
+	var ticket MobilityTicket
+	hasTicket := ticket.GetFrom(req) == nil
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	switch req.Type {
+	case AllocateRequest:
+		if !hasTicket || len(ticket) != 0 {
+			s.t.Error("mobility not requested")
+		}
+		s.client = addr
+		return stun.MustBuild(req, stun.NewType(stun.MethodAllocate, stun.ClassSuccessResponse),
+			RelayedAddress{IP: net.IPv4(10, 0, 0, 1), Port: 5000},
+			Lifetime{time.Minute * 5}, s.ticket(addr),
+			testIntegrity, stun.Fingerprint,
+		)
+	case RefreshRequest:
+		if hasTicket {
+			if s.forbid {
+				return stun.MustBuild(req, stun.NewType(req.Type.Method, stun.ClassErrorResponse),
+					stun.ErrorCodeAttribute{Code: CodeMobilityForbidden},
+				)
+			}
+			info, err := s.issuer.Verify(ticket, "user")
+			if err != nil {
+				s.t.Error(err)
+			}
+			if info.Tuple.Client.String() != s.client.String() {
+				s.t.Errorf("ticket for unexpected tuple %s", info.Tuple)
+			}
+			s.client = addr
+		} else if addr.String() != s.client.String() {
+			return stun.MustBuild(req, stun.NewType(req.Type.Method, stun.ClassErrorResponse),
+				stun.CodeAllocMismatch,
+			)
+		}
+		return stun.MustBuild(req, stun.NewType(req.Type.Method, stun.ClassSuccessResponse),
+			Lifetime{time.Minute * 5}, s.ticket(addr),
+			testIntegrity, stun.Fingerprint,
+		)
+	default:
+		return stun.MustBuild(req, stun.NewType(req.Type.Method, stun.ClassSuccessResponse),
+			testIntegrity, stun.Fingerprint,
+		)
+	}
+}
+
+func newMobilityClient(t *testing.T, s *fakeServer) *Client {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := NewClient(ClientOptions{
+		Conn:     conn,
+		Server:   s.conn.LocalAddr(),
+		Username: "user",
+		Password: "secret",
+		RTO:      time.Millisecond * 50,
+		Mobility: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func TestClient_Migrate(t *testing.T) {
+	m := &mobilityServer{t: t, issuer: newTestTicketIssuer(t)}
+	s := newFakeServer(t, m.handle)
+	defer s.Close()
+	c := newMobilityClient(t, s)
+	defer c.Close()
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = c.Migrate(conn); err != ErrNoMobility {
+		t.Errorf("unexpected error %v", err)
+	}
+	a, err := c.Allocate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	prev := c.connection()
+	if err = c.Migrate(conn); err != nil {
+		t.Fatal(err)
+	}
+	if c.connection() != conn {
+		t.Error("connection not changed")
+	}
+	if _, _, err = prev.ReadFrom(make([]byte, 10)); err == nil {
+		t.Error("previous connection should be closed")
+	}
+	// Allocation should be refreshed from new 5-tuple.
+	if err = a.Refresh(); err != nil {
+		t.Fatal(err)
+	}
+	// Data should be received on new connection.
+	s.send(stun.MustBuild(stun.TransactionID, stun.NewType(stun.MethodData, stun.ClassIndication),
+		PeerAddress{IP: net.IPv4(10, 0, 0, 2), Port: 3478}, Data("hello"),
+	).Raw, conn.LocalAddr())
+	buf := make([]byte, 10)
+	n, _, err := a.ReadFrom(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(buf[:n]) != "hello" {
+		t.Errorf("unexpected data %q", buf[:n])
+	}
+	t.Run("Forbidden", func(t *testing.T) {
+		m.mux.Lock()
+		m.forbid = true
+		m.mux.Unlock()
+		next, err := net.ListenPacket("udp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = c.Migrate(next)
+		if e, ok := err.(*ResponseError); !ok || e.Code.Code != CodeMobilityForbidden {
+			t.Errorf("unexpected error %v", err)
+		}
+		if c.connection() != conn {
+			t.Error("connection should be restored")
+		}
+		if err = a.Refresh(); err != nil {
+			t.Error(err)
+		}
+	})
+}
@@ -0,0 +1,219 @@
+package turn
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"gortc.io/stun"
+)
+
+// DefaultNonceValidity is default duration for which nonce is valid.
+const DefaultNonceValidity = time.Minute * 10
+
+// nonceCookie is prefix of nonce that indicates support of RFC 8489,
+// followed by base64-encoded security feature set.
+//
+// RFC 8489 Section 9.2
+const nonceCookie = "obMatJos2"
+
+// nonceCookieLength is length of nonce cookie with feature set.
+const nonceCookieLength = len(nonceCookie) + 4
+
+// SecurityFeatures is 24-bit STUN Security Features set that is
+// advertised by server in nonce cookie.
+//
+// RFC 8489 Section 18.1
+type SecurityFeatures uint32
+
+// Security features from RFC 8489 Section 18.1.
+const (
+	FeaturePasswordAlgorithms SecurityFeatures = 1 << 23 // bit 0
+	FeatureUsernameAnonymity  SecurityFeatures = 1 << 22 // bit 1
+)
+
+func (f SecurityFeatures) encode() string {
+	return base64.StdEncoding.EncodeToString([]byte{
+		byte(f >> 16), byte(f >> 8), byte(f),
+	})
+}
+
+// ParseNonceCookie returns security features from nonce that starts with
+// nonce cookie. The ok is false if there is no valid nonce cookie.
+func ParseNonceCookie(nonce stun.Nonce) (features SecurityFeatures, ok bool) {
+	if len(nonce) < nonceCookieLength || string(nonce[:len(nonceCookie)]) != nonceCookie {
+		return 0, false
+	}
+	b, err := base64.StdEncoding.DecodeString(string(nonce[len(nonceCookie):nonceCookieLength]))
+	if err != nil {
+		return 0, false
+	}
+	return SecurityFeatures(b[0])<<16 | SecurityFeatures(b[1])<<8 | SecurityFeatures(b[2]), true
+}
+
+var (
+	// ErrInvalidNonce means that nonce was not issued by NonceManager
+	// for client IP.
+	ErrInvalidNonce = errors.New("invalid nonce")
+	// ErrStaleNonce means that nonce is expired.
+	ErrStaleNonce = errors.New("stale nonce")
+)
+
+// nonceTimestampSize and nonceMACSize are sizes of nonce parts.
+const (
+	nonceTimestampSize = 8
+	nonceMACSize       = 16
+)
+
+// NonceOptions contains options for NewNonceManager.
+type NonceOptions struct {
+	// Key for HMAC of nonces, random if nil.
+	Key []byte
+	// Validity is duration for which nonce is valid, DefaultNonceValidity
+	// if zero.
+	Validity time.Duration
+	// Cookie enables RFC 8489 nonce cookie with Features.
+	Cookie   bool
+	Features SecurityFeatures
+}
+
+// NonceManager issues self-validating nonces that contain timestamp and
+// HMAC of timestamp and client IP, so no per-client state is required
+// to validate them.
+//
+// RFC 5389 Section 10.2
+type NonceManager struct {
+	validity time.Duration
+	prefix   string
+	now      func() time.Time
+
+	mux  sync.RWMutex
+	keys [][]byte // current key and previous key after rotation
+}
+
+// newNonceKey returns random key for nonce HMAC.
+func newNonceKey() ([]byte, error) {
+	key := make([]byte, sha256.Size)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// NewNonceManager initializes and returns new NonceManager.
+func NewNonceManager(o NonceOptions) (*NonceManager, error) {
+	if o.Validity == 0 {
+		o.Validity = DefaultNonceValidity
+	}
+	if o.Key == nil {
+		key, err := newNonceKey()
+		if err != nil {
+			return nil, err
+		}
+		o.Key = key
+	}
+	n := &NonceManager{
+		validity: o.Validity,
+		now:      time.Now,
+		keys:     [][]byte{o.Key},
+	}
+	if o.Cookie {
+		n.prefix = nonceCookie + o.Features.encode()
+	}
+	return n, nil
+}
+
+// Rotate makes key current for issuing nonces. Nonces that were issued
+// with previous key are still accepted until they are expired, but
+// nonces of all keys before it are not.
+func (n *NonceManager) Rotate(key []byte) {
+	n.mux.Lock()
+	n.keys = [][]byte{key, n.keys[0]}
+	n.mux.Unlock()
+}
+
+func (n *NonceManager) mac(key []byte, timestamp []byte, ip net.IP) []byte {
+	h := hmac.New(sha256.New, key)
+	_, _ = h.Write([]byte(n.prefix))
+	_, _ = h.Write(timestamp)
+	_, _ = h.Write(ip.To16())
+	return h.Sum(nil)[:nonceMACSize]
+}
+
+// Nonce returns new nonce for client IP.
+func (n *NonceManager) Nonce(ip net.IP) stun.Nonce {
+	b := make([]byte, nonceTimestampSize, nonceTimestampSize+nonceMACSize)
+	bin.PutUint64(b, uint64(n.now().UnixNano()))
+	n.mux.RLock()
+	key := n.keys[0]
+	n.mux.RUnlock()
+	b = append(b, n.mac(key, b, ip)...)
+	return stun.NewNonce(n.prefix + base64.RawURLEncoding.EncodeToString(b))
+}
+
+// Check returns ErrInvalidNonce if nonce was not issued for client IP
+// or ErrStaleNonce if it is expired.
+func (n *NonceManager) Check(nonce stun.Nonce, ip net.IP) error {
+	s := string(nonce)
+	if !strings.HasPrefix(s, n.prefix) {
+		return ErrInvalidNonce
+	}
+	b, err := base64.RawURLEncoding.DecodeString(s[len(n.prefix):])
+	if err != nil || len(b) != nonceTimestampSize+nonceMACSize {
+		return ErrInvalidNonce
+	}
+	timestamp, mac := b[:nonceTimestampSize], b[nonceTimestampSize:]
+	n.mux.RLock()
+	keys := n.keys
+	n.mux.RUnlock()
+	valid := false
+	for _, key := range keys {
+		if hmac.Equal(mac, n.mac(key, timestamp, ip)) {
+			valid = true
+			break
+		}
+	}
+	if !valid {
+		return ErrInvalidNonce
+	}
+	issued := time.Unix(0, int64(bin.Uint64(timestamp)))
+	if n.now().Sub(issued) > n.validity {
+		return ErrStaleNonce
+	}
+	return nil
+}
+
+// challenge returns error response to request with REALM, new NONCE
+// and provided attributes.
+func (n *NonceManager) challenge(req *stun.Message, code stun.ErrorCode, realm string, ip net.IP, setters ...stun.Setter) (*stun.Message, error) {
+	all := make([]stun.Setter, 0, len(setters)+6)
+	all = append(all, req,
+		stun.NewType(req.Type.Method, stun.ClassErrorResponse),
+		code, stun.NewRealm(realm), n.Nonce(ip),
+	)
+	all = append(all, setters...)
+	all = append(all, stun.Fingerprint)
+	return stun.Build(all...)
+}
+
+// Unauthorized returns 401 (Unauthorized) response to request with
+// REALM and new NONCE for client IP.
+//
+// RFC 5389 Section 10.2.2
+func (n *NonceManager) Unauthorized(req *stun.Message, realm string, ip net.IP) (*stun.Message, error) {
+	return n.challenge(req, stun.CodeUnauthorized, realm, ip)
+}
+
+// StaleNonce returns 438 (Stale Nonce) response to request with REALM
+// and new NONCE for client IP.
+//
+// RFC 5389 Section 10.2.2
+func (n *NonceManager) StaleNonce(req *stun.Message, realm string, ip net.IP) (*stun.Message, error) {
+	return n.challenge(req, stun.CodeStaleNonce, realm, ip)
+}
@@ -0,0 +1,150 @@
+package turn
+
+import (
+	"bytes"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"gortc.io/stun"
+)
+
+func TestNonceManager(t *testing.T) {
+	n, err := NewNonceManager(NonceOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Unix(1234567890, 0)
+	n.now = func() time.Time { return now }
+	ip := net.IPv4(10, 0, 0, 1)
+	nonce := n.Nonce(ip)
+	if err = n.Check(nonce, ip); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := ParseNonceCookie(nonce); ok {
+		t.Error("nonce should not have cookie")
+	}
+	t.Run("OtherIP", func(t *testing.T) {
+		if err = n.Check(nonce, net.IPv4(10, 0, 0, 2)); err != ErrInvalidNonce {
+			t.Errorf("unexpected error %v", err)
+		}
+	})
+	t.Run("Invalid", func(t *testing.T) {
+		for _, s := range []string{"", "nonce", "!!!", string(nonce[:len(nonce)-1])} {
+			if err = n.Check(stun.NewNonce(s), ip); err != ErrInvalidNonce {
+				t.Errorf("unexpected error %v for %q", err, s)
+			}
+		}
+	})
+	t.Run("Stale", func(t *testing.T) {
+		defer func() { now = time.Unix(1234567890, 0) }()
+		now = now.Add(DefaultNonceValidity)
+		if err = n.Check(nonce, ip); err != nil {
+			t.Errorf("unexpected error %v", err)
+		}
+		now = now.Add(time.Second)
+		if err = n.Check(nonce, ip); err != ErrStaleNonce {
+			t.Errorf("unexpected error %v", err)
+		}
+	})
+	t.Run("Rotate", func(t *testing.T) {
+		n.Rotate([]byte("second"))
+		if err = n.Check(nonce, ip); err != nil {
+			t.Errorf("nonce of previous key should be valid: %v", err)
+		}
+		second := n.Nonce(ip)
+		n.Rotate([]byte("third"))
+		if err = n.Check(nonce, ip); err != ErrInvalidNonce {
+			t.Errorf("unexpected error %v", err)
+		}
+		if err = n.Check(second, ip); err != nil {
+			t.Errorf("unexpected error %v", err)
+		}
+	})
+}
+
+func TestNonceManager_Cookie(t *testing.T) {
+	features := FeaturePasswordAlgorithms | FeatureUsernameAnonymity
+	n, err := NewNonceManager(NonceOptions{Cookie: true, Features: features})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ip := net.ParseIP("2001:db8::1")
+	nonce := n.Nonce(ip)
+	if !strings.HasPrefix(nonce.String(), "obMatJos2wAAA") {
+		t.Errorf("unexpected prefix of %s", nonce)
+	}
+	got, ok := ParseNonceCookie(nonce)
+	if !ok || got != features {
+		t.Errorf("unexpected features %x", got)
+	}
+	if err = n.Check(nonce, ip); err != nil {
+		t.Error(err)
+	}
+	if err = n.Check(nonce[nonceCookieLength:], ip); err != ErrInvalidNonce {
+		t.Errorf("unexpected error %v", err)
+	}
+	if _, ok = ParseNonceCookie(stun.NewNonce("obMatJos2!!!!")); ok {
+		t.Error("should not parse invalid cookie")
+	}
+}
+
+func TestNonceManager_Challenge(t *testing.T) {
+	n, err := NewNonceManager(NonceOptions{Key: []byte("key")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := stun.MustBuild(stun.TransactionID, AllocateRequest)
+	ip := net.IPv4(10, 0, 0, 1)
+	for _, tc := range []struct {
+		name  string
+		build func(req *stun.Message, realm string, ip net.IP) (*stun.Message, error)
+		code  stun.ErrorCode
+	}{
+		{"Unauthorized", n.Unauthorized, stun.CodeUnauthorized},
+		{"StaleNonce", n.StaleNonce, stun.CodeStaleNonce},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			res, buildErr := tc.build(req, "realm", ip)
+			if buildErr != nil {
+				t.Fatal(buildErr)
+			}
+			if res.TransactionID != req.TransactionID {
+				t.Error("unexpected transaction id")
+			}
+			var (
+				code  stun.ErrorCodeAttribute
+				realm stun.Realm
+				nonce stun.Nonce
+			)
+			if buildErr = res.Parse(&code, &realm, &nonce); buildErr != nil {
+				t.Fatal(buildErr)
+			}
+			if code.Code != tc.code {
+				t.Errorf("unexpected code %d", code.Code)
+			}
+			if !bytes.Equal(realm, []byte("realm")) {
+				t.Errorf("unexpected realm %s", realm)
+			}
+			if buildErr = n.Check(nonce, ip); buildErr != nil {
+				t.Error(buildErr)
+			}
+		})
+	}
+}
+
+func BenchmarkNonceManager_Check(b *testing.B) {
+	n, err := NewNonceManager(NonceOptions{Key: []byte("key")})
+	if err != nil {
+		b.Fatal(err)
+	}
+	ip := net.IPv4(10, 0, 0, 1)
+	nonce := n.Nonce(ip)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if err = n.Check(nonce, ip); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
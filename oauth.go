@@ -0,0 +1,266 @@
+package turn
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"errors"
+	"time"
+
+	"gortc.io/stun"
+)
+
+// ThirdPartyAuthorization represents THIRD-PARTY-AUTHORIZATION attribute.
+//
+// The THIRD-PARTY-AUTHORIZATION attribute is used by server in 401
+// response to indicate that it supports third-party authorization and
+// contains the server name that is used by authorization server to
+// identify the TURN server.
+//
+// RFC 7635 Section 6.1
+type ThirdPartyAuthorization []byte
+
+const maxThirdPartyAuthorizationB = 763
+
+func (a ThirdPartyAuthorization) String() string { return string(a) }
+
+// AddTo adds THIRD-PARTY-AUTHORIZATION to message.
+func (a ThirdPartyAuthorization) AddTo(m *stun.Message) error {
+	return stun.TextAttribute(a).AddToAs(m, AttrThirdPartyAuthorization, maxThirdPartyAuthorizationB)
+}
+
+// GetFrom decodes THIRD-PARTY-AUTHORIZATION from message.
+func (a *ThirdPartyAuthorization) GetFrom(m *stun.Message) error {
+	return (*stun.TextAttribute)(a).GetFromAs(m, AttrThirdPartyAuthorization)
+}
+
+// AccessToken represents ACCESS-TOKEN attribute.
+//
+// The ACCESS-TOKEN attribute contains self-contained token that is
+// encrypted by authorization server with the key shared with TURN server
+// and is opaque to client. Use OAuthKey to encrypt or decrypt it.
+//
+// RFC 7635 Section 6.2
+type AccessToken []byte
+
+// AddTo adds ACCESS-TOKEN to message.
+func (t AccessToken) AddTo(m *stun.Message) error {
+	m.Add(AttrAccessToken, t)
+	return nil
+}
+
+// GetFrom decodes ACCESS-TOKEN from message.
+func (t *AccessToken) GetFrom(m *stun.Message) error {
+	v, err := m.Get(AttrAccessToken)
+	if err != nil {
+		return err
+	}
+	*t = v
+	return nil
+}
+
+// Token is decrypted self-contained token.
+//
+// RFC 7635 Section 6.2
+type Token struct {
+	// MACKey is key that is used to compute MESSAGE-INTEGRITY.
+	MACKey []byte
+	// Timestamp is time when token was issued.
+	Timestamp time.Time
+	// Lifetime is duration of token validity since Timestamp,
+	// with second precision.
+	Lifetime time.Duration
+}
+
+// Integrity returns MESSAGE-INTEGRITY that is computed with mac_key
+// of token.
+func (t *Token) Integrity() stun.MessageIntegrity {
+	return stun.MessageIntegrity(t.MACKey)
+}
+
+// timestamp fraction is 1/64000 of second, RFC 7635 Section 6.2.
+const (
+	tokenFractions      = 64000
+	tokenFractionBits   = 16
+	tokenLengthSize     = 2
+	tokenTimestampSize  = 8
+	tokenLifetimeSize   = 4
+	tokenMaxFieldLength = 1<<16 - 1
+)
+
+func encodeTimestamp(t time.Time) uint64 {
+	fraction := uint64(t.Nanosecond()) * tokenFractions / uint64(time.Second)
+	return uint64(t.Unix())<<tokenFractionBits | fraction
+}
+
+func decodeTimestamp(v uint64) time.Time {
+	fraction := int64(v & (1<<tokenFractionBits - 1))
+	return time.Unix(int64(v>>tokenFractionBits), fraction*int64(time.Second)/tokenFractions)
+}
+
+// OAuthKey is long-term key that is shared between authorization server and
+// TURN server and is identified by kid. Keys of 16 and 32 bytes are used
+// for AEAD_AES_128_GCM and AEAD_AES_256_GCM respectively.
+//
+// RFC 7635 Section 4.1
+type OAuthKey []byte
+
+var (
+	// ErrInvalidToken means that token can't be decrypted or decoded.
+	ErrInvalidToken = errors.New("invalid access token")
+	// ErrTokenExpired means that token lifetime is over or token timestamp
+	// is in future.
+	ErrTokenExpired = errors.New("access token expired")
+	// ErrUnknownKey means that kid from USERNAME is not known.
+	ErrUnknownKey = errors.New("unknown key id")
+)
+
+func (k OAuthKey) aead() (cipher.AEAD, error) {
+	block, err := aes.NewCipher(k)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// Seal encrypts token for TURN server with provided name, returning
+// self-contained token that is used as ACCESS-TOKEN.
+func (k OAuthKey) Seal(serverName string, t *Token) (AccessToken, error) {
+	if len(t.MACKey) > tokenMaxFieldLength {
+		return nil, errors.New("mac_key is too long")
+	}
+	aead, err := k.aead()
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err = rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	plaintext := make([]byte, tokenLengthSize+len(t.MACKey)+tokenTimestampSize+tokenLifetimeSize)
+	bin.PutUint16(plaintext, uint16(len(t.MACKey)))
+	offset := tokenLengthSize + copy(plaintext[tokenLengthSize:], t.MACKey)
+	bin.PutUint64(plaintext[offset:], encodeTimestamp(t.Timestamp))
+	bin.PutUint32(plaintext[offset+tokenTimestampSize:], uint32(t.Lifetime/time.Second))
+
+	token := make([]byte, tokenLengthSize, tokenLengthSize+len(nonce)+len(plaintext)+aead.Overhead())
+	bin.PutUint16(token, uint16(len(nonce)))
+	token = append(token, nonce...)
+	// Server name is used as associated data.
+	return aead.Seal(token, nonce, plaintext, []byte(serverName)), nil
+}
+
+// Open decrypts self-contained token that was issued for TURN server with
+// provided name. Token validity is not checked.
+func (k OAuthKey) Open(serverName string, token AccessToken) (*Token, error) {
+	aead, err := k.aead()
+	if err != nil {
+		return nil, err
+	}
+	if len(token) < tokenLengthSize {
+		return nil, ErrInvalidToken
+	}
+	nonceLength := int(bin.Uint16(token))
+	if nonceLength != aead.NonceSize() || len(token) < tokenLengthSize+nonceLength {
+		return nil, ErrInvalidToken
+	}
+	nonce := token[tokenLengthSize : tokenLengthSize+nonceLength]
+	plaintext, err := aead.Open(nil, nonce, token[tokenLengthSize+nonceLength:], []byte(serverName))
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+	if len(plaintext) < tokenLengthSize {
+		return nil, ErrInvalidToken
+	}
+	keyLength := int(bin.Uint16(plaintext))
+	if len(plaintext) != tokenLengthSize+keyLength+tokenTimestampSize+tokenLifetimeSize {
+		return nil, ErrInvalidToken
+	}
+	offset := tokenLengthSize + keyLength
+	return &Token{
+		MACKey:    plaintext[tokenLengthSize:offset],
+		Timestamp: decodeTimestamp(bin.Uint64(plaintext[offset:])),
+		Lifetime:  time.Duration(bin.Uint32(plaintext[offset+tokenTimestampSize:])) * time.Second,
+	}, nil
+}
+
+// OAuthCredentials are credentials that client obtained from
+// authorization server.
+//
+// RFC 7635 Section 4.1
+type OAuthCredentials struct {
+	KeyID  string      // kid, sent in USERNAME
+	Token  AccessToken // self-contained token
+	MACKey []byte      // key for MESSAGE-INTEGRITY
+}
+
+// AddTo adds USERNAME and ACCESS-TOKEN to message. MESSAGE-INTEGRITY
+// should be added after other attributes with Integrity.
+func (c OAuthCredentials) AddTo(m *stun.Message) error {
+	if err := stun.NewUsername(c.KeyID).AddTo(m); err != nil {
+		return err
+	}
+	return c.Token.AddTo(m)
+}
+
+// Integrity returns MESSAGE-INTEGRITY that is computed with mac_key.
+func (c OAuthCredentials) Integrity() stun.MessageIntegrity {
+	return stun.MessageIntegrity(c.MACKey)
+}
+
+// DefaultOAuthClockSkew is default maximum clock difference between
+// authorization server and TURN server.
+const DefaultOAuthClockSkew = time.Second * 5
+
+// OAuthValidator validates requests with third-party authorization on
+// TURN server.
+//
+// RFC 7635 Section 5
+type OAuthValidator struct {
+	// ServerName is name of TURN server, same as in
+	// THIRD-PARTY-AUTHORIZATION.
+	ServerName string
+	// Keys is list of keys by kid.
+	Keys map[string]OAuthKey
+	// ClockSkew is maximum clock difference, DefaultOAuthClockSkew
+	// if zero.
+	ClockSkew time.Duration
+	// Now returns current time, time.Now if nil.
+	Now func() time.Time
+}
+
+// Validate decrypts ACCESS-TOKEN from request with key identified by
+// USERNAME, checks token validity and MESSAGE-INTEGRITY of request,
+// returning decrypted token.
+func (v *OAuthValidator) Validate(m *stun.Message) (*Token, error) {
+	var (
+		username stun.Username
+		token    AccessToken
+	)
+	if err := m.Parse(&username, &token); err != nil {
+		return nil, err
+	}
+	key, ok := v.Keys[username.String()]
+	if !ok {
+		return nil, ErrUnknownKey
+	}
+	t, err := key.Open(v.ServerName, token)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	if v.Now != nil {
+		now = v.Now()
+	}
+	skew := v.ClockSkew
+	if skew == 0 {
+		skew = DefaultOAuthClockSkew
+	}
+	if now.Before(t.Timestamp.Add(-skew)) || now.After(t.Timestamp.Add(t.Lifetime+skew)) {
+		return nil, ErrTokenExpired
+	}
+	if err = t.Integrity().Check(m); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
@@ -0,0 +1,234 @@
+package turn
+
+import (
+	"bytes"
+	"net"
+	"testing"
+	"time"
+
+	"gortc.io/stun"
+)
+
+func TestThirdPartyAuthorization(t *testing.T) {
+	m := new(stun.Message)
+	a := ThirdPartyAuthorization("turn.example.org")
+	if err := a.AddTo(m); err != nil {
+		t.Fatal(err)
+	}
+	var got ThirdPartyAuthorization
+	if err := got.GetFrom(m); err != nil {
+		t.Fatal(err)
+	}
+	if got.String() != "turn.example.org" {
+		t.Errorf("unexpected value %s", got)
+	}
+	if err := got.GetFrom(new(stun.Message)); err != stun.ErrAttributeNotFound {
+		t.Errorf("unexpected error %v", err)
+	}
+	if err := ThirdPartyAuthorization(make([]byte, 1024)).AddTo(m); err == nil {
+		t.Error("should error on overflow")
+	}
+}
+
+func TestAccessToken(t *testing.T) {
+	m := new(stun.Message)
+	token := AccessToken{1, 2, 3, 4}
+	if err := token.AddTo(m); err != nil {
+		t.Fatal(err)
+	}
+	var got AccessToken
+	if err := got.GetFrom(m); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, token) {
+		t.Errorf("unexpected value %x", got)
+	}
+	if err := got.GetFrom(new(stun.Message)); err != stun.ErrAttributeNotFound {
+		t.Errorf("unexpected error %v", err)
+	}
+}
+
+func TestTimestamp(t *testing.T) {
+	now := time.Unix(1234567890, int64(time.Millisecond*500))
+	v := encodeTimestamp(now)
+	if v>>16 != 1234567890 || v&0xffff != 32000 {
+		t.Errorf("unexpected value %x", v)
+	}
+	if !decodeTimestamp(v).Equal(now) {
+		t.Errorf("%s (got) != %s (expected)", decodeTimestamp(v), now)
+	}
+}
+
+var (
+	testOAuthKey    = OAuthKey(bytes.Repeat([]byte{1}, 32))
+	testServerName  = "turn.example.org"
+	testOAuthMACKey = bytes.Repeat([]byte{2}, 20)
+)
+
+func TestOAuthKey(t *testing.T) {
+	token := &Token{
+		MACKey:    testOAuthMACKey,
+		Timestamp: time.Unix(1234567890, 0),
+		Lifetime:  time.Hour,
+	}
+	for _, key := range []OAuthKey{testOAuthKey, testOAuthKey[:16]} {
+		sealed, err := key.Seal(testServerName, token)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := key.Open(testServerName, sealed)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got.MACKey, token.MACKey) || !got.Timestamp.Equal(token.Timestamp) || got.Lifetime != token.Lifetime {
+			t.Errorf("unexpected token %+v", got)
+		}
+		if _, err = key.Open("other.example.org", sealed); err != ErrInvalidToken {
+			t.Errorf("unexpected error %v", err)
+		}
+		for _, invalid := range []AccessToken{
+			nil,
+			{0, 1, 0},
+			sealed[:20],
+			append(append(AccessToken{}, sealed[:len(sealed)-1]...), sealed[len(sealed)-1]^1),
+		} {
+			if _, err = key.Open(testServerName, invalid); err != ErrInvalidToken {
+				t.Errorf("unexpected error %v", err)
+			}
+		}
+	}
+	invalidKey := OAuthKey{1, 2, 3}
+	if _, err := invalidKey.Seal(testServerName, token); err == nil {
+		t.Error("should error on invalid key size")
+	}
+	if _, err := invalidKey.Open(testServerName, nil); err == nil {
+		t.Error("should error on invalid key size")
+	}
+	if _, err := testOAuthKey.Seal(testServerName, &Token{MACKey: make([]byte, 1<<16)}); err == nil {
+		t.Error("should error on too long mac_key")
+	}
+}
+
+// newTestOAuthCredentials returns credentials with token that is
+// issued at provided time for one hour.
+func newTestOAuthCredentials(t testing.TB, issued time.Time) *OAuthCredentials {
+	token, err := testOAuthKey.Seal(testServerName, &Token{
+		MACKey:    testOAuthMACKey,
+		Timestamp: issued,
+		Lifetime:  time.Hour,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &OAuthCredentials{
+		KeyID:  "kid",
+		Token:  token,
+		MACKey: testOAuthMACKey,
+	}
+}
+
+func TestOAuthValidator_Validate(t *testing.T) {
+	now := time.Unix(1234567890, 0)
+	v := &OAuthValidator{
+		ServerName: testServerName,
+		Keys:       map[string]OAuthKey{"kid": testOAuthKey},
+		Now:        func() time.Time { return now },
+	}
+	build := func(c *OAuthCredentials, integrity stun.MessageIntegrity) *stun.Message {
+		return stun.MustBuild(stun.TransactionID, AllocateRequest,
+			c, stun.NewRealm(testRealm), integrity,
+		)
+	}
+	t.Run("Valid", func(t *testing.T) {
+		c := newTestOAuthCredentials(t, now.Add(-time.Minute))
+		token, err := v.Validate(build(c, c.Integrity()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(token.MACKey, testOAuthMACKey) {
+			t.Error("unexpected mac_key")
+		}
+	})
+	t.Run("Skew", func(t *testing.T) {
+		c := newTestOAuthCredentials(t, now.Add(time.Second))
+		if _, err := v.Validate(build(c, c.Integrity())); err != nil {
+			t.Error(err)
+		}
+	})
+	t.Run("Expired", func(t *testing.T) {
+		c := newTestOAuthCredentials(t, now.Add(-time.Hour-time.Minute))
+		if _, err := v.Validate(build(c, c.Integrity())); err != ErrTokenExpired {
+			t.Errorf("unexpected error %v", err)
+		}
+	})
+	t.Run("Future", func(t *testing.T) {
+		c := newTestOAuthCredentials(t, now.Add(time.Minute))
+		if _, err := v.Validate(build(c, c.Integrity())); err != ErrTokenExpired {
+			t.Errorf("unexpected error %v", err)
+		}
+	})
+	t.Run("UnknownKey", func(t *testing.T) {
+		c := newTestOAuthCredentials(t, now)
+		c.KeyID = "unknown"
+		if _, err := v.Validate(build(c, c.Integrity())); err != ErrUnknownKey {
+			t.Errorf("unexpected error %v", err)
+		}
+	})
+	t.Run("Integrity", func(t *testing.T) {
+		c := newTestOAuthCredentials(t, now)
+		if _, err := v.Validate(build(c, stun.NewShortTermIntegrity("other"))); err == nil {
+			t.Error("should error on invalid integrity")
+		}
+	})
+	t.Run("NoToken", func(t *testing.T) {
+		m := stun.MustBuild(stun.TransactionID, AllocateRequest, stun.NewUsername("kid"))
+		if _, err := v.Validate(m); err != stun.ErrAttributeNotFound {
+			t.Errorf("unexpected error %v", err)
+		}
+	})
+}
+
+func TestClient_OAuth(t *testing.T) {
+	v := &OAuthValidator{
+		ServerName: testServerName,
+		Keys:       map[string]OAuthKey{"kid": testOAuthKey},
+	}
+	s := newFakeServer(t, func(req *stun.Message, addr net.Addr) *stun.Message {
+		if !req.Contains(stun.AttrMessageIntegrity) {
+			return stun.MustBuild(req, stun.NewType(req.Type.Method, stun.ClassErrorResponse),
+				stun.CodeUnauthorized, stun.NewRealm(testRealm), stun.NewNonce(testNonce),
+				ThirdPartyAuthorization(testServerName),
+			)
+		}
+		token, err := v.Validate(req)
+		if err != nil {
+			t.Error(err)
+			return stun.MustBuild(req, stun.NewType(req.Type.Method, stun.ClassErrorResponse),
+				stun.CodeUnauthorized,
+			)
+		}
+		return stun.MustBuild(req, stun.NewType(req.Type.Method, stun.ClassSuccessResponse),
+			RelayedAddress{IP: net.IPv4(10, 0, 0, 1), Port: 5000},
+			Lifetime{time.Minute * 5},
+			token.Integrity(), stun.Fingerprint,
+		)
+	})
+	defer s.Close()
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := NewClient(ClientOptions{
+		Conn:   conn,
+		Server: s.conn.LocalAddr(),
+		OAuth:  newTestOAuthCredentials(t, time.Now()),
+		RTO:    time.Millisecond * 50,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if _, err = c.Allocate(); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -33,3 +33,38 @@ func (a *PeerAddress) GetFrom(m *stun.Message) error {
 }
 
 type XORPeerAddress = PeerAddress
+
+// PeerAddresses is list of XOR-PEER-ADDRESS attributes, e.g. from
+// CreatePermission request that installs multiple permissions.
+//
+// RFC 5766 Section 9.1
+type PeerAddresses []PeerAddress
+
+// AddTo adds XOR-PEER-ADDRESS to message for every address.
+func (a PeerAddresses) AddTo(m *stun.Message) error {
+	for _, addr := range a {
+		if err := addr.AddTo(m); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetFrom decodes all XOR-PEER-ADDRESS attributes from message in
+// order of appearance.
+func (a *PeerAddresses) GetFrom(m *stun.Message) error {
+	addrs := (*a)[:0]
+	err := forEachAttr(m, stun.AttrXORPeerAddress, func(single *stun.Message) error {
+		var addr PeerAddress
+		if err := addr.GetFrom(single); err != nil {
+			return err
+		}
+		addrs = append(addrs, addr)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	*a = addrs
+	return nil
+}
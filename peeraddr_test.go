@@ -30,3 +30,28 @@ func TestPeerAddress(t *testing.T) {
 		t.Fatal(err)
 	}
 }
+
+func TestPeerAddresses(t *testing.T) {
+	addrs := PeerAddresses{
+		{IP: net.IPv4(10, 0, 0, 2), Port: 333},
+		{IP: net.IPv4(10, 0, 0, 3), Port: 444},
+	}
+	m, err := stun.Build(stun.TransactionID, CreatePermissionRequest, addrs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got PeerAddresses
+	if err = got.GetFrom(m); err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 2 || got[0].String() != addrs[0].String() || got[1].String() != addrs[1].String() {
+		t.Errorf("unexpected addresses %v", got)
+	}
+	if err = got.GetFrom(new(stun.Message)); err != stun.ErrAttributeNotFound {
+		t.Errorf("unexpected error %v", err)
+	}
+	m.Add(stun.AttrXORPeerAddress, []byte{1, 2, 3})
+	if err = got.GetFrom(m); err == nil {
+		t.Error("should error on invalid attribute")
+	}
+}
@@ -0,0 +1,145 @@
+package turn
+
+import (
+	"net"
+	"sort"
+	"time"
+
+	"gortc.io/stun"
+)
+
+// PermissionLifetime is lifetime of permission.
+//
+// RFC 5766 Section 8
+const PermissionLifetime = time.Minute * 5
+
+// permission represents permission that is installed by client
+// for peer IP address.
+type permission struct {
+	peer      Addr
+	refreshAt time.Time
+	expiresAt time.Time
+}
+
+// CreatePermission installs permissions for IP addresses of provided
+// peers in single CreatePermission request. Port is ignored, so
+// permissions are deduplicated by IP.
+//
+// Installed permissions are refreshed automatically until they are
+// removed by RemovePermission or allocation is closed.
+//
+// RFC 5766 Section 9
+func (a *Allocation) CreatePermission(peers ...net.Addr) error {
+	var (
+		ips     = make(map[string]struct{}, len(peers))
+		toAdd   = make([]Addr, 0, len(peers))
+		setters = make([]stun.Setter, 0, len(peers))
+	)
+	for _, p := range peers {
+		peer, err := peerAddr(p)
+		if err != nil {
+			return err
+		}
+		ip := peer.IP.String()
+		if _, dup := ips[ip]; dup {
+			continue
+		}
+		ips[ip] = struct{}{}
+		toAdd = append(toAdd, peer)
+		setters = append(setters, PeerAddress(peer))
+	}
+	if len(toAdd) == 0 {
+		return nil
+	}
+	if _, err := a.client.request(CreatePermissionRequest, setters...); err != nil {
+		return err
+	}
+	now := time.Now()
+	a.mux.Lock()
+	for _, peer := range toAdd {
+		a.perms[peer.IP.String()] = &permission{
+			peer:      peer,
+			refreshAt: now.Add(refreshDelay(PermissionLifetime)),
+			expiresAt: now.Add(PermissionLifetime),
+		}
+	}
+	a.mux.Unlock()
+	a.reschedule()
+	return nil
+}
+
+// RemovePermission stops refreshing permissions for IP addresses of
+// provided peers. TURN has no explicit permission removal, so
+// permission expires on server after PermissionLifetime.
+func (a *Allocation) RemovePermission(peers ...net.Addr) error {
+	a.mux.Lock()
+	defer a.mux.Unlock()
+	for _, p := range peers {
+		peer, err := peerAddr(p)
+		if err != nil {
+			return err
+		}
+		delete(a.perms, peer.IP.String())
+	}
+	return nil
+}
+
+// Permissions returns IP addresses of currently installed
+// permissions in sorted order.
+func (a *Allocation) Permissions() []net.IP {
+	a.mux.Lock()
+	ips := make([]net.IP, 0, len(a.perms))
+	for _, p := range a.perms {
+		ips = append(ips, p.peer.IP)
+	}
+	a.mux.Unlock()
+	sort.Slice(ips, func(i, j int) bool {
+		return ips[i].String() < ips[j].String()
+	})
+	return ips
+}
+
+// maintainPermissions refreshes all permissions that are due at now
+// in single request and returns time of next permission refresh.
+func (a *Allocation) maintainPermissions(now time.Time) (time.Time, error) {
+	var (
+		due     []*permission
+		setters []stun.Setter
+	)
+	a.mux.Lock()
+	for ip, p := range a.perms {
+		if !now.Before(p.expiresAt) {
+			// Failed to refresh permission in time.
+			delete(a.perms, ip)
+			continue
+		}
+		if now.Before(p.refreshAt) {
+			continue
+		}
+		due = append(due, p)
+		setters = append(setters, PeerAddress(p.peer))
+	}
+	a.mux.Unlock()
+	var err error
+	if len(due) > 0 {
+		_, err = a.client.request(CreatePermissionRequest, setters...)
+	}
+	var next time.Time
+	a.mux.Lock()
+	for _, p := range due {
+		if err == nil {
+			p.refreshAt = now.Add(refreshDelay(PermissionLifetime))
+			p.expiresAt = now.Add(PermissionLifetime)
+			continue
+		}
+		p.refreshAt = now.Add(refreshRetryInterval)
+		if p.refreshAt.After(p.expiresAt) {
+			p.refreshAt = p.expiresAt
+		}
+	}
+	for _, p := range a.perms {
+		next = earliest(next, p.refreshAt)
+	}
+	a.mux.Unlock()
+	return next, err
+}
@@ -0,0 +1,188 @@
+package turn
+
+import (
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"gortc.io/stun"
+)
+
+// permissionServer is fake server that records peers of
+// CreatePermission requests.
+type permissionServer struct {
+	mux      sync.Mutex
+	requests [][]PeerAddress
+	code     stun.ErrorCode
+}
+
+func (s *permissionServer) handle(t testing.TB, req *stun.Message, addr net.Addr) *stun.Message {
+	if req.Type != CreatePermissionRequest {
+		return allocateHandler(t, req, addr)
+	}
+	if res := authenticate(t, req); res != nil {
+		return res
+	}
+	var peers []PeerAddress
+	for _, a := range req.Attributes {
+		if a.Type != stun.AttrXORPeerAddress {
+			continue
+		}
+		m := new(stun.Message)
+		m.Add(a.Type, a.Value)
+		m.TransactionID = req.TransactionID
+		var p PeerAddress
+		if err := p.GetFrom(m); err != nil {
+			t.Error(err)
+		}
+		peers = append(peers, p)
+	}
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	s.requests = append(s.requests, peers)
+	if s.code != 0 {
+		return stun.MustBuild(req, stun.NewType(req.Type.Method, stun.ClassErrorResponse), s.code)
+	}
+	return stun.MustBuild(req, stun.NewType(req.Type.Method, stun.ClassSuccessResponse),
+		testIntegrity, stun.Fingerprint,
+	)
+}
+
+func (s *permissionServer) last() []PeerAddress {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	if len(s.requests) == 0 {
+		return nil
+	}
+	return s.requests[len(s.requests)-1]
+}
+
+func (s *permissionServer) setCode(code stun.ErrorCode) {
+	s.mux.Lock()
+	s.code = code
+	s.mux.Unlock()
+}
+
+func TestAllocation_CreatePermission(t *testing.T) {
+	ps := new(permissionServer)
+	s := newFakeServer(t, func(req *stun.Message, addr net.Addr) *stun.Message {
+		return ps.handle(t, req, addr)
+	})
+	defer s.Close()
+	c, a := newTestAllocation(t, s)
+	defer c.Close()
+	var (
+		peerA  = &net.UDPAddr{IP: net.IPv4(10, 0, 0, 2), Port: 1001}
+		peerA2 = &net.UDPAddr{IP: net.IPv4(10, 0, 0, 2), Port: 1002}
+		peerB  = Addr{IP: net.IPv4(10, 0, 0, 3), Port: 1001}
+	)
+	if err := a.CreatePermission(peerA, peerA2, peerB); err != nil {
+		t.Fatal(err)
+	}
+	if peers := ps.last(); len(peers) != 2 {
+		t.Errorf("unexpected peers in request: %v", peers)
+	}
+	if err := a.CreatePermission(); err != nil {
+		t.Error(err)
+	}
+	ips := a.Permissions()
+	if len(ips) != 2 || !ips[0].Equal(peerA.IP) || !ips[1].Equal(peerB.IP) {
+		t.Errorf("unexpected permissions: %v", ips)
+	}
+	if err := a.RemovePermission(peerA2); err != nil {
+		t.Fatal(err)
+	}
+	if ips = a.Permissions(); len(ips) != 1 || !ips[0].Equal(peerB.IP) {
+		t.Errorf("unexpected permissions: %v", ips)
+	}
+	if err := a.CreatePermission(&net.IPAddr{}); err == nil {
+		t.Error("should error on unsupported address")
+	}
+	if err := a.RemovePermission(&net.IPAddr{}); err == nil {
+		t.Error("should error on unsupported address")
+	}
+	ps.setCode(stun.CodeForbidden)
+	err := a.CreatePermission(peerA)
+	if e, ok := err.(*ResponseError); !ok || e.Code.Code != stun.CodeForbidden {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestAllocation_maintainPermissions(t *testing.T) {
+	ps := new(permissionServer)
+	s := newFakeServer(t, func(req *stun.Message, addr net.Addr) *stun.Message {
+		return ps.handle(t, req, addr)
+	})
+	defer s.Close()
+	c, a := newTestAllocation(t, s)
+	defer c.Close()
+	close(a.closed)
+	<-a.done
+	var (
+		peerA = &net.UDPAddr{IP: net.IPv4(10, 0, 0, 2), Port: 1001}
+		peerB = &net.UDPAddr{IP: net.IPv4(10, 0, 0, 3), Port: 1001}
+		peerC = &net.UDPAddr{IP: net.IPv4(10, 0, 0, 4), Port: 1001}
+	)
+	if err := a.CreatePermission(peerA, peerB); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.CreatePermission(peerC); err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	next, err := a.maintainPermissions(now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if next.Sub(now) > refreshDelay(PermissionLifetime) {
+		t.Errorf("unexpected next refresh %s", next.Sub(now))
+	}
+	// All permissions should be refreshed in single request.
+	now = now.Add(PermissionLifetime - time.Second)
+	if _, err = a.maintainPermissions(now); err != nil {
+		t.Fatal(err)
+	}
+	if peers := ps.last(); len(peers) != 3 {
+		t.Errorf("unexpected peers in request: %v", peers)
+	}
+	if len(a.Permissions()) != 3 {
+		t.Error("permissions should be refreshed")
+	}
+	// Failed refresh is retried until expiration.
+	ps.setCode(stun.CodeServerError)
+	now = now.Add(refreshDelay(PermissionLifetime))
+	if next, err = a.maintainPermissions(now); err == nil {
+		t.Error("should error")
+	}
+	if !next.Equal(now.Add(refreshRetryInterval)) {
+		t.Errorf("unexpected retry %s", next.Sub(now))
+	}
+	if _, err = a.maintainPermissions(now.Add(PermissionLifetime)); err != nil {
+		t.Error(err)
+	}
+	if len(a.Permissions()) != 0 {
+		t.Error("permissions should be expired")
+	}
+}
+
+func TestEarliest(t *testing.T) {
+	var (
+		zero time.Time
+		a    = time.Unix(100, 0)
+		b    = time.Unix(200, 0)
+	)
+	for _, tc := range []struct {
+		a, b, v time.Time
+	}{
+		{zero, zero, zero},
+		{a, zero, a},
+		{zero, b, b},
+		{a, b, a},
+		{b, a, a},
+	} {
+		if v := earliest(tc.a, tc.b); !v.Equal(tc.v) {
+			t.Errorf("earliest(%s, %s) = %s", tc.a, tc.b, v)
+		}
+	}
+}
@@ -0,0 +1,67 @@
+package turn
+
+import (
+	"net"
+	"sync"
+	"time"
+)
+
+// ipKey is comparable representation of IP address.
+type ipKey [net.IPv6len]byte
+
+func newIPKey(ip net.IP) ipKey {
+	var k ipKey
+	copy(k[:], ip.To16())
+	return k
+}
+
+// permissionTable is table of permissions of server allocation, keyed
+// by peer IP address. Safe for concurrent use.
+//
+// RFC 5766 Section 8
+type permissionTable struct {
+	mux   sync.RWMutex
+	perms map[ipKey]time.Time // peer IP -> expiration time
+}
+
+func newPermissionTable() *permissionTable {
+	return &permissionTable{
+		perms: make(map[ipKey]time.Time),
+	}
+}
+
+// install installs or refreshes permission for peer IP for
+// PermissionLifetime.
+func (t *permissionTable) install(ip net.IP, now time.Time) {
+	t.mux.Lock()
+	t.perms[newIPKey(ip)] = now.Add(PermissionLifetime)
+	t.mux.Unlock()
+}
+
+// permitted returns true if there is permission for peer IP.
+func (t *permissionTable) permitted(ip net.IP, now time.Time) bool {
+	t.mux.RLock()
+	expiresAt, ok := t.perms[newIPKey(ip)]
+	t.mux.RUnlock()
+	return ok && now.Before(expiresAt)
+}
+
+// len returns count of permissions, including expired ones that are
+// not collected yet.
+func (t *permissionTable) len() int {
+	t.mux.RLock()
+	n := len(t.perms)
+	t.mux.RUnlock()
+	return n
+}
+
+// collect deletes expired permissions.
+func (t *permissionTable) collect(now time.Time) {
+	t.mux.Lock()
+	for k, expiresAt := range t.perms {
+		if !now.Before(expiresAt) {
+			delete(t.perms, k)
+		}
+	}
+	t.mux.Unlock()
+}
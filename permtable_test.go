@@ -0,0 +1,54 @@
+package turn
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func TestPermissionTable(t *testing.T) {
+	now := time.Now()
+	p := newPermissionTable()
+	ip := net.IPv4(10, 0, 0, 1)
+	if p.permitted(ip, now) {
+		t.Error("should not be permitted")
+	}
+	p.install(ip, now)
+	if !p.permitted(ip, now) || !p.permitted(net.ParseIP("::ffff:10.0.0.1"), now) {
+		t.Error("should be permitted")
+	}
+	if p.permitted(net.IPv4(10, 0, 0, 2), now) {
+		t.Error("permission is for single IP")
+	}
+	if p.permitted(ip, now.Add(PermissionLifetime)) {
+		t.Error("permission should expire after 300 seconds")
+	}
+	t.Run("Refresh", func(t *testing.T) {
+		p.install(ip, now.Add(time.Minute))
+		if !p.permitted(ip, now.Add(PermissionLifetime)) {
+			t.Error("permission should be refreshed")
+		}
+	})
+	t.Run("Collect", func(t *testing.T) {
+		p.install(net.IPv4(10, 0, 0, 2), now.Add(time.Minute*2))
+		p.collect(now.Add(PermissionLifetime + time.Minute))
+		if p.len() != 1 {
+			t.Errorf("unexpected length %d", p.len())
+		}
+	})
+}
+
+func BenchmarkPermissionTable_permitted(b *testing.B) {
+	now := time.Now()
+	p := newPermissionTable()
+	ip := net.IPv4(10, 0, 0, 1)
+	p.install(ip, now)
+	b.ReportAllocs()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			if !p.permitted(ip, now) {
+				b.Fatal("not permitted")
+			}
+		}
+	})
+}
@@ -0,0 +1,224 @@
+package turn
+
+import (
+	"crypto/rand"
+	"errors"
+	"math/big"
+	"net"
+	"sync"
+	"time"
+)
+
+// PortRange is inclusive range of ports for relayed transport addresses.
+type PortRange struct {
+	Min int
+	Max int
+}
+
+// DefaultPortRange is range of ports that server should allocate
+// relayed transport addresses from.
+//
+// RFC 5766 Section 6.2
+var DefaultPortRange = PortRange{Min: 49152, Max: 65535}
+
+func (r PortRange) valid() bool {
+	return r.Min > 0 && r.Max <= 65535 && r.Min <= r.Max
+}
+
+// ReservationLifetime is duration for which server holds relayed
+// transport address that is reserved with RESERVATION-TOKEN.
+//
+// RFC 5766 Section 6.2
+const ReservationLifetime = time.Second * 30
+
+// ErrNoFreePort means that there is no free port in range.
+var ErrNoFreePort = errors.New("no free port in range")
+
+// errReusePortUnsupported means that SO_REUSEPORT can't be set.
+var errReusePortUnsupported = errors.New("SO_REUSEPORT is not supported")
+
+// reservation is relay socket that is held for RESERVATION-TOKEN.
+type reservation struct {
+	conn      *net.UDPConn
+	expiresAt time.Time
+}
+
+// portAllocator allocates relay sockets on ports from range, holding
+// sockets of reserved ports until they are claimed with token or
+// expired.
+type portAllocator struct {
+	ports PortRange
+	intn  func(n int) int
+
+	mux          sync.Mutex
+	reservations map[[reservationTokenSize]byte]*reservation
+}
+
+func newPortAllocator(ports PortRange) *portAllocator {
+	return &portAllocator{
+		ports:        ports,
+		intn:         randIntn,
+		reservations: make(map[[reservationTokenSize]byte]*reservation),
+	}
+}
+
+// randIntn returns random number in [0, n) from crypto/rand, so
+// relayed transport addresses are hard to guess.
+//
+// RFC 5766 Section 6.2
+func randIntn(n int) int {
+	v, err := rand.Int(rand.Reader, big.NewInt(int64(n)))
+	if err != nil {
+		return 0
+	}
+	return int(v.Int64())
+}
+
+// scan calls try for ports in range starting from random one until it
+// returns true. Only even ports are tried if even is true.
+func (p *portAllocator) scan(even bool, try func(port int) bool) bool {
+	n := p.ports.Max - p.ports.Min + 1
+	start := p.intn(n)
+	for i := 0; i < n; i++ {
+		port := p.ports.Min + (start+i)%n
+		if even && port%2 != 0 {
+			continue
+		}
+		if try(port) {
+			return true
+		}
+	}
+	return false
+}
+
+// listen returns relay socket on free port from range, which is even if
+// requested.
+func (p *portAllocator) listen(ip net.IP, even bool) (*net.UDPConn, error) {
+	var conn *net.UDPConn
+	if !p.scan(even, func(port int) bool {
+		c, err := net.ListenUDP("udp", &net.UDPAddr{IP: ip, Port: port})
+		if err != nil {
+			return false
+		}
+		conn = c
+		return true
+	}) {
+		return nil, ErrNoFreePort
+	}
+	return conn, nil
+}
+
+// listenTCP returns listener for TCP relayed transport address on free
+// port from range.
+//
+// SO_REUSEPORT is enabled after bind if supported, so connections to
+// peers can be established from the same address, while other listeners
+// still can't bind to it.
+//
+// RFC 6062 Section 5.1
+func (p *portAllocator) listenTCP(ip net.IP) (*net.TCPListener, error) {
+	var l *net.TCPListener
+	if !p.scan(false, func(port int) bool {
+		c, err := net.ListenTCP("tcp", &net.TCPAddr{IP: ip, Port: port})
+		if err != nil {
+			return false
+		}
+		if supportsReusePort {
+			raw, rawErr := c.SyscallConn()
+			if rawErr == nil {
+				rawErr = setReusePort(raw)
+			}
+			if rawErr != nil {
+				_ = c.Close()
+				return false
+			}
+		}
+		l = c
+		return true
+	}) {
+		return nil, ErrNoFreePort
+	}
+	return l, nil
+}
+
+// listenReserve returns relay socket on free even port from range and
+// reserves next-higher port until expiration, returning token for it.
+//
+// RFC 5766 Section 6.2
+func (p *portAllocator) listenReserve(ip net.IP, now time.Time) (*net.UDPConn, ReservationToken, error) {
+	var conn, reserved *net.UDPConn
+	if !p.scan(true, func(port int) bool {
+		if port+1 > p.ports.Max {
+			return false
+		}
+		c, err := net.ListenUDP("udp", &net.UDPAddr{IP: ip, Port: port})
+		if err != nil {
+			return false
+		}
+		next, err := net.ListenUDP("udp", &net.UDPAddr{IP: ip, Port: port + 1})
+		if err != nil {
+			_ = c.Close()
+			return false
+		}
+		conn, reserved = c, next
+		return true
+	}) {
+		return nil, nil, ErrNoFreePort
+	}
+	var token [reservationTokenSize]byte
+	if _, err := rand.Read(token[:]); err != nil {
+		_ = conn.Close()
+		_ = reserved.Close()
+		return nil, nil, err
+	}
+	p.mux.Lock()
+	p.reservations[token] = &reservation{
+		conn:      reserved,
+		expiresAt: now.Add(ReservationLifetime),
+	}
+	p.mux.Unlock()
+	return conn, ReservationToken(token[:]), nil
+}
+
+// claim returns relay socket that was reserved for token.
+func (p *portAllocator) claim(token ReservationToken, now time.Time) (*net.UDPConn, bool) {
+	if len(token) != reservationTokenSize {
+		return nil, false
+	}
+	var k [reservationTokenSize]byte
+	copy(k[:], token)
+	p.mux.Lock()
+	r, ok := p.reservations[k]
+	delete(p.reservations, k)
+	p.mux.Unlock()
+	if !ok {
+		return nil, false
+	}
+	if !now.Before(r.expiresAt) {
+		_ = r.conn.Close()
+		return nil, false
+	}
+	return r.conn, true
+}
+
+// collect releases expired reservations.
+func (p *portAllocator) collect(now time.Time) {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	for k, r := range p.reservations {
+		if !now.Before(r.expiresAt) {
+			delete(p.reservations, k)
+			_ = r.conn.Close()
+		}
+	}
+}
+
+// close releases all reservations.
+func (p *portAllocator) close() {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	for k, r := range p.reservations {
+		delete(p.reservations, k)
+		_ = r.conn.Close()
+	}
+}
@@ -0,0 +1,156 @@
+package turn
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func relayPort(t testing.TB, conn *net.UDPConn) int {
+	t.Helper()
+	return conn.LocalAddr().(*net.UDPAddr).Port
+}
+
+func TestPortRange(t *testing.T) {
+	for _, tc := range []struct {
+		r     PortRange
+		valid bool
+	}{
+		{DefaultPortRange, true},
+		{PortRange{Min: 1000, Max: 1000}, true},
+		{PortRange{}, false},
+		{PortRange{Min: 2000, Max: 1000}, false},
+		{PortRange{Min: 1000, Max: 70000}, false},
+	} {
+		if tc.r.valid() != tc.valid {
+			t.Errorf("%v: valid() != %v", tc.r, tc.valid)
+		}
+	}
+}
+
+func TestRandIntn(t *testing.T) {
+	seen := make(map[int]bool)
+	for i := 0; i < 1000; i++ {
+		v := randIntn(4)
+		if v < 0 || v >= 4 {
+			t.Fatalf("unexpected value %d", v)
+		}
+		seen[v] = true
+	}
+	if len(seen) != 4 {
+		t.Errorf("unexpected values %v", seen)
+	}
+	if randIntn(1) != 0 {
+		t.Error("should be zero")
+	}
+}
+
+func TestPortAllocator(t *testing.T) {
+	ip := net.IPv4(127, 0, 0, 1)
+	ports := PortRange{Min: 50100, Max: 50199}
+	p := newPortAllocator(ports)
+	defer p.close()
+	t.Run("Listen", func(t *testing.T) {
+		conn, err := p.listen(ip, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		if port := relayPort(t, conn); port < ports.Min || port > ports.Max {
+			t.Errorf("port %d not in range", port)
+		}
+	})
+	t.Run("Even", func(t *testing.T) {
+		for i := 0; i < 10; i++ {
+			conn, err := p.listen(ip, true)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if port := relayPort(t, conn); port%2 != 0 {
+				t.Errorf("port %d is not even", port)
+			}
+			if err = conn.Close(); err != nil {
+				t.Fatal(err)
+			}
+		}
+	})
+	t.Run("TCP", func(t *testing.T) {
+		l, err := p.listenTCP(ip)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer l.Close()
+		if port := l.Addr().(*net.TCPAddr).Port; port < ports.Min || port > ports.Max {
+			t.Errorf("port %d not in range", port)
+		}
+		// Port is shared only with connections to peers.
+		other, err := p.listenTCP(ip)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer other.Close()
+		if other.Addr().String() == l.Addr().String() {
+			t.Errorf("port %s is allocated twice", l.Addr())
+		}
+	})
+	t.Run("Reserve", func(t *testing.T) {
+		now := time.Now()
+		conn, token, err := p.listenReserve(ip, now)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		if len(token) != reservationTokenSize {
+			t.Fatalf("unexpected token %x", token)
+		}
+		reserved, ok := p.claim(token, now.Add(ReservationLifetime-time.Second))
+		if !ok {
+			t.Fatal("failed to claim reserved port")
+		}
+		defer reserved.Close()
+		if relayPort(t, reserved) != relayPort(t, conn)+1 {
+			t.Errorf("unexpected reserved port %d for %d", relayPort(t, reserved), relayPort(t, conn))
+		}
+		if _, ok = p.claim(token, now); ok {
+			t.Error("token should be claimed once")
+		}
+		if _, ok = p.claim(ReservationToken{1, 2, 3}, now); ok {
+			t.Error("should not claim invalid token")
+		}
+	})
+	t.Run("Expire", func(t *testing.T) {
+		now := time.Now()
+		conn, token, err := p.listenReserve(ip, now)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		p.collect(now.Add(ReservationLifetime))
+		if _, ok := p.claim(token, now); ok {
+			t.Error("reservation should expire")
+		}
+		// Reserved port is released.
+		next, err := net.ListenUDP("udp", &net.UDPAddr{IP: ip, Port: relayPort(t, conn) + 1})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = next.Close(); err != nil {
+			t.Fatal(err)
+		}
+	})
+	t.Run("NoFreePort", func(t *testing.T) {
+		conn, err := p.listen(ip, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		port := relayPort(t, conn)
+		single := newPortAllocator(PortRange{Min: port, Max: port})
+		if _, err = single.listen(ip, false); err != ErrNoFreePort {
+			t.Errorf("unexpected error %v", err)
+		}
+		if _, _, err = single.listenReserve(ip, time.Now()); err != ErrNoFreePort {
+			t.Errorf("unexpected error %v", err)
+		}
+	})
+}
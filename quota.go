@@ -0,0 +1,116 @@
+package turn
+
+import (
+	"net"
+	"sync"
+
+	"gortc.io/stun"
+)
+
+// Quota limits count of concurrent allocations on server. Zero value of
+// limit means no limit.
+type Quota struct {
+	// PerUser limits allocations per username of authenticated
+	// requests, 486 (Allocation Quota Reached) is returned if exceeded.
+	PerUser int
+	// PerIP limits allocations per client IP address, 486 (Allocation
+	// Quota Reached) is returned if exceeded.
+	PerIP int
+	// Total limits allocations on server, 508 (Insufficient Capacity)
+	// is returned if exceeded.
+	Total int
+}
+
+func exceeds(limit, count int) bool {
+	return limit > 0 && count >= limit
+}
+
+// quotaCounter counts allocations to enforce Quota. Safe for
+// concurrent use.
+//
+// RFC 5766 Section 6.2
+type quotaCounter struct {
+	mux      sync.Mutex
+	quota    Quota
+	users    map[string]int
+	ips      map[ipKey]int
+	total    int
+	rejected uint64
+}
+
+func newQuotaCounter(q Quota) *quotaCounter {
+	return &quotaCounter{
+		quota: q,
+		users: make(map[string]int),
+		ips:   make(map[ipKey]int),
+	}
+}
+
+// acquire counts allocation for username and client IP, returning error
+// code if quota is exceeded.
+func (c *quotaCounter) acquire(username string, ip net.IP) stun.ErrorCode {
+	k := newIPKey(ip)
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	var code stun.ErrorCode
+	switch {
+	case exceeds(c.quota.Total, c.total):
+		code = stun.CodeInsufficientCapacity
+	case username != "" && exceeds(c.quota.PerUser, c.users[username]):
+		code = stun.CodeAllocQuotaReached
+	case exceeds(c.quota.PerIP, c.ips[k]):
+		code = stun.CodeAllocQuotaReached
+	}
+	if code != 0 {
+		c.rejected++
+		return code
+	}
+	c.total++
+	if username != "" {
+		c.users[username]++
+	}
+	c.ips[k]++
+	return 0
+}
+
+// release releases allocation that was counted by acquire.
+func (c *quotaCounter) release(username string, ip net.IP) {
+	k := newIPKey(ip)
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	c.total--
+	if username != "" {
+		if c.users[username]--; c.users[username] <= 0 {
+			delete(c.users, username)
+		}
+	}
+	if c.ips[k]--; c.ips[k] <= 0 {
+		delete(c.ips, k)
+	}
+}
+
+// set replaces quota. Existing allocations are not affected.
+func (c *quotaCounter) set(q Quota) {
+	c.mux.Lock()
+	c.quota = q
+	c.mux.Unlock()
+}
+
+// QuotaStats is snapshot of quota usage.
+type QuotaStats struct {
+	Quota    Quota
+	Users    int    // count of users with allocations
+	IPs      int    // count of client IPs with allocations
+	Rejected uint64 // count of Allocate requests rejected by quota
+}
+
+func (c *quotaCounter) stats() QuotaStats {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	return QuotaStats{
+		Quota:    c.quota,
+		Users:    len(c.users),
+		IPs:      len(c.ips),
+		Rejected: c.rejected,
+	}
+}
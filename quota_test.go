@@ -0,0 +1,57 @@
+package turn
+
+import (
+	"net"
+	"testing"
+
+	"gortc.io/stun"
+)
+
+func TestQuotaCounter(t *testing.T) {
+	c := newQuotaCounter(Quota{PerUser: 2, PerIP: 3, Total: 4})
+	ip1, ip2 := net.IPv4(10, 0, 0, 1), net.IPv4(10, 0, 0, 2)
+	for _, tc := range []struct {
+		username string
+		ip       net.IP
+		code     stun.ErrorCode
+	}{
+		{"alice", ip1, 0},
+		{"alice", ip1, 0},
+		{"alice", ip2, stun.CodeAllocQuotaReached}, // per user
+		{"bob", ip1, 0},
+		{"carol", ip1, stun.CodeAllocQuotaReached}, // per IP
+		{"", ip2, 0},
+		{"", ip2, stun.CodeInsufficientCapacity}, // total
+	} {
+		if code := c.acquire(tc.username, tc.ip); code != tc.code {
+			t.Errorf("%s from %s: unexpected code %d, expected %d", tc.username, tc.ip, code, tc.code)
+		}
+	}
+	stats := c.stats()
+	if stats.Users != 2 || stats.IPs != 2 || stats.Rejected != 3 {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+	c.release("alice", ip1)
+	if code := c.acquire("carol", ip2); code != 0 {
+		t.Errorf("unexpected code %d", code)
+	}
+	t.Run("Set", func(t *testing.T) {
+		c.set(Quota{})
+		for i := 0; i < 10; i++ {
+			if code := c.acquire("alice", ip1); code != 0 {
+				t.Fatalf("unexpected code %d", code)
+			}
+		}
+		if c.stats().Quota != (Quota{}) {
+			t.Error("quota is not set")
+		}
+	})
+	t.Run("Release", func(t *testing.T) {
+		c := newQuotaCounter(Quota{})
+		c.acquire("alice", ip1)
+		c.release("alice", ip1)
+		if stats := c.stats(); stats.Users != 0 || stats.IPs != 0 {
+			t.Errorf("unexpected stats %+v", stats)
+		}
+	})
+}
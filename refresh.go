@@ -0,0 +1,172 @@
+package turn
+
+import (
+	"errors"
+	"time"
+
+	"gortc.io/stun"
+)
+
+const (
+	// refreshMargin is maximum duration before expiration when
+	// refresh is performed.
+	refreshMargin = time.Minute
+	// refreshRetryInterval is duration between refresh retries
+	// after failure.
+	refreshRetryInterval = time.Second * 5
+)
+
+// ErrAllocationExpired means that allocation was not refreshed in time
+// and is expired.
+var ErrAllocationExpired = errors.New("allocation expired")
+
+// refreshDelay returns duration after which resource with provided
+// lifetime should be refreshed.
+func refreshDelay(lifetime time.Duration) time.Duration {
+	margin := lifetime / 5
+	if margin > refreshMargin {
+		margin = refreshMargin
+	}
+	return lifetime - margin
+}
+
+// Refresh performs Refresh request, requesting the last granted
+// lifetime for allocation.
+//
+// Refresh is performed automatically, so calling it directly is
+// not required.
+func (a *Allocation) Refresh() error {
+	a.mux.Lock()
+	lifetime := a.lifetime
+	a.mux.Unlock()
+	res, err := a.client.request(RefreshRequest, lifetime)
+	if err != nil {
+		return err
+	}
+	if err = lifetime.GetFrom(res); err != nil {
+		return err
+	}
+	a.setLifetime(time.Now(), lifetime)
+	a.updateTicket(res)
+	return nil
+}
+
+// setLifetime sets granted lifetime and schedules next refresh.
+func (a *Allocation) setLifetime(now time.Time, l Lifetime) {
+	a.mux.Lock()
+	a.lifetime = l
+	a.refreshAt = now.Add(refreshDelay(l.Duration))
+	a.expiresAt = now.Add(l.Duration)
+	a.mux.Unlock()
+}
+
+// deallocate deletes allocation on server.
+func (a *Allocation) deallocate() error {
+	_, err := a.client.request(RefreshRequest, ZeroLifetime)
+	if e, ok := err.(*ResponseError); ok && e.Code.Code == stun.CodeAllocMismatch {
+		// Allocation does not exist.
+		return nil
+	}
+	return err
+}
+
+// maintain performs refreshes that are due at now and returns time
+// of next required maintenance. Zero time means that allocation
+// is expired and no maintenance is required.
+func (a *Allocation) maintain(now time.Time) (time.Time, error) {
+	next, err := a.maintainAllocation(now)
+	if next.IsZero() {
+		return next, err
+	}
+	permNext, permErr := a.maintainPermissions(now)
+	if err == nil {
+		err = permErr
+	}
+	chanNext, chanErr := a.maintainChannels(now)
+	if err == nil {
+		err = chanErr
+	}
+	return earliest(earliest(next, permNext), chanNext), err
+}
+
+// earliest returns earliest non-zero time of a and b.
+func earliest(a, b time.Time) time.Time {
+	if a.IsZero() || (!b.IsZero() && b.Before(a)) {
+		return b
+	}
+	return a
+}
+
+// maintainAllocation refreshes allocation if it is due at now.
+func (a *Allocation) maintainAllocation(now time.Time) (time.Time, error) {
+	a.mux.Lock()
+	refreshAt, expiresAt := a.refreshAt, a.expiresAt
+	a.mux.Unlock()
+	if now.Before(refreshAt) {
+		return refreshAt, nil
+	}
+	if err := a.Refresh(); err != nil {
+		if e, ok := err.(*ResponseError); ok && e.Code.Code == stun.CodeAllocMismatch {
+			return time.Time{}, ErrAllocationExpired
+		}
+		if !now.Before(expiresAt) {
+			return time.Time{}, ErrAllocationExpired
+		}
+		retryAt := now.Add(refreshRetryInterval)
+		if retryAt.After(expiresAt) {
+			retryAt = expiresAt
+		}
+		return retryAt, err
+	}
+	a.mux.Lock()
+	refreshAt = a.refreshAt
+	a.mux.Unlock()
+	return refreshAt, nil
+}
+
+// reschedule wakes refresh loop to re-evaluate time of next
+// maintenance.
+func (a *Allocation) reschedule() {
+	select {
+	case a.wake <- struct{}{}:
+	default:
+	}
+}
+
+// refreshUntilClosed keeps allocation alive until it is closed or
+// expired.
+func (a *Allocation) refreshUntilClosed() {
+	defer close(a.done)
+	a.mux.Lock()
+	next := a.refreshAt
+	a.mux.Unlock()
+	timer := time.NewTimer(time.Until(next))
+	defer timer.Stop()
+	for {
+		select {
+		case <-a.closed:
+			return
+		case <-a.client.closed:
+			return
+		case <-a.wake:
+			if !timer.Stop() {
+				<-timer.C
+			}
+		case <-timer.C:
+		}
+		var err error
+		next, err = a.maintain(time.Now())
+		if next.IsZero() {
+			a.expire()
+		}
+		if err != nil && a.client.onRefreshError != nil {
+			// Callback can close allocation, which waits for this loop
+			// to stop, so it is called in separate goroutine.
+			go a.client.onRefreshError(err)
+		}
+		if next.IsZero() {
+			return
+		}
+		timer.Reset(time.Until(next))
+	}
+}
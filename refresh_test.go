@@ -0,0 +1,213 @@
+package turn
+
+import (
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"gortc.io/stun"
+)
+
+func TestRefreshDelay(t *testing.T) {
+	for _, tc := range []struct {
+		lifetime, delay time.Duration
+	}{
+		{DefaultLifetime, DefaultLifetime - time.Minute},
+		{time.Minute, time.Second * 48},
+		{time.Second * 5, time.Second * 4},
+	} {
+		if d := refreshDelay(tc.lifetime); d != tc.delay {
+			t.Errorf("refreshDelay(%s) = %s, expected %s", tc.lifetime, d, tc.delay)
+		}
+	}
+}
+
+func TestAllocation_Refresh(t *testing.T) {
+	var (
+		refreshes = make(chan Lifetime, 10)
+		stale     = true
+	)
+	s := newFakeServer(t, func(req *stun.Message, addr net.Addr) *stun.Message {
+		if req.Type != RefreshRequest {
+			return allocateHandler(t, req, addr)
+		}
+		if stale {
+			stale = false
+			return stun.MustBuild(req, stun.NewType(req.Type.Method, stun.ClassErrorResponse),
+				stun.CodeStaleNonce, stun.NewNonce(testNonce),
+			)
+		}
+		if res := authenticate(t, req); res != nil {
+			return res
+		}
+		var l Lifetime
+		if err := l.GetFrom(req); err != nil {
+			t.Error(err)
+		}
+		refreshes <- l
+		return stun.MustBuild(req, stun.NewType(req.Type.Method, stun.ClassSuccessResponse),
+			Lifetime{time.Second}, testIntegrity, stun.Fingerprint,
+		)
+	})
+	defer s.Close()
+	c, a := newTestAllocation(t, s)
+	defer c.Close()
+	if err := a.Refresh(); err != nil {
+		t.Fatal(err)
+	}
+	if l := <-refreshes; l.Duration != time.Minute*5 {
+		t.Errorf("unexpected lifetime %s, expected granted", l)
+	}
+	// Allocation should be refreshed automatically with granted lifetime.
+	a.mux.Lock()
+	a.refreshAt = time.Now()
+	a.mux.Unlock()
+	a.reschedule()
+	select {
+	case l := <-refreshes:
+		if l.Duration != time.Second {
+			t.Errorf("unexpected lifetime %s", l)
+		}
+	case <-time.After(time.Second * 2):
+		t.Fatal("not refreshed")
+	}
+	select {
+	case <-refreshes:
+	case <-time.After(time.Second * 2):
+		t.Fatal("not refreshed automatically")
+	}
+	if err := a.Close(); err != nil {
+		t.Fatal(err)
+	}
+	for l := range refreshes {
+		if l.Duration == 0 {
+			break
+		}
+	}
+}
+
+func TestAllocation_maintain(t *testing.T) {
+	var code atomic.Value
+	code.Store(stun.CodeServerError)
+	s := newFakeServer(t, func(req *stun.Message, addr net.Addr) *stun.Message {
+		if req.Type != RefreshRequest {
+			return allocateHandler(t, req, addr)
+		}
+		return stun.MustBuild(req, stun.NewType(req.Type.Method, stun.ClassErrorResponse),
+			code.Load().(stun.ErrorCode),
+		)
+	})
+	defer s.Close()
+	c, a := newTestAllocation(t, s)
+	defer c.Close()
+	// Stopping refresh loop to call maintain directly.
+	close(a.closed)
+	<-a.done
+	a.mux.Lock()
+	refreshAt, expiresAt := a.refreshAt, a.expiresAt
+	a.mux.Unlock()
+	next, err := a.maintain(time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !next.Equal(refreshAt) {
+		t.Error("should not refresh before refreshAt")
+	}
+	// Retry on error.
+	now := refreshAt
+	next, err = a.maintain(now)
+	if err == nil {
+		t.Error("should error")
+	}
+	if !next.Equal(now.Add(refreshRetryInterval)) {
+		t.Errorf("unexpected next %s", next)
+	}
+	// Retrying until expiration.
+	if next, err = a.maintain(expiresAt.Add(-time.Second)); !next.Equal(expiresAt) || err == nil {
+		t.Errorf("unexpected next %s or error %v", next, err)
+	}
+	if next, err = a.maintain(expiresAt); err != ErrAllocationExpired || !next.IsZero() {
+		t.Errorf("unexpected next %s or error %v", next, err)
+	}
+	// Allocation mismatch means that allocation does not exist anymore.
+	code.Store(stun.CodeAllocMismatch)
+	if _, err = a.maintain(now); err != ErrAllocationExpired {
+		t.Errorf("unexpected error %v", err)
+	}
+	if err = a.deallocate(); err != nil {
+		t.Errorf("allocation mismatch should be ignored on close: %v", err)
+	}
+}
+
+func TestAllocation_OnRefreshError(t *testing.T) {
+	s := newFakeServer(t, func(req *stun.Message, addr net.Addr) *stun.Message {
+		if req.Type != RefreshRequest {
+			return allocateHandler(t, req, addr)
+		}
+		return stun.MustBuild(req, stun.NewType(req.Type.Method, stun.ClassErrorResponse), stun.CodeAllocMismatch)
+	})
+	defer s.Close()
+	c := newTestClient(t, s)
+	defer c.Close()
+	errs := make(chan error, 1)
+	c.onRefreshError = func(err error) { errs <- err }
+	a, err := c.Allocate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	a.mux.Lock()
+	a.refreshAt = time.Now()
+	a.mux.Unlock()
+	a.reschedule()
+	select {
+	case err = <-errs:
+		if err != ErrAllocationExpired {
+			t.Errorf("unexpected error %v", err)
+		}
+	case <-time.After(time.Second * 5):
+		t.Fatal("no error reported")
+	}
+	if _, _, err = a.ReadFrom(make([]byte, 10)); err != ErrAllocationClosed {
+		t.Errorf("unexpected read error %v", err)
+	}
+	// New allocation can be created after expiration.
+	next, err := c.Allocate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = next.Close(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestAllocation_OnRefreshError_Close(t *testing.T) {
+	s := newFakeServer(t, func(req *stun.Message, addr net.Addr) *stun.Message {
+		if req.Type != RefreshRequest {
+			return allocateHandler(t, req, addr)
+		}
+		return stun.MustBuild(req, stun.NewType(req.Type.Method, stun.ClassErrorResponse), stun.CodeAllocMismatch)
+	})
+	defer s.Close()
+	c := newTestClient(t, s)
+	defer c.Close()
+	var a *Allocation
+	closed := make(chan error, 1)
+	c.onRefreshError = func(err error) { closed <- a.Close() }
+	a, err := c.Allocate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	a.mux.Lock()
+	a.refreshAt = time.Now()
+	a.mux.Unlock()
+	a.reschedule()
+	select {
+	case err = <-closed:
+		if err != nil {
+			t.Error(err)
+		}
+	case <-time.After(time.Second * 5):
+		t.Fatal("Close from OnRefreshError is blocked")
+	}
+}
@@ -32,3 +32,65 @@ func (a *RelayedAddress) GetFrom(m *stun.Message) error {
 }
 
 type XORRelayedAddress = RelayedAddress
+
+// RelayedAddresses is list of XOR-RELAYED-ADDRESS attributes.
+//
+// Server can allocate both IPv4 and IPv6 relayed addresses in single
+// Allocate transaction, including XOR-RELAYED-ADDRESS for each of them.
+//
+// RFC 8656 Section 7.3
+type RelayedAddresses []RelayedAddress
+
+// AddTo adds XOR-RELAYED-ADDRESS to message for every address.
+func (a RelayedAddresses) AddTo(m *stun.Message) error {
+	for _, addr := range a {
+		if err := addr.AddTo(m); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetFrom decodes all XOR-RELAYED-ADDRESS attributes from message in
+// order of appearance.
+func (a *RelayedAddresses) GetFrom(m *stun.Message) error {
+	addrs := (*a)[:0]
+	err := forEachAttr(m, stun.AttrXORRelayedAddress, func(single *stun.Message) error {
+		var addr RelayedAddress
+		if err := addr.GetFrom(single); err != nil {
+			return err
+		}
+		addrs = append(addrs, addr)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	*a = addrs
+	return nil
+}
+
+// forEachAttr calls f for every attribute of type t in m with message that
+// contains only that attribute, because getters return only the first
+// attribute of type. Returns stun.ErrAttributeNotFound if there are no
+// such attributes.
+func forEachAttr(m *stun.Message, t stun.AttrType, f func(single *stun.Message) error) error {
+	found := false
+	for _, attr := range m.Attributes {
+		if attr.Type != t {
+			continue
+		}
+		found = true
+		single := stun.Message{
+			TransactionID: m.TransactionID,
+			Attributes:    stun.Attributes{attr},
+		}
+		if err := f(&single); err != nil {
+			return err
+		}
+	}
+	if !found {
+		return stun.ErrAttributeNotFound
+	}
+	return nil
+}
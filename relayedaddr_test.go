@@ -30,3 +30,35 @@ func TestRelayedAddress(t *testing.T) {
 		t.Fatal(err)
 	}
 }
+
+func TestRelayedAddresses(t *testing.T) {
+	addrs := RelayedAddresses{
+		{IP: net.IPv4(111, 11, 1, 2), Port: 333},
+		{IP: net.ParseIP("2001:db8::1"), Port: 444},
+	}
+	m, err := stun.Build(stun.TransactionID, addrs, Lifetime{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded := new(stun.Message)
+	if _, err = decoded.Write(m.Raw); err != nil {
+		t.Fatal(err)
+	}
+	var got RelayedAddresses
+	if err = got.GetFrom(decoded); err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != len(addrs) {
+		t.Fatalf("unexpected count %d", len(got))
+	}
+	for i := range addrs {
+		if got[i].String() != addrs[i].String() {
+			t.Errorf("%s (got) != %s (expected)", got[i], addrs[i])
+		}
+	}
+	t.Run("NotFound", func(t *testing.T) {
+		if err := got.GetFrom(new(stun.Message)); err != stun.ErrAttributeNotFound {
+			t.Errorf("unexpected error %v", err)
+		}
+	})
+}
@@ -10,12 +10,16 @@ import (
 type Protocol byte
 
 const (
+	// ProtoTCP is IANA assigned protocol number for TCP.
+	ProtoTCP Protocol = 6
 	// ProtoUDP is IANA assigned protocol number for UDP.
 	ProtoUDP Protocol = 17
 )
 
 func (p Protocol) String() string {
 	switch p {
+	case ProtoTCP:
+		return "TCP"
 	case ProtoUDP:
 		return "UDP"
 	default:
@@ -27,9 +31,10 @@ func (p Protocol) String() string {
 //
 // This attribute is used by the client to request a specific transport
 // protocol for the allocated transport address. RFC 5766 only allows the use of
-// codepoint 17 (User Datagram Protocol).
+// codepoint 17 (User Datagram Protocol), while RFC 6062 allows codepoint
+// 6 (Transmission Control Protocol).
 //
-// RFC 5766 Section 14.7
+// RFC 5766 Section 14.7, RFC 6062 Section 6.1
 type RequestedTransport struct {
 	Protocol Protocol
 }
@@ -69,3 +74,9 @@ func (t *RequestedTransport) GetFrom(m *stun.Message) error {
 var RequestedTransportUDP stun.Setter = RequestedTransport{
 	Protocol: ProtoUDP,
 }
+
+// RequestedTransportTCP is setter for requested transport attribute with
+// value ProtoTCP (6).
+var RequestedTransportTCP stun.Setter = RequestedTransport{
+	Protocol: ProtoTCP,
+}
@@ -91,3 +91,20 @@ func TestRequestedTransport(t *testing.T) {
 		})
 	})
 }
+
+func TestRequestedTransportTCP(t *testing.T) {
+	if ProtoTCP.String() != "TCP" {
+		t.Errorf("bad string %q", ProtoTCP)
+	}
+	m := new(stun.Message)
+	if err := RequestedTransportTCP.AddTo(m); err != nil {
+		t.Fatal(err)
+	}
+	var r RequestedTransport
+	if err := r.GetFrom(m); err != nil {
+		t.Fatal(err)
+	}
+	if r.Protocol != ProtoTCP {
+		t.Errorf("unexpected protocol %s", r.Protocol)
+	}
+}
@@ -0,0 +1,310 @@
+package turn
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// NAPTR represents DNS NAPTR record as defined in RFC 3403.
+type NAPTR struct {
+	Order       uint16
+	Preference  uint16
+	Flags       string
+	Service     string
+	Regexp      string
+	Replacement string
+}
+
+// Resolver performs DNS lookups that are required for TURN server
+// resolution. The *net.Resolver can be used to implement it.
+type Resolver interface {
+	LookupNAPTR(ctx context.Context, name string) ([]*NAPTR, error)
+	LookupSRV(ctx context.Context, service, proto, name string) (string, []*net.SRV, error)
+	LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error)
+}
+
+// NetResolver implements Resolver using *net.Resolver. Because standard
+// library does not support NAPTR lookups, they always return no records,
+// so resolution starts with SRV lookups.
+type NetResolver struct {
+	*net.Resolver
+}
+
+// LookupNAPTR returns no records.
+func (NetResolver) LookupNAPTR(ctx context.Context, name string) ([]*NAPTR, error) {
+	return nil, nil
+}
+
+// Candidate is transport address of TURN server that was obtained
+// by resolution process.
+type Candidate struct {
+	Secure    bool   // TLS or DTLS
+	Transport string // TransportUDP or TransportTCP
+	Addr      Addr
+}
+
+func (c Candidate) String() string {
+	addr := net.JoinHostPort(c.Addr.IP.String(), strconv.Itoa(c.Addr.Port))
+	switch {
+	case c.Secure && c.Transport == TransportTCP:
+		return "tls://" + addr
+	case c.Secure:
+		return "dtls://" + addr
+	default:
+		return c.Transport + "://" + addr
+	}
+}
+
+// ErrNoCandidates means that resolution produced no candidates.
+var ErrNoCandidates = errors.New("no candidates found")
+
+// transport is transport that can be used to reach TURN server.
+type transport struct {
+	secure bool
+	proto  string // TransportUDP or TransportTCP
+}
+
+// naptrService returns NAPTR service field value for transport.
+//
+// RFC 5928 Section 4, RFC 7350 Section 4.2
+func (t transport) naptrService() string {
+	switch {
+	case t.secure && t.proto == TransportTCP:
+		return "RELAY:turn.tls"
+	case t.secure:
+		return "RELAY:turn.dtls"
+	default:
+		return "RELAY:turn." + t.proto
+	}
+}
+
+// srvService returns service part of SRV name.
+func (t transport) srvService() string {
+	if t.secure {
+		return SchemeSecure
+	}
+	return Scheme
+}
+
+func (t transport) defaultPort() int {
+	if t.secure {
+		return DefaultTLSPort
+	}
+	return DefaultPort
+}
+
+// uriTransports returns ordered list of transports that can be used
+// for URI.
+func uriTransports(uri URI) []transport {
+	secure := uri.Scheme == SchemeSecure
+	switch {
+	case uri.Transport != "":
+		return []transport{{secure: secure, proto: uri.Transport}}
+	case secure:
+		return []transport{{secure: true, proto: TransportTCP}}
+	default:
+		return []transport{
+			{proto: TransportUDP},
+			{proto: TransportTCP},
+		}
+	}
+}
+
+// Resolve resolves TURN URI to ordered list of candidate transport
+// addresses using provided resolver or NetResolver with
+// net.DefaultResolver if r is nil.
+//
+// If URI host is not IP address and port is not specified, NAPTR
+// lookup is performed (if transport is not specified) followed by SRV
+// lookups, falling back to A/AAAA lookup with default port.
+//
+// RFC 5928 Section 3
+func Resolve(ctx context.Context, uri URI, r Resolver) ([]Candidate, error) {
+	if r == nil {
+		r = NetResolver{Resolver: net.DefaultResolver}
+	}
+	transports := uriTransports(uri)
+	if uri.Port != 0 || net.ParseIP(uri.Host) != nil {
+		// No NAPTR or SRV lookups for explicit address.
+		t := transports[0]
+		port := uri.Port
+		if port == 0 {
+			port = t.defaultPort()
+		}
+		return lookupHost(ctx, r, t, uri.Host, port)
+	}
+	if uri.Transport == "" {
+		candidates, err := resolveNAPTR(ctx, r, uri.Host, transports)
+		if err != nil {
+			return nil, err
+		}
+		if len(candidates) > 0 {
+			return candidates, nil
+		}
+	}
+	var candidates []Candidate
+	for _, t := range transports {
+		_, records, err := r.LookupSRV(ctx, t.srvService(), t.proto, uri.Host)
+		if err != nil && !isNotFound(err) {
+			return nil, err
+		}
+		c, err := resolveSRV(ctx, r, t, records)
+		if err != nil {
+			return nil, err
+		}
+		candidates = append(candidates, c...)
+	}
+	if len(candidates) > 0 {
+		return candidates, nil
+	}
+	return lookupHost(ctx, r, transports[0], uri.Host, transports[0].defaultPort())
+}
+
+// resolveNAPTR performs NAPTR lookup and resolves SRV records from
+// replacement fields of NAPTR records with supported services.
+func resolveNAPTR(ctx context.Context, r Resolver, host string, transports []transport) ([]Candidate, error) {
+	records, err := r.LookupNAPTR(ctx, host)
+	if err != nil && !isNotFound(err) {
+		return nil, err
+	}
+	sort.SliceStable(records, func(i, j int) bool {
+		if records[i].Order != records[j].Order {
+			return records[i].Order < records[j].Order
+		}
+		return records[i].Preference < records[j].Preference
+	})
+	var candidates []Candidate
+	for _, record := range records {
+		if !strings.EqualFold(record.Flags, "s") {
+			// Only SRV terminal lookups are supported.
+			continue
+		}
+		for _, t := range transports {
+			if !strings.EqualFold(record.Service, t.naptrService()) {
+				continue
+			}
+			_, srv, srvErr := r.LookupSRV(ctx, "", "", record.Replacement)
+			if srvErr != nil && !isNotFound(srvErr) {
+				return nil, srvErr
+			}
+			c, srvErr := resolveSRV(ctx, r, t, srv)
+			if srvErr != nil {
+				return nil, srvErr
+			}
+			candidates = append(candidates, c...)
+		}
+	}
+	return candidates, nil
+}
+
+// resolveSRV orders SRV records and resolves their targets.
+func resolveSRV(ctx context.Context, r Resolver, t transport, records []*net.SRV) ([]Candidate, error) {
+	var candidates []Candidate
+	for _, srv := range orderSRV(records, rand.Intn) {
+		if srv.Target == "." {
+			// Service is decidedly not available.
+			continue
+		}
+		c, err := lookupHost(ctx, r, t, srv.Target, int(srv.Port))
+		if err != nil && err != ErrNoCandidates {
+			return nil, err
+		}
+		candidates = append(candidates, c...)
+	}
+	return candidates, nil
+}
+
+// orderSRV returns SRV records sorted by priority with weighted random
+// ordering among records with equal priority, where intn returns random
+// number in [0, n).
+//
+// RFC 2782
+func orderSRV(records []*net.SRV, intn func(n int) int) []*net.SRV {
+	sorted := make([]*net.SRV, len(records))
+	copy(sorted, records)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Priority < sorted[j].Priority
+	})
+	for start := 0; start < len(sorted); {
+		end := start + 1
+		for end < len(sorted) && sorted[end].Priority == sorted[start].Priority {
+			end++
+		}
+		shuffleByWeight(sorted[start:end], intn)
+		start = end
+	}
+	return sorted
+}
+
+// shuffleByWeight orders records of equal priority by weighted
+// random selection. Records with zero weight are placed first, so they
+// have small chance of being selected.
+//
+// RFC 2782
+func shuffleByWeight(records []*net.SRV, intn func(n int) int) {
+	sort.SliceStable(records, func(i, j int) bool {
+		return records[i].Weight == 0 && records[j].Weight != 0
+	})
+	sum := 0
+	for _, srv := range records {
+		sum += int(srv.Weight)
+	}
+	for i := range records {
+		if sum == 0 {
+			return
+		}
+		n := intn(sum + 1)
+		for j := i; j < len(records); j++ {
+			n -= int(records[j].Weight)
+			if n <= 0 {
+				// Moving selected record, keeping order of others.
+				selected := records[j]
+				copy(records[i+1:j+1], records[i:j])
+				records[i] = selected
+				break
+			}
+		}
+		sum -= int(records[i].Weight)
+	}
+}
+
+// lookupHost resolves host to candidates.
+func lookupHost(ctx context.Context, r Resolver, t transport, host string, port int) ([]Candidate, error) {
+	var ips []net.IP
+	if ip := net.ParseIP(host); ip != nil {
+		ips = append(ips, ip)
+	} else {
+		addrs, err := r.LookupIPAddr(ctx, host)
+		if err != nil && !isNotFound(err) {
+			return nil, err
+		}
+		for _, a := range addrs {
+			ips = append(ips, a.IP)
+		}
+	}
+	if len(ips) == 0 {
+		return nil, ErrNoCandidates
+	}
+	candidates := make([]Candidate, 0, len(ips))
+	for _, ip := range ips {
+		candidates = append(candidates, Candidate{
+			Secure:    t.secure,
+			Transport: t.proto,
+			Addr:      Addr{IP: ip, Port: port},
+		})
+	}
+	return candidates, nil
+}
+
+// isNotFound returns true if err means that there is no records.
+func isNotFound(err error) bool {
+	if dnsErr, ok := err.(*net.DNSError); ok {
+		return !dnsErr.IsTimeout && !dnsErr.IsTemporary
+	}
+	return false
+}
@@ -0,0 +1,279 @@
+package turn
+
+import (
+	"context"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// fakeResolver is in-memory DNS for resolution tests.
+type fakeResolver struct {
+	naptr map[string][]*NAPTR
+	srv   map[string][]*net.SRV // keyed by full SRV name
+	hosts map[string][]net.IP
+	err   error
+}
+
+var _ Resolver = NetResolver{}
+
+func (r *fakeResolver) LookupNAPTR(ctx context.Context, name string) ([]*NAPTR, error) {
+	if r.err != nil {
+		return nil, r.err
+	}
+	return r.naptr[name], nil
+}
+
+func (r *fakeResolver) LookupSRV(ctx context.Context, service, proto, name string) (string, []*net.SRV, error) {
+	if service != "" || proto != "" {
+		name = "_" + service + "._" + proto + "." + name
+	}
+	records, ok := r.srv[name]
+	if !ok {
+		return "", nil, &net.DNSError{Err: "no such host", Name: name}
+	}
+	return name, records, nil
+}
+
+func (r *fakeResolver) LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error) {
+	var addrs []net.IPAddr
+	for _, ip := range r.hosts[host] {
+		addrs = append(addrs, net.IPAddr{IP: ip})
+	}
+	return addrs, nil
+}
+
+func candidatesString(candidates []Candidate) string {
+	s := make([]string, 0, len(candidates))
+	for _, c := range candidates {
+		s = append(s, c.String())
+	}
+	return strings.Join(s, " ")
+}
+
+func TestResolve(t *testing.T) {
+	r := &fakeResolver{
+		naptr: map[string][]*NAPTR{
+			"naptr.example.org": {
+				{Order: 20, Flags: "s", Service: "RELAY:turn.tcp", Replacement: "_turn._tcp.example.org"},
+				{Order: 10, Flags: "S", Service: "RELAY:turn.udp", Replacement: "_turn._udp.example.org"},
+				{Order: 10, Preference: 5, Flags: "S", Service: "RELAY:turn.tls", Replacement: "_turns._tcp.example.org"},
+				{Order: 5, Flags: "A", Service: "RELAY:turn.udp", Replacement: "a.example.org"},
+			},
+		},
+		srv: map[string][]*net.SRV{
+			"_turn._udp.example.org": {
+				{Target: "b.example.org", Port: 3479, Priority: 20},
+				{Target: "a.example.org", Port: 3478, Priority: 10},
+			},
+			"_turn._tcp.example.org": {
+				{Target: "a.example.org", Port: 3478, Priority: 10},
+			},
+			"_turns._tcp.example.org": {
+				{Target: "a.example.org", Port: 5349, Priority: 10},
+			},
+			"_turn._udp.srv.example.org": {
+				{Target: ".", Port: 3478},
+			},
+			"_turn._tcp.srv.example.org": {
+				{Target: "a.example.org", Port: 3480},
+			},
+		},
+		hosts: map[string][]net.IP{
+			"a.example.org":       {net.IPv4(10, 0, 0, 1), net.ParseIP("2001:db8::1")},
+			"b.example.org":       {net.IPv4(10, 0, 0, 2)},
+			"naptr.example.org":   {net.IPv4(10, 0, 0, 3)},
+			"example.org":         {net.IPv4(10, 0, 0, 4)},
+			"srv.example.org":     {net.IPv4(10, 0, 0, 5)},
+			"nothing.example.org": {net.IPv4(10, 0, 0, 6)},
+		},
+	}
+	for _, tc := range []struct {
+		uri  string
+		out  string
+		fail bool
+	}{
+		{
+			uri: "turn:10.0.0.10",
+			out: "udp://10.0.0.10:3478",
+		},
+		{
+			uri: "turns:[2001:db8::10]",
+			out: "tls://[2001:db8::10]:5349",
+		},
+		{
+			uri: "turn:a.example.org:1000?transport=tcp",
+			out: "tcp://10.0.0.1:1000 tcp://[2001:db8::1]:1000",
+		},
+		{
+			uri: "turn:naptr.example.org",
+			out: "udp://10.0.0.1:3478 udp://[2001:db8::1]:3478 udp://10.0.0.2:3479 " +
+				"tcp://10.0.0.1:3478 tcp://[2001:db8::1]:3478",
+		},
+		{
+			uri: "turns:naptr.example.org",
+			out: "tls://10.0.0.1:5349 tls://[2001:db8::1]:5349",
+		},
+		{
+			uri: "turn:naptr.example.org?transport=tcp",
+			out: "tcp://10.0.0.3:3478",
+		},
+		{
+			uri: "turn:example.org",
+			out: "udp://10.0.0.1:3478 udp://[2001:db8::1]:3478 udp://10.0.0.2:3479 " +
+				"tcp://10.0.0.1:3478 tcp://[2001:db8::1]:3478",
+		},
+		{
+			uri: "turns:example.org",
+			out: "tls://10.0.0.1:5349 tls://[2001:db8::1]:5349",
+		},
+		{
+			uri: "turn:srv.example.org",
+			out: "tcp://10.0.0.1:3480 tcp://[2001:db8::1]:3480",
+		},
+		{
+			uri: "turn:nothing.example.org",
+			out: "udp://10.0.0.6:3478",
+		},
+		{
+			uri: "turns:nothing.example.org",
+			out: "tls://10.0.0.6:5349",
+		},
+		{
+			uri:  "turn:unknown.example.org",
+			fail: true,
+		},
+	} {
+		t.Run(tc.uri, func(t *testing.T) {
+			uri, err := ParseURI(tc.uri)
+			if err != nil {
+				t.Fatal(err)
+			}
+			candidates, err := Resolve(context.Background(), uri, r)
+			if tc.fail {
+				if err != ErrNoCandidates {
+					t.Errorf("unexpected error %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if s := candidatesString(candidates); s != tc.out {
+				t.Errorf("%s (got) != %s (expected)", s, tc.out)
+			}
+		})
+	}
+	t.Run("Error", func(t *testing.T) {
+		failing := &fakeResolver{err: &net.DNSError{Err: "timeout", IsTimeout: true}}
+		if _, err := Resolve(context.Background(), URI{Scheme: Scheme, Host: "example.org"}, failing); err != failing.err {
+			t.Errorf("unexpected error %v", err)
+		}
+	})
+	t.Run("DTLS", func(t *testing.T) {
+		candidates, err := Resolve(context.Background(), URI{
+			Scheme: SchemeSecure, Host: "10.0.0.1", Transport: TransportUDP,
+		}, r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if s := candidatesString(candidates); s != "dtls://10.0.0.1:5349" {
+			t.Errorf("unexpected candidates %s", s)
+		}
+	})
+}
+
+func TestOrderSRV(t *testing.T) {
+	records := []*net.SRV{
+		{Target: "d", Priority: 20, Weight: 10},
+		{Target: "a", Priority: 10, Weight: 0},
+		{Target: "b", Priority: 10, Weight: 30},
+		{Target: "c", Priority: 10, Weight: 70},
+	}
+	targets := func(records []*net.SRV) []string {
+		var s []string
+		for _, srv := range records {
+			s = append(s, srv.Target)
+		}
+		return s
+	}
+	for _, tc := range []struct {
+		name string
+		intn func(n int) int
+		out  []string
+	}{
+		{
+			name: "Min",
+			intn: func(n int) int { return 0 },
+			out:  []string{"a", "b", "c", "d"},
+		},
+		{
+			name: "Max",
+			intn: func(n int) int { return n - 1 },
+			out:  []string{"c", "b", "a", "d"},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if out := targets(orderSRV(records, tc.intn)); !reflect.DeepEqual(out, tc.out) {
+				t.Errorf("%v (got) != %v (expected)", out, tc.out)
+			}
+		})
+	}
+	if records[0].Target != "d" {
+		t.Error("records should not be modified")
+	}
+}
+
+func TestOrderSRV_ZeroWeight(t *testing.T) {
+	mixed := []*net.SRV{
+		{Target: "b", Priority: 10, Weight: 30},
+		{Target: "a", Priority: 10, Weight: 0},
+		{Target: "c", Priority: 10, Weight: 70},
+		{Target: "e", Priority: 10, Weight: 0},
+	}
+	for _, tc := range []struct {
+		name    string
+		records []*net.SRV
+		intn    func(n int) int
+		out     []string
+	}{
+		{
+			name:    "Min",
+			records: mixed,
+			intn:    func(n int) int { return 0 },
+			out:     []string{"a", "e", "b", "c"},
+		},
+		{
+			name:    "Max",
+			records: mixed,
+			intn:    func(n int) int { return n - 1 },
+			out:     []string{"c", "b", "a", "e"},
+		},
+		{
+			name:    "Middle",
+			records: mixed,
+			intn:    func(n int) int { return 20 },
+			out:     []string{"b", "c", "a", "e"},
+		},
+		{
+			name: "AllZero",
+			records: []*net.SRV{
+				{Target: "x", Priority: 10},
+				{Target: "y", Priority: 10},
+			},
+			intn: func(n int) int { return n - 1 },
+			out:  []string{"x", "y"},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var out []string
+			for _, srv := range orderSRV(tc.records, tc.intn) {
+				out = append(out, srv.Target)
+			}
+			if !reflect.DeepEqual(out, tc.out) {
+				t.Errorf("%v (got) != %v (expected)", out, tc.out)
+			}
+		})
+	}
+}
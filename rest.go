@@ -0,0 +1,127 @@
+package turn
+
+import (
+	"crypto/hmac"
+	"crypto/sha1" // #nosec
+	"encoding/base64"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"gortc.io/stun"
+)
+
+// RESTCredentials are ephemeral long-term credentials as defined by
+// TURN REST API, also known as "use-auth-secret" in coturn.
+//
+// The username is "timestamp:user", where timestamp is expiration time
+// in seconds since Unix epoch, and password is base64-encoded
+// HMAC-SHA1 of username with secret that is shared between web service
+// and TURN server.
+type RESTCredentials struct {
+	Username string
+	Password string
+}
+
+// restSeparator separates timestamp and user in username.
+const restSeparator = ":"
+
+// RESTPassword returns password for ephemeral username and secret.
+func RESTPassword(secret, username string) string {
+	mac := hmac.New(sha1.New, []byte(secret))
+	_, _ = mac.Write([]byte(username))
+	return base64.StdEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// NewRESTCredentials returns ephemeral credentials for user that are
+// valid for ttl starting from now.
+func NewRESTCredentials(secret, user string, ttl time.Duration, now time.Time) RESTCredentials {
+	username := strconv.FormatInt(now.Add(ttl).Unix(), 10)
+	if user != "" {
+		username += restSeparator + user
+	}
+	return RESTCredentials{
+		Username: username,
+		Password: RESTPassword(secret, username),
+	}
+}
+
+// ParseRESTUsername returns expiration time and user from ephemeral
+// username. The user part is optional.
+func ParseRESTUsername(username string) (expires time.Time, user string, err error) {
+	timestamp := username
+	if i := strings.Index(username, restSeparator); i >= 0 {
+		timestamp, user = username[:i], username[i+len(restSeparator):]
+	}
+	seconds, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return time.Time{}, "", ErrInvalidRESTUsername
+	}
+	return time.Unix(seconds, 0), user, nil
+}
+
+var (
+	// ErrInvalidRESTUsername means that username has no valid timestamp.
+	ErrInvalidRESTUsername = errors.New("invalid ephemeral username")
+	// ErrCredentialsExpired means that ephemeral credentials are expired.
+	ErrCredentialsExpired = errors.New("credentials expired")
+)
+
+// DefaultRESTClockSkew is default maximum clock difference between
+// web service that issues ephemeral credentials and TURN server.
+const DefaultRESTClockSkew = time.Second * 5
+
+// RESTVerifier verifies requests with ephemeral credentials on server.
+//
+// Multiple secrets can be provided for rotation: credentials that were
+// generated with any of them are accepted, so new secret can be added
+// before web service starts to use it and old one removed after all
+// issued credentials are expired.
+type RESTVerifier struct {
+	// Secrets is list of shared secrets.
+	Secrets []string
+	// ClockSkew is maximum clock difference, DefaultRESTClockSkew
+	// if zero.
+	ClockSkew time.Duration
+	// Now returns current time, time.Now if nil.
+	Now func() time.Time
+}
+
+// Verify checks that ephemeral credentials from USERNAME of request are
+// not expired and MESSAGE-INTEGRITY is valid for one of secrets,
+// returning integrity that can be used to sign response.
+func (v *RESTVerifier) Verify(m *stun.Message) (stun.MessageIntegrity, error) {
+	var (
+		username stun.Username
+		realm    stun.Realm
+	)
+	if err := m.Parse(&username, &realm); err != nil {
+		return nil, err
+	}
+	expires, _, err := ParseRESTUsername(username.String())
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	if v.Now != nil {
+		now = v.Now()
+	}
+	skew := v.ClockSkew
+	if skew == 0 {
+		skew = DefaultRESTClockSkew
+	}
+	if now.After(expires.Add(skew)) {
+		return nil, ErrCredentialsExpired
+	}
+	err = stun.ErrIntegrityMismatch
+	for _, secret := range v.Secrets {
+		integrity := stun.NewLongTermIntegrity(username.String(), realm.String(),
+			RESTPassword(secret, username.String()),
+		)
+		if err = integrity.Check(m); err == nil {
+			return integrity, nil
+		}
+	}
+	return nil, err
+}
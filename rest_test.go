@@ -0,0 +1,139 @@
+package turn
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"gortc.io/stun"
+)
+
+func TestNewRESTCredentials(t *testing.T) {
+	now := time.Unix(1234567890-3600, 0)
+	c := NewRESTCredentials("secret", "user", time.Hour, now)
+	if c.Username != "1234567890:user" {
+		t.Errorf("unexpected username %q", c.Username)
+	}
+	if c.Password != "tHbOsg6j8vjx6g3+R1ACZpLMHG0=" {
+		t.Errorf("unexpected password %q", c.Password)
+	}
+	if c = NewRESTCredentials("secret", "", time.Hour, now); c.Username != "1234567890" {
+		t.Errorf("unexpected username %q", c.Username)
+	}
+}
+
+func TestParseRESTUsername(t *testing.T) {
+	for _, tc := range []struct {
+		in      string
+		expires int64
+		user    string
+		err     error
+	}{
+		{in: "1234567890:user", expires: 1234567890, user: "user"},
+		{in: "1234567890:user:with:colons", expires: 1234567890, user: "user:with:colons"},
+		{in: "1234567890", expires: 1234567890},
+		{in: "user:1234567890", err: ErrInvalidRESTUsername},
+		{in: "", err: ErrInvalidRESTUsername},
+	} {
+		t.Run(tc.in, func(t *testing.T) {
+			expires, user, err := ParseRESTUsername(tc.in)
+			if err != tc.err {
+				t.Fatalf("unexpected error %v", err)
+			}
+			if err != nil {
+				return
+			}
+			if expires.Unix() != tc.expires || user != tc.user {
+				t.Errorf("unexpected %s %q", expires, user)
+			}
+		})
+	}
+}
+
+func TestRESTVerifier_Verify(t *testing.T) {
+	now := time.Unix(1234567890, 0)
+	v := &RESTVerifier{
+		Secrets: []string{"new", "old"},
+		Now:     func() time.Time { return now },
+	}
+	build := func(c RESTCredentials) *stun.Message {
+		return stun.MustBuild(stun.TransactionID, AllocateRequest,
+			stun.NewUsername(c.Username), stun.NewRealm(testRealm),
+			stun.NewLongTermIntegrity(c.Username, testRealm, c.Password),
+		)
+	}
+	for _, tc := range []struct {
+		name  string
+		creds RESTCredentials
+		err   error
+	}{
+		{name: "New", creds: NewRESTCredentials("new", "user", time.Hour, now)},
+		{name: "Old", creds: NewRESTCredentials("old", "user", time.Hour, now)},
+		{name: "Skew", creds: NewRESTCredentials("new", "user", -time.Second, now)},
+		{name: "Expired", creds: NewRESTCredentials("new", "user", -time.Minute, now), err: ErrCredentialsExpired},
+		{name: "Unknown", creds: NewRESTCredentials("unknown", "user", time.Hour, now), err: stun.ErrIntegrityMismatch},
+		{name: "Username", creds: RESTCredentials{Username: "user", Password: "secret"}, err: ErrInvalidRESTUsername},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			m := build(tc.creds)
+			integrity, err := v.Verify(m)
+			if err != tc.err {
+				t.Fatalf("unexpected error %v", err)
+			}
+			if err != nil {
+				return
+			}
+			if integrity.Check(m) != nil {
+				t.Error("returned integrity should be valid for request")
+			}
+		})
+	}
+	t.Run("NoUsername", func(t *testing.T) {
+		if _, err := v.Verify(stun.MustBuild(stun.TransactionID, AllocateRequest)); err != stun.ErrAttributeNotFound {
+			t.Errorf("unexpected error %v", err)
+		}
+	})
+}
+
+func TestClient_RESTCredentials(t *testing.T) {
+	v := &RESTVerifier{Secrets: []string{"secret"}}
+	s := newFakeServer(t, func(req *stun.Message, addr net.Addr) *stun.Message {
+		if !req.Contains(stun.AttrMessageIntegrity) {
+			return stun.MustBuild(req, stun.NewType(req.Type.Method, stun.ClassErrorResponse),
+				stun.CodeUnauthorized, stun.NewRealm(testRealm), stun.NewNonce(testNonce),
+			)
+		}
+		integrity, err := v.Verify(req)
+		if err != nil {
+			t.Error(err)
+			return stun.MustBuild(req, stun.NewType(req.Type.Method, stun.ClassErrorResponse),
+				stun.CodeUnauthorized,
+			)
+		}
+		return stun.MustBuild(req, stun.NewType(req.Type.Method, stun.ClassSuccessResponse),
+			RelayedAddress{IP: net.IPv4(10, 0, 0, 1), Port: 5000},
+			Lifetime{time.Minute * 5},
+			integrity, stun.Fingerprint,
+		)
+	})
+	defer s.Close()
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	creds := NewRESTCredentials("secret", "user", time.Hour, time.Now())
+	c, err := NewClient(ClientOptions{
+		Conn:     conn,
+		Server:   s.conn.LocalAddr(),
+		Username: creds.Username,
+		Password: creds.Password,
+		RTO:      time.Millisecond * 50,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if _, err = c.Allocate(); err != nil {
+		t.Fatal(err)
+	}
+}
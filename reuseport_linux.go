@@ -0,0 +1,25 @@
+//go:build linux && !mips && !mipsle && !mips64 && !mips64le
+// +build linux,!mips,!mipsle,!mips64,!mips64le
+
+package turn
+
+import "syscall"
+
+// soReusePort is SO_REUSEPORT option, which is missing in syscall
+// package for linux.
+const soReusePort = 0xf
+
+// supportsReusePort is true if sockets can share local address with
+// SO_REUSEPORT.
+const supportsReusePort = true
+
+// setReusePort enables SO_REUSEPORT on socket.
+func setReusePort(c syscall.RawConn) error {
+	var sockErr error
+	if err := c.Control(func(fd uintptr) {
+		sockErr = syscall.SetsockoptInt(int(fd), syscall.SOL_SOCKET, soReusePort, 1)
+	}); err != nil {
+		return err
+	}
+	return sockErr
+}
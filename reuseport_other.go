@@ -0,0 +1,15 @@
+//go:build !linux || mips || mipsle || mips64 || mips64le
+// +build !linux mips mipsle mips64 mips64le
+
+package turn
+
+import "syscall"
+
+// supportsReusePort is true if sockets can share local address with
+// SO_REUSEPORT.
+const supportsReusePort = false
+
+// setReusePort enables SO_REUSEPORT on socket.
+func setReusePort(c syscall.RawConn) error {
+	return errReusePortUnsupported
+}
@@ -0,0 +1,1212 @@
+package turn
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"gortc.io/stun"
+)
+
+// DefaultMaxLifetime is default maximum lifetime of allocation.
+//
+// RFC 5766 Section 6.2
+const DefaultMaxLifetime = time.Hour
+
+// DefaultAuthTimeout is default timeout of Auth lookup.
+const DefaultAuthTimeout = time.Second * 5
+
+// maxAuthLookups is maximum count of concurrent Auth lookups for
+// requests on packet connections.
+const maxAuthLookups = 64
+
+// collectInterval is interval between removals of expired allocations,
+// permissions and channel bindings.
+const collectInterval = time.Second
+
+// ErrServerClosed is returned by Serve after Close.
+var ErrServerClosed = errors.New("server is closed")
+
+// ErrRelayIPRequired is returned by Serve and ServeListener for
+// connection or listener on unspecified address if RelayIP is not set.
+var ErrRelayIPRequired = errors.New("relay IP is required for unspecified local address")
+
+// ServerOptions contains options for NewServer.
+type ServerOptions struct {
+	// RelayIP is IP address on which relayed transport addresses are
+	// allocated. Local IP of connection that received Allocate request
+	// is used if nil, so it is required for connections that are bound
+	// to unspecified address, otherwise ErrRelayIPRequired is returned
+	// on serving them.
+	RelayIP net.IP
+	// Ports is range of ports for relayed transport addresses,
+	// DefaultPortRange if zero.
+	Ports PortRange
+
+	// Lifetime is default lifetime of allocation, DefaultLifetime if zero.
+	Lifetime time.Duration
+	// MaxLifetime is maximum lifetime of allocation, DefaultMaxLifetime
+	// if zero.
+	MaxLifetime time.Duration
+
+	// Quota limits concurrent allocations, no limits if zero. Can be
+	// changed with SetQuota.
+	Quota Quota
+
+	// Bandwidth limits rate of data that is relayed by allocation in
+	// each direction, no limit if zero. UDP data that exceeds the limit
+	// is dropped, while TCP data is delayed.
+	Bandwidth BandwidthLimit
+	// UserBandwidth overrides Bandwidth for usernames.
+	UserBandwidth map[string]BandwidthLimit
+
+	// PeerACL controls peers that data is relayed to, DefaultPeerACL
+	// if nil. Use empty PeerACL to allow all peers.
+	PeerACL *PeerACL
+
+	// Auth enables long-term credential mechanism for requests with
+	// Realm. Requests are not authenticated if both Auth and OAuth
+	// are nil.
+	Auth  Auth
+	Realm string
+	// AuthTimeout limits duration of Auth lookup, DefaultAuthTimeout if
+	// zero. Request is rejected with 500 on timeout.
+	//
+	// Lookups for requests on packet connections are performed in
+	// background, up to 64 at once, and further requests are dropped
+	// until some lookup is done. Lookups for requests on TCP and TLS
+	// connections block reading from connection of the client.
+	AuthTimeout time.Duration
+	// OAuth enables third-party authorization for requests with
+	// ACCESS-TOKEN and Realm, in addition to Auth if it is set.
+	// ACCESS-TOKEN is rejected as unknown attribute if nil.
+	OAuth *OAuthValidator
+	// Nonces issues nonces for authentication, NonceManager with
+	// default options if nil.
+	Nonces *NonceManager
+
+	// Tickets enables mobility, issuing mobility tickets for UDP
+	// allocations of clients on UDP that request it, so they can be
+	// moved to new 5-tuple. Mobility is forbidden if nil.
+	Tickets *TicketIssuer
+}
+
+// Server is embeddable TURN server that relays data between clients and
+// peers through UDP relayed transport addresses, or through TCP relayed
+// transport addresses for clients on TCP or TLS connections.
+//
+// Allocations are kept in table keyed by 5-tuple and are deleted when
+// their lifetime is over, along with permissions and channel bindings.
+//
+// RFC 5766 Section 6-11, RFC 6062 Section 5
+type Server struct {
+	relayIP     net.IP
+	lifetime    time.Duration
+	maxLifetime time.Duration
+	now         func() time.Time
+	auth        Auth
+	authTimeout time.Duration
+	oauth       *OAuthValidator
+	realm       string
+	nonces      *NonceManager
+	tickets     *TicketIssuer
+	ports       *portAllocator
+	acl         *PeerACL
+	quota       *quotaCounter
+	bandwidth   BandwidthLimit
+	users       map[string]BandwidthLimit
+	drops       *DropStats
+
+	// lookups limits concurrent Auth lookups.
+	lookups chan struct{}
+	// ctx is done when server is closed, to cancel auth lookups.
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	mux       sync.Mutex
+	allocs    map[tupleKey]*serverAllocation
+	conns     map[net.PacketConn]struct{}
+	listeners map[net.Listener]struct{}
+	// pending are peer data connections of TCP allocations that are
+	// not bound to client data connections yet.
+	pending map[ConnectionID]*peerConnection
+
+	wg        sync.WaitGroup
+	closeOnce sync.Once
+	closed    chan struct{}
+}
+
+// NewServer creates and initializes new TURN server. Use Serve to
+// handle requests on connections.
+func NewServer(o ServerOptions) (*Server, error) {
+	if o.Lifetime == 0 {
+		o.Lifetime = DefaultLifetime
+	}
+	if o.MaxLifetime == 0 {
+		o.MaxLifetime = DefaultMaxLifetime
+	}
+	if o.AuthTimeout == 0 {
+		o.AuthTimeout = DefaultAuthTimeout
+	}
+	if o.MaxLifetime < o.Lifetime {
+		return nil, errors.New("max lifetime is less than default one")
+	}
+	if o.PeerACL == nil {
+		o.PeerACL = DefaultPeerACL()
+	}
+	if o.Ports == (PortRange{}) {
+		o.Ports = DefaultPortRange
+	}
+	if !o.Ports.valid() {
+		return nil, errors.New("invalid port range")
+	}
+	if (o.Auth != nil || o.OAuth != nil) && o.Nonces == nil {
+		nonces, err := NewNonceManager(NonceOptions{})
+		if err != nil {
+			return nil, err
+		}
+		o.Nonces = nonces
+	}
+	s := &Server{
+		relayIP:     o.RelayIP,
+		lifetime:    o.Lifetime,
+		maxLifetime: o.MaxLifetime,
+		now:         time.Now,
+		auth:        o.Auth,
+		authTimeout: o.AuthTimeout,
+		oauth:       o.OAuth,
+		realm:       o.Realm,
+		nonces:      o.Nonces,
+		tickets:     o.Tickets,
+		ports:       newPortAllocator(o.Ports),
+		acl:         o.PeerACL,
+		quota:       newQuotaCounter(o.Quota),
+		bandwidth:   o.Bandwidth,
+		users:       make(map[string]BandwidthLimit, len(o.UserBandwidth)),
+		drops:       new(DropStats),
+		allocs:      make(map[tupleKey]*serverAllocation),
+		conns:       make(map[net.PacketConn]struct{}),
+		listeners:   make(map[net.Listener]struct{}),
+		pending:     make(map[ConnectionID]*peerConnection),
+		lookups:     make(chan struct{}, maxAuthLookups),
+		closed:      make(chan struct{}),
+	}
+	for username, limit := range o.UserBandwidth {
+		s.users[username] = limit
+	}
+	s.ctx, s.cancel = context.WithCancel(context.Background())
+	s.wg.Add(1)
+	go s.collectUntilClosed()
+	return s, nil
+}
+
+// Serve reads requests from conn and handles them until conn or server
+// is closed. The conn is closed by Close.
+func (s *Server) Serve(conn net.PacketConn) error {
+	if err := s.checkLocalAddr(conn.LocalAddr()); err != nil {
+		return err
+	}
+	if !s.addConn(conn) {
+		return ErrServerClosed
+	}
+	defer s.removeConn(conn)
+	buf := make([]byte, maxPacketSize)
+	for {
+		n, addr, err := conn.ReadFrom(buf)
+		if err != nil {
+			select {
+			case <-s.closed:
+				return ErrServerClosed
+			default:
+			}
+			if ne, ok := err.(net.Error); ok && ne.Temporary() {
+				continue
+			}
+			return err
+		}
+		s.handle(conn, buf[:n], addr)
+	}
+}
+
+// checkLocalAddr returns ErrRelayIPRequired if relayed transport
+// addresses can't be allocated on IP of local address.
+func (s *Server) checkLocalAddr(addr net.Addr) error {
+	if s.relayIP != nil {
+		return nil
+	}
+	local, err := peerAddr(addr)
+	if err != nil {
+		return err
+	}
+	if local.IP.IsUnspecified() {
+		return ErrRelayIPRequired
+	}
+	return nil
+}
+
+// addConn adds conn to served connections, so it is closed by Close.
+// Returns false if server is closed.
+func (s *Server) addConn(conn net.PacketConn) bool {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	select {
+	case <-s.closed:
+		return false
+	default:
+	}
+	s.conns[conn] = struct{}{}
+	return true
+}
+
+func (s *Server) removeConn(conn net.PacketConn) {
+	s.mux.Lock()
+	delete(s.conns, conn)
+	s.mux.Unlock()
+}
+
+// Close closes served connections and listeners and deletes all
+// allocations.
+func (s *Server) Close() error {
+	s.closeOnce.Do(func() {
+		close(s.closed)
+		s.cancel()
+		s.mux.Lock()
+		for l := range s.listeners {
+			_ = l.Close()
+		}
+		for conn := range s.conns {
+			_ = conn.Close()
+		}
+		for k, a := range s.allocs {
+			delete(s.allocs, k)
+			a.close()
+		}
+		s.mux.Unlock()
+		s.ports.close()
+		s.wg.Wait()
+	})
+	return nil
+}
+
+// SetQuota replaces allocation quota. Existing allocations are not
+// affected even if quota is exceeded.
+func (s *Server) SetQuota(q Quota) {
+	s.quota.set(q)
+}
+
+// DropStats are counters of data that was dropped by bandwidth limits.
+// Data of TCP allocations is delayed instead, so it is not counted.
+type DropStats struct {
+	Packets uint64
+	Bytes   uint64
+}
+
+// ServerStats is snapshot of server statistics.
+type ServerStats struct {
+	Allocations int
+	Quota       QuotaStats
+	Dropped     DropStats
+}
+
+// Stats returns current server statistics.
+func (s *Server) Stats() ServerStats {
+	s.mux.Lock()
+	allocations := len(s.allocs)
+	s.mux.Unlock()
+	return ServerStats{
+		Allocations: allocations,
+		Quota:       s.quota.stats(),
+		Dropped: DropStats{
+			Packets: atomic.LoadUint64(&s.drops.Packets),
+			Bytes:   atomic.LoadUint64(&s.drops.Bytes),
+		},
+	}
+}
+
+// userBandwidth returns bandwidth limit for username.
+func (s *Server) userBandwidth(username string) BandwidthLimit {
+	if limit, ok := s.users[username]; ok {
+		return limit
+	}
+	return s.bandwidth
+}
+
+// limit returns true if n bytes can be relayed according to bucket,
+// otherwise counts them as dropped.
+func (s *Server) limit(b *tokenBucket, n int, now time.Time) bool {
+	if b.allow(n, now) {
+		return true
+	}
+	atomic.AddUint64(&s.drops.Packets, 1)
+	atomic.AddUint64(&s.drops.Bytes, uint64(n))
+	return false
+}
+
+// request is STUN request or indication that is handled by server.
+type request struct {
+	conn  net.PacketConn
+	addr  net.Addr
+	tuple FiveTuple
+	m     *stun.Message
+
+	// username and key of authenticated request.
+	username string
+	key      stun.MessageIntegrity
+}
+
+// tuple returns 5-tuple for client address on conn. The transport
+// protocol is TCP for TCP and TLS connections.
+func (s *Server) tuple(conn net.PacketConn, addr net.Addr) (FiveTuple, error) {
+	client, err := peerAddr(addr)
+	if err != nil {
+		return FiveTuple{}, err
+	}
+	server, err := peerAddr(conn.LocalAddr())
+	if err != nil {
+		return FiveTuple{}, err
+	}
+	proto := ProtoUDP
+	if isReliable(conn) {
+		proto = ProtoTCP
+	}
+	return FiveTuple{Client: client, Server: server, Proto: proto}, nil
+}
+
+func (s *Server) handle(conn net.PacketConn, buf []byte, addr net.Addr) {
+	tuple, err := s.tuple(conn, addr)
+	if err != nil {
+		return
+	}
+	if IsChannelData(buf) {
+		s.handleChannelData(tuple, buf)
+		return
+	}
+	if !stun.IsMessage(buf) {
+		return
+	}
+	// Message is valid only during handling, because buf is reused.
+	m := &stun.Message{Raw: buf}
+	if err = m.Decode(); err != nil {
+		return
+	}
+	r := &request{conn: conn, addr: addr, tuple: tuple, m: m}
+	if (s.auth != nil || s.oauth != nil) && m.Type.Class == stun.ClassRequest && m.Type != stun.BindingRequest {
+		if !isReliable(conn) {
+			s.handleAuthenticated(r)
+			return
+		}
+		// Stream connections have their own read loops, and
+		// ConnectionBind request takes over connection.
+		if !s.authenticate(r) {
+			return
+		}
+	}
+	s.dispatch(r)
+}
+
+// handleAuthenticated authenticates request and handles it in separate
+// goroutine, so slow Auth lookups don't delay relaying of data on shared
+// connection. Request is dropped if there are maxAuthLookups in
+// progress, and client retransmits it.
+func (s *Server) handleAuthenticated(r *request) {
+	// Message is valid only during handling, so it is copied.
+	m := new(stun.Message)
+	if err := r.m.CloneTo(m); err != nil {
+		return
+	}
+	r.m = m
+	select {
+	case s.lookups <- struct{}{}:
+	default:
+		return
+	}
+	s.mux.Lock()
+	select {
+	case <-s.closed:
+		s.mux.Unlock()
+		<-s.lookups
+		return
+	default:
+	}
+	s.wg.Add(1)
+	s.mux.Unlock()
+	go func() {
+		defer s.wg.Done()
+		authenticated := s.authenticate(r)
+		<-s.lookups
+		if authenticated {
+			s.dispatch(r)
+		}
+	}()
+}
+
+// dispatch handles request or indication by its type.
+func (s *Server) dispatch(r *request) {
+	m, tuple := r.m, r.tuple
+	if unknown := s.unknownAttributes(m); len(unknown) > 0 {
+		if m.Type.Class == stun.ClassRequest {
+			s.fail(r, stun.CodeUnknownAttribute, unknown)
+		}
+		// Indications with unknown attributes are discarded.
+		return
+	}
+	switch m.Type {
+	case stun.BindingRequest:
+		s.success(r, &stun.XORMappedAddress{IP: tuple.Client.IP, Port: tuple.Client.Port})
+	case AllocateRequest:
+		s.allocate(r)
+	case RefreshRequest:
+		s.refresh(r)
+	case CreatePermissionRequest:
+		s.createPermission(r)
+	case ChannelBindRequest:
+		s.channelBind(r)
+	case SendIndication:
+		s.handleSend(r)
+	case ConnectRequest:
+		s.connect(r)
+	case ConnectionBindRequest:
+		s.connectionBind(r)
+	default:
+		if m.Type.Class == stun.ClassRequest {
+			s.fail(r, stun.CodeBadRequest)
+		}
+	}
+}
+
+// supportedAttributes are comprehension-required attributes that are
+// understood by server.
+var supportedAttributes = map[stun.AttrType]bool{
+	stun.AttrUsername:               true,
+	stun.AttrMessageIntegrity:       true,
+	stun.AttrRealm:                  true,
+	stun.AttrNonce:                  true,
+	stun.AttrXORMappedAddress:       true,
+	stun.AttrChannelNumber:          true,
+	stun.AttrLifetime:               true,
+	stun.AttrXORPeerAddress:         true,
+	stun.AttrData:                   true,
+	stun.AttrRequestedAddressFamily: true,
+	stun.AttrRequestedTransport:     true,
+	stun.AttrEvenPort:               true,
+	stun.AttrReservationToken:       true,
+	stun.AttrConnectionID:           true,
+	// DONT-FRAGMENT is rejected with 420 on Allocate request and Send
+	// indications with it are discarded if DF bit can't be set.
+	//
+	// RFC 5766 Section 6.2 and Section 10.2
+	stun.AttrDontFragment: supportsDontFragment,
+}
+
+// unknownAttributes returns comprehension-required attributes of m that
+// are not supported. ACCESS-TOKEN is supported only with OAuth.
+//
+// RFC 5389 Section 7.3.1, RFC 7635 Section 6.2
+func (s *Server) unknownAttributes(m *stun.Message) stun.UnknownAttributes {
+	var unknown stun.UnknownAttributes
+	for _, a := range m.Attributes {
+		if a.Type == AttrAccessToken && s.oauth != nil {
+			continue
+		}
+		if !a.Type.Optional() && !supportedAttributes[a.Type] {
+			unknown = append(unknown, a.Type)
+		}
+	}
+	return unknown
+}
+
+// response returns response of type t to request, with
+// MESSAGE-INTEGRITY if request was authenticated.
+func (s *Server) response(r *request, t stun.MessageType, setters ...stun.Setter) (*stun.Message, error) {
+	all := make([]stun.Setter, 0, len(setters)+4)
+	all = append(all, r.m, t)
+	all = append(all, setters...)
+	if r.key != nil {
+		all = append(all, r.key)
+	}
+	all = append(all, stun.Fingerprint)
+	return stun.Build(all...)
+}
+
+// respond writes response of type t to request.
+func (s *Server) respond(r *request, t stun.MessageType, setters ...stun.Setter) {
+	res, err := s.response(r, t, setters...)
+	if err != nil {
+		return
+	}
+	_, _ = r.conn.WriteTo(res.Raw, r.addr)
+}
+
+// success writes success response to request.
+func (s *Server) success(r *request, setters ...stun.Setter) {
+	s.respond(r, stun.NewType(r.m.Type.Method, stun.ClassSuccessResponse), setters...)
+}
+
+// fail writes error response with provided code to request.
+func (s *Server) fail(r *request, code stun.ErrorCode, setters ...stun.Setter) {
+	s.respond(r, stun.NewType(r.m.Type.Method, stun.ClassErrorResponse),
+		append([]stun.Setter{code}, setters...)...,
+	)
+}
+
+// authenticate checks long-term credentials or ACCESS-TOKEN of request
+// and returns true if request is authenticated, otherwise writes error
+// response.
+//
+// RFC 5389 Section 10.2.2
+func (s *Server) authenticate(r *request) bool {
+	if !r.m.Contains(stun.AttrMessageIntegrity) {
+		s.challenge(r, stun.CodeUnauthorized)
+		return false
+	}
+	var (
+		username stun.Username
+		realm    stun.Realm
+		nonce    stun.Nonce
+	)
+	if err := r.m.Parse(&username, &realm, &nonce); err != nil {
+		s.fail(r, stun.CodeBadRequest)
+		return false
+	}
+	if err := s.nonces.Check(nonce, r.tuple.Client.IP); err != nil {
+		s.challenge(r, stun.CodeStaleNonce)
+		return false
+	}
+	if realm.String() != s.realm {
+		s.challenge(r, stun.CodeUnauthorized)
+		return false
+	}
+	if s.oauth != nil && r.m.Contains(AttrAccessToken) {
+		return s.authenticateOAuth(r, username.String())
+	}
+	if s.auth == nil {
+		s.challenge(r, stun.CodeUnauthorized)
+		return false
+	}
+	ctx, cancel := context.WithTimeout(s.ctx, s.authTimeout)
+	key, err := s.auth.Key(ctx, username.String(), s.realm)
+	cancel()
+	if err == ErrUnknownUser {
+		s.challenge(r, stun.CodeUnauthorized)
+		return false
+	}
+	if err != nil {
+		s.fail(r, stun.CodeServerError)
+		return false
+	}
+	if err = key.Check(r.m); err != nil {
+		s.challenge(r, stun.CodeUnauthorized)
+		return false
+	}
+	r.username = username.String()
+	r.key = key
+	return true
+}
+
+// authenticateOAuth checks ACCESS-TOKEN of request and returns true
+// if request is authenticated with its mac_key, otherwise writes error
+// response. Key ID from USERNAME is used as username of request.
+//
+// RFC 7635 Section 6.2
+func (s *Server) authenticateOAuth(r *request, kid string) bool {
+	token, err := s.oauth.Validate(r.m)
+	if err != nil {
+		s.challenge(r, stun.CodeUnauthorized)
+		return false
+	}
+	r.username = kid
+	r.key = token.Integrity()
+	return true
+}
+
+// challenge writes 401 or 438 error response with REALM and new NONCE,
+// and THIRD-PARTY-AUTHORIZATION if OAuth is enabled.
+//
+// RFC 5389 Section 10.2.2, RFC 7635 Section 6.1
+func (s *Server) challenge(r *request, code stun.ErrorCode) {
+	var setters []stun.Setter
+	if s.oauth != nil {
+		setters = append(setters, ThirdPartyAuthorization(s.oauth.ServerName))
+	}
+	res, err := s.nonces.challenge(r.m, code, s.realm, r.tuple.Client.IP, setters...)
+	if err != nil {
+		return
+	}
+	_, _ = r.conn.WriteTo(res.Raw, r.addr)
+}
+
+// allocation returns allocation for 5-tuple or nil.
+func (s *Server) allocation(tuple FiveTuple) *serverAllocation {
+	s.mux.Lock()
+	a := s.allocs[tuple.key()]
+	s.mux.Unlock()
+	return a
+}
+
+// requestAllocation returns allocation for request, otherwise writes
+// error response and returns nil.
+//
+// RFC 5766 Section 4
+func (s *Server) requestAllocation(r *request) *serverAllocation {
+	a := s.allocation(r.tuple)
+	if a == nil {
+		s.fail(r, stun.CodeAllocMismatch)
+		return nil
+	}
+	if a.username != r.username {
+		// Requests must use the same username as Allocate request.
+		s.fail(r, stun.CodeWrongCredentials)
+		return nil
+	}
+	return a
+}
+
+// delete removes allocation from table and closes it.
+func (s *Server) delete(a *serverAllocation) {
+	s.mux.Lock()
+	k := a.tuple.key()
+	if s.allocs[k] == a {
+		delete(s.allocs, k)
+	}
+	s.mux.Unlock()
+	a.close()
+}
+
+// allocationLifetime returns lifetime of allocation for request.
+//
+// RFC 5766 Section 6.2 and Section 7.2
+func (s *Server) allocationLifetime(m *stun.Message) time.Duration {
+	var lifetime Lifetime
+	if err := lifetime.GetFrom(m); err != nil {
+		return s.lifetime
+	}
+	if lifetime.Duration > s.maxLifetime {
+		return s.maxLifetime
+	}
+	if lifetime.Duration < s.lifetime {
+		return s.lifetime
+	}
+	return lifetime.Duration
+}
+
+// relayAddr returns IP address for relayed transport address of request.
+func (s *Server) relayAddr(r *request) net.IP {
+	if s.relayIP != nil {
+		return s.relayIP
+	}
+	return r.tuple.Server.IP
+}
+
+// allocate handles Allocate request.
+//
+// RFC 5766 Section 6.2
+func (s *Server) allocate(r *request) {
+	if a := s.allocation(r.tuple); a != nil {
+		if a.transaction == r.m.TransactionID {
+			// Retransmission of request that created allocation.
+			_, _ = r.conn.WriteTo(a.response, r.addr)
+			return
+		}
+		s.fail(r, stun.CodeAllocMismatch)
+		return
+	}
+	var transport RequestedTransport
+	if err := transport.GetFrom(r.m); err != nil {
+		s.fail(r, stun.CodeBadRequest)
+		return
+	}
+	switch transport.Protocol {
+	case ProtoUDP:
+	case ProtoTCP:
+		// RFC 6062 Section 5.1
+		if !isReliable(r.conn) || r.m.Contains(stun.AttrEvenPort) || r.m.Contains(stun.AttrReservationToken) {
+			s.fail(r, stun.CodeBadRequest)
+			return
+		}
+	default:
+		s.fail(r, stun.CodeUnsupportedTransProto)
+		return
+	}
+	if r.m.Contains(AttrMobilityTicket) && (s.tickets == nil || transport.Protocol != ProtoUDP || r.tuple.Proto != ProtoUDP) {
+		// RFC 8016 Section 3.1
+		s.forbidMobility(r)
+		return
+	}
+	if code := s.quota.acquire(r.username, r.tuple.Client.IP); code != 0 {
+		s.fail(r, code)
+		return
+	}
+	release := func() {
+		s.quota.release(r.username, r.tuple.Client.IP)
+	}
+	lifetime := s.allocationLifetime(r.m)
+	now := s.now()
+	a, reserved, code := s.newAllocation(r, transport, now.Add(lifetime))
+	if code != 0 {
+		release()
+		s.fail(r, code)
+		return
+	}
+	a.release = release
+	limit := s.userBandwidth(r.username)
+	a.toPeer = newTokenBucket(limit, now)
+	a.toClient = newTokenBucket(limit, now)
+	setters := []stun.Setter{
+		RelayedAddress(a.relayed),
+		Lifetime{Duration: lifetime},
+		&stun.XORMappedAddress{IP: r.tuple.Client.IP, Port: r.tuple.Client.Port},
+	}
+	if reserved != nil {
+		setters = append(setters, reserved)
+	}
+	if r.m.Contains(AttrMobilityTicket) {
+		// RFC 8016 Section 3.1
+		ticket, err := s.issueTicket(r, now.Add(lifetime))
+		if err != nil {
+			a.close()
+			s.fail(r, stun.CodeServerError)
+			return
+		}
+		a.mobile = true
+		setters = append(setters, ticket)
+	}
+	res, err := s.response(r, stun.NewType(stun.MethodAllocate, stun.ClassSuccessResponse), setters...)
+	if err != nil {
+		a.close()
+		s.fail(r, stun.CodeServerError)
+		return
+	}
+	a.response = res.Raw
+	s.mux.Lock()
+	select {
+	case <-s.closed:
+		s.mux.Unlock()
+		a.close()
+		return
+	default:
+	}
+	k := r.tuple.key()
+	if existing, exists := s.allocs[k]; exists {
+		s.mux.Unlock()
+		a.close()
+		if existing.transaction == r.m.TransactionID {
+			// Retransmission was handled concurrently.
+			_, _ = r.conn.WriteTo(existing.response, r.addr)
+			return
+		}
+		s.fail(r, stun.CodeAllocMismatch)
+		return
+	}
+	s.allocs[k] = a
+	s.wg.Add(1)
+	s.mux.Unlock()
+	go func() {
+		defer s.wg.Done()
+		if a.listener != nil {
+			s.acceptUntilClosed(a)
+			return
+		}
+		s.relayUntilClosed(a)
+	}()
+	_, _ = r.conn.WriteTo(a.response, r.addr)
+}
+
+// newAllocation returns allocation with relayed transport address for
+// Allocate request and token of reserved port if it was requested, or
+// error code.
+func (s *Server) newAllocation(r *request, transport RequestedTransport, expiresAt time.Time) (*serverAllocation, ReservationToken, stun.ErrorCode) {
+	if transport.Protocol == ProtoTCP {
+		l, code := s.listenTCPRelay(r)
+		if code != 0 {
+			return nil, nil, code
+		}
+		return newTCPServerAllocation(r, l, expiresAt), nil, 0
+	}
+	relay, reserved, code := s.listenRelay(r)
+	if code != 0 {
+		return nil, nil, code
+	}
+	return newServerAllocation(r, relay, expiresAt), reserved, 0
+}
+
+// listenRelay returns relay socket for Allocate request and token of
+// reserved port if it was requested, or error code.
+//
+// RFC 5766 Section 6.2, RFC 6156 Section 4.2
+func (s *Server) listenRelay(r *request) (*net.UDPConn, ReservationToken, stun.ErrorCode) {
+	var (
+		evenPort EvenPort
+		token    ReservationToken
+	)
+	hasEvenPort := r.m.Contains(stun.AttrEvenPort)
+	hasToken := r.m.Contains(stun.AttrReservationToken)
+	if hasToken && (hasEvenPort || r.m.Contains(stun.AttrRequestedAddressFamily)) {
+		return nil, nil, stun.CodeBadRequest
+	}
+	if hasToken {
+		if err := token.GetFrom(r.m); err != nil {
+			return nil, nil, stun.CodeBadRequest
+		}
+		relay, ok := s.ports.claim(token, s.now())
+		if !ok {
+			return nil, nil, stun.CodeInsufficientCapacity
+		}
+		return relay, nil, 0
+	}
+	relayIP, code := s.requestedRelayAddr(r)
+	if code != 0 {
+		return nil, nil, code
+	}
+	if hasEvenPort {
+		if err := evenPort.GetFrom(r.m); err != nil {
+			return nil, nil, stun.CodeBadRequest
+		}
+	}
+	if evenPort.ReservePort {
+		relay, reserved, err := s.ports.listenReserve(relayIP, s.now())
+		if err != nil {
+			return nil, nil, stun.CodeInsufficientCapacity
+		}
+		return relay, reserved, 0
+	}
+	relay, err := s.ports.listen(relayIP, hasEvenPort)
+	if err != nil {
+		return nil, nil, stun.CodeInsufficientCapacity
+	}
+	return relay, nil, 0
+}
+
+// requestedRelayAddr returns IP address for relayed transport address of
+// request if it has requested address family, or error code.
+//
+// RFC 6156 Section 4.2
+func (s *Server) requestedRelayAddr(r *request) (net.IP, stun.ErrorCode) {
+	relayIP := s.relayAddr(r)
+	family := RequestedFamilyIPv4
+	if relayIP.To4() == nil {
+		family = RequestedFamilyIPv6
+	}
+	var requested RequestedAddressFamily
+	switch err := requested.GetFrom(r.m); err {
+	case nil:
+		if requested != family {
+			return nil, stun.CodeAddrFamilyNotSupported
+		}
+	case stun.ErrAttributeNotFound:
+	default:
+		return nil, stun.CodeBadRequest
+	}
+	return relayIP, 0
+}
+
+// refresh handles Refresh request.
+//
+// RFC 5766 Section 7.2
+func (s *Server) refresh(r *request) {
+	if r.m.Contains(AttrMobilityTicket) && s.allocation(r.tuple) == nil {
+		if s.tickets == nil {
+			s.forbidMobility(r)
+			return
+		}
+		if !s.move(r) {
+			return
+		}
+	}
+	a := s.requestAllocation(r)
+	if a == nil {
+		return
+	}
+	var requested Lifetime
+	if err := requested.GetFrom(r.m); err == nil && requested.Duration == 0 {
+		s.delete(a)
+		s.success(r, ZeroLifetime)
+		return
+	}
+	lifetime := s.allocationLifetime(r.m)
+	expiresAt := s.now().Add(lifetime)
+	a.refresh(expiresAt)
+	setters := []stun.Setter{Lifetime{Duration: lifetime}}
+	if a.mobile {
+		// Ticket is bound to 5-tuple, so new one is issued with each
+		// response, RFC 8016 Section 3.2.
+		ticket, err := s.issueTicket(r, expiresAt)
+		if err != nil {
+			s.fail(r, stun.CodeServerError)
+			return
+		}
+		setters = append(setters, ticket)
+	}
+	s.success(r, setters...)
+}
+
+// forbidMobility writes 405 error response to request with mobility
+// ticket, which has no default reason phrase.
+//
+// RFC 8016 Section 3.1
+func (s *Server) forbidMobility(r *request) {
+	s.respond(r, stun.NewType(r.m.Type.Method, stun.ClassErrorResponse), stun.ErrorCodeAttribute{
+		Code:   CodeMobilityForbidden,
+		Reason: []byte("Mobility Forbidden"),
+	})
+}
+
+// issueTicket returns mobility ticket for allocation on 5-tuple of
+// request that expires with it.
+//
+// RFC 8016 Section 4
+func (s *Server) issueTicket(r *request, expiresAt time.Time) (MobilityTicket, error) {
+	return s.tickets.Issue(TicketInfo{
+		Tuple:    r.tuple,
+		Username: r.username,
+		Expires:  expiresAt,
+	})
+}
+
+// move moves allocation of mobility ticket from Refresh request to
+// 5-tuple of request, otherwise writes error response and returns
+// false.
+//
+// RFC 8016 Section 3.2
+func (s *Server) move(r *request) bool {
+	var ticket MobilityTicket
+	if err := ticket.GetFrom(r.m); err != nil {
+		s.fail(r, stun.CodeBadRequest)
+		return false
+	}
+	info, err := s.tickets.Verify(ticket, r.username)
+	if err == ErrTicketWrongUser {
+		s.fail(r, stun.CodeWrongCredentials)
+		return false
+	}
+	if err != nil || info.Tuple.Proto != r.tuple.Proto {
+		s.fail(r, stun.CodeBadRequest)
+		return false
+	}
+	s.mux.Lock()
+	prev := info.Tuple.key()
+	a := s.allocs[prev]
+	if a == nil || !a.mobile {
+		s.mux.Unlock()
+		s.fail(r, stun.CodeBadRequest)
+		return false
+	}
+	if a.username != r.username {
+		s.mux.Unlock()
+		s.fail(r, stun.CodeWrongCredentials)
+		return false
+	}
+	if _, exists := s.allocs[r.tuple.key()]; exists {
+		s.mux.Unlock()
+		s.fail(r, stun.CodeAllocMismatch)
+		return false
+	}
+	delete(s.allocs, prev)
+	a.tuple = r.tuple
+	a.move(r)
+	s.allocs[r.tuple.key()] = a
+	s.mux.Unlock()
+	return true
+}
+
+// checkPeerFamily returns true if peer address has the same family as
+// relayed transport address of allocation.
+func checkPeerFamily(a *serverAllocation, peer net.IP) bool {
+	return (peer.To4() == nil) == (a.relayed.IP.To4() == nil)
+}
+
+// createPermission handles CreatePermission request.
+//
+// RFC 5766 Section 9.2
+func (s *Server) createPermission(r *request) {
+	a := s.requestAllocation(r)
+	if a == nil {
+		return
+	}
+	var peers PeerAddresses
+	if err := peers.GetFrom(r.m); err != nil {
+		s.fail(r, stun.CodeBadRequest)
+		return
+	}
+	for _, peer := range peers {
+		if !checkPeerFamily(a, peer.IP) {
+			s.fail(r, stun.CodePeerAddrFamilyMismatch)
+			return
+		}
+		if !s.acl.Allowed(peer.IP) {
+			s.fail(r, stun.CodeForbidden)
+			return
+		}
+	}
+	now := s.now()
+	for _, peer := range peers {
+		a.perms.install(peer.IP, now)
+	}
+	s.success(r)
+}
+
+// channelBind handles ChannelBind request.
+//
+// RFC 5766 Section 11.2
+func (s *Server) channelBind(r *request) {
+	a := s.requestAllocation(r)
+	if a == nil {
+		return
+	}
+	var (
+		number ChannelNumber
+		peer   PeerAddress
+	)
+	if err := r.m.Parse(&number, &peer); err != nil || !number.Valid() || a.relay == nil {
+		// Channels are not used with TCP allocations.
+		s.fail(r, stun.CodeBadRequest)
+		return
+	}
+	if !checkPeerFamily(a, peer.IP) {
+		s.fail(r, stun.CodePeerAddrFamilyMismatch)
+		return
+	}
+	if !s.acl.Allowed(peer.IP) {
+		s.fail(r, stun.CodeForbidden)
+		return
+	}
+	if err := a.bind(number, Addr(peer), s.now()); err != nil {
+		s.fail(r, stun.CodeBadRequest)
+		return
+	}
+	s.success(r)
+}
+
+// handleSend handles Send indication.
+//
+// RFC 5766 Section 10.2
+func (s *Server) handleSend(r *request) {
+	a := s.allocation(r.tuple)
+	if a == nil || a.relay == nil {
+		return
+	}
+	var (
+		peer PeerAddress
+		data Data
+	)
+	if err := r.m.Parse(&peer, &data); err != nil {
+		return
+	}
+	// Indications are discarded instead of 403 response.
+	now := s.now()
+	if !s.acl.Allowed(peer.IP) || !a.perms.permitted(peer.IP, now) {
+		return
+	}
+	if !s.limit(a.toPeer, len(data), now) {
+		return
+	}
+	_ = a.writeToPeer(data, &net.UDPAddr{IP: peer.IP, Port: peer.Port}, DontFragment.IsSet(r.m))
+}
+
+// handleChannelData handles ChannelData message.
+//
+// RFC 5766 Section 11.6
+func (s *Server) handleChannelData(tuple FiveTuple, buf []byte) {
+	a := s.allocation(tuple)
+	if a == nil || a.relay == nil {
+		return
+	}
+	d := ChannelData{Raw: buf}
+	if err := d.Decode(); err != nil {
+		return
+	}
+	now := s.now()
+	peer, ok := a.channelPeer(d.Number, now)
+	if !ok || !s.limit(a.toPeer, len(d.Data), now) {
+		return
+	}
+	_ = a.writeToPeer(d.Data, peer, false)
+}
+
+// relayUntilClosed relays data from peers to client until allocation
+// is closed.
+//
+// RFC 5766 Section 10.3 and Section 11.5
+func (s *Server) relayUntilClosed(a *serverAllocation) {
+	var (
+		buf = make([]byte, maxPacketSize)
+		m   = new(stun.Message)
+		d   = new(ChannelData)
+	)
+	for {
+		n, addr, err := a.relay.ReadFrom(buf)
+		if err != nil {
+			if ne, ok := err.(net.Error); ok && ne.Temporary() {
+				continue
+			}
+			return
+		}
+		udpAddr, ok := addr.(*net.UDPAddr)
+		if !ok {
+			continue
+		}
+		now := s.now()
+		if !a.perms.permitted(udpAddr.IP, now) || !s.limit(a.toClient, n, now) {
+			continue
+		}
+		if number, bound := a.channels.number(udpAddr, now); bound {
+			d.Number = number
+			d.Data = buf[:n]
+			d.Encode()
+			a.writeToClient(d.Raw)
+			continue
+		}
+		if err = m.Build(stun.TransactionID, DataIndication,
+			PeerAddress{IP: udpAddr.IP, Port: udpAddr.Port}, Data(buf[:n]),
+		); err != nil {
+			continue
+		}
+		a.writeToClient(m.Raw)
+	}
+}
+
+// collect deletes expired allocations, permissions, channel bindings
+// and peer data connections that are not bound in time.
+func (s *Server) collect(now time.Time) {
+	var (
+		expired []*serverAllocation
+		unbound []*peerConnection
+	)
+	s.mux.Lock()
+	for k, a := range s.allocs {
+		if a.collect(now) {
+			delete(s.allocs, k)
+			expired = append(expired, a)
+		}
+	}
+	for id, c := range s.pending {
+		if !now.Before(c.expiresAt) {
+			delete(s.pending, id)
+			unbound = append(unbound, c)
+		}
+	}
+	s.mux.Unlock()
+	for _, a := range expired {
+		a.close()
+	}
+	for _, c := range unbound {
+		c.close()
+	}
+	s.ports.collect(now)
+}
+
+func (s *Server) collectUntilClosed() {
+	defer s.wg.Done()
+	ticker := time.NewTicker(collectInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.closed:
+			return
+		case <-ticker.C:
+			s.collect(s.now())
+		}
+	}
+}
@@ -0,0 +1,1089 @@
+package turn
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"gortc.io/stun"
+)
+
+// testServer is Server that serves single UDP connection on loopback.
+type testServer struct {
+	*Server
+	t    testing.TB
+	conn net.PacketConn
+	done chan error
+}
+
+// testPeerACL is DefaultPeerACL that allows loopback peers.
+var testPeerACL = &PeerACL{
+	Allow: mustParseNetworks("127.0.0.0/8"),
+	Deny:  DefaultDeniedNetworks,
+}
+
+func newTestServer(t testing.TB, o ServerOptions) *testServer {
+	if o.PeerACL == nil {
+		o.PeerACL = testPeerACL
+	}
+	s, err := NewServer(o)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := &testServer{
+		Server: s,
+		t:      t,
+		conn:   conn,
+		done:   make(chan error, 1),
+	}
+	go func() {
+		ts.done <- s.Serve(conn)
+	}()
+	return ts
+}
+
+func (s *testServer) Close() {
+	if err := s.Server.Close(); err != nil {
+		s.t.Error(err)
+	}
+	if err := <-s.done; err != ErrServerClosed {
+		s.t.Errorf("unexpected serve error %v", err)
+	}
+}
+
+// client returns client without credentials that is connected to server.
+func (s *testServer) client() *Client {
+	return s.dial(ClientOptions{})
+}
+
+// dial returns client with options that is connected to server.
+func (s *testServer) dial(o ClientOptions) *Client {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		s.t.Fatal(err)
+	}
+	o.Conn = conn
+	o.Server = s.conn.LocalAddr()
+	o.RTO = time.Millisecond * 50
+	c, err := NewClient(o)
+	if err != nil {
+		s.t.Fatal(err)
+	}
+	return c
+}
+
+// do performs request from conn and returns response.
+func (s *testServer) do(conn net.PacketConn, setters ...stun.Setter) *stun.Message {
+	req, err := stun.Build(setters...)
+	if err != nil {
+		s.t.Fatal(err)
+	}
+	if _, err = conn.WriteTo(req.Raw, s.conn.LocalAddr()); err != nil {
+		s.t.Fatal(err)
+	}
+	if err = conn.SetReadDeadline(time.Now().Add(time.Second)); err != nil {
+		s.t.Fatal(err)
+	}
+	buf := make([]byte, 1024)
+	n, _, err := conn.ReadFrom(buf)
+	if err != nil {
+		s.t.Fatal(err)
+	}
+	res := &stun.Message{Raw: buf[:n]}
+	if err = res.Decode(); err != nil {
+		s.t.Fatal(err)
+	}
+	if res.TransactionID != req.TransactionID {
+		s.t.Fatal("unexpected transaction id")
+	}
+	return res
+}
+
+func expectCode(t testing.TB, res *stun.Message, expected stun.ErrorCode) {
+	t.Helper()
+	var code stun.ErrorCodeAttribute
+	if err := code.GetFrom(res); err != nil {
+		t.Fatalf("failed to get error code from %s: %v", res, err)
+	}
+	if code.Code != expected {
+		t.Errorf("unexpected code %d, expected %d", code.Code, expected)
+	}
+}
+
+func listenPeer(t testing.TB) net.PacketConn {
+	peer, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = peer.SetDeadline(time.Now().Add(time.Second * 5)); err != nil {
+		t.Fatal(err)
+	}
+	return peer
+}
+
+func TestNewServer(t *testing.T) {
+	if _, err := NewServer(ServerOptions{
+		Lifetime:    time.Hour,
+		MaxLifetime: time.Minute,
+	}); err == nil {
+		t.Error("should error on invalid max lifetime")
+	}
+	if _, err := NewServer(ServerOptions{
+		Ports: PortRange{Min: 2000, Max: 1000},
+	}); err == nil {
+		t.Error("should error on invalid port range")
+	}
+	s, err := NewServer(ServerOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if err = s.Serve(conn); err != ErrServerClosed {
+		t.Errorf("unexpected error %v", err)
+	}
+}
+
+func TestServer_UnspecifiedAddr(t *testing.T) {
+	s, err := NewServer(ServerOptions{PeerACL: testPeerACL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	t.Run("Serve", func(t *testing.T) {
+		conn, listenErr := net.ListenPacket("udp", ":0")
+		if listenErr != nil {
+			t.Fatal(listenErr)
+		}
+		defer conn.Close()
+		if err = s.Serve(conn); err != ErrRelayIPRequired {
+			t.Errorf("unexpected error %v", err)
+		}
+	})
+	t.Run("ServeListener", func(t *testing.T) {
+		l, listenErr := net.Listen("tcp", ":0")
+		if listenErr != nil {
+			t.Fatal(listenErr)
+		}
+		defer l.Close()
+		if err = s.ServeListener(l); err != ErrRelayIPRequired {
+			t.Errorf("unexpected error %v", err)
+		}
+	})
+	t.Run("RelayIP", func(t *testing.T) {
+		relayed, serverErr := NewServer(ServerOptions{RelayIP: net.IPv4(127, 0, 0, 1)})
+		if serverErr != nil {
+			t.Fatal(serverErr)
+		}
+		conn, listenErr := net.ListenPacket("udp4", "0.0.0.0:0")
+		if listenErr != nil {
+			t.Fatal(listenErr)
+		}
+		done := make(chan error, 1)
+		go func() {
+			done <- relayed.Serve(conn)
+		}()
+		defer func() {
+			if err = relayed.Close(); err != nil {
+				t.Error(err)
+			}
+			if err = <-done; err != ErrServerClosed {
+				t.Errorf("unexpected serve error %v", err)
+			}
+		}()
+		clientConn, listenErr := net.ListenPacket("udp4", "127.0.0.1:0")
+		if listenErr != nil {
+			t.Fatal(listenErr)
+		}
+		c, clientErr := NewClient(ClientOptions{
+			Conn:   clientConn,
+			Server: &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: conn.LocalAddr().(*net.UDPAddr).Port},
+			RTO:    time.Millisecond * 50,
+		})
+		if clientErr != nil {
+			t.Fatal(clientErr)
+		}
+		defer c.Close()
+		a, allocErr := c.Allocate()
+		if allocErr != nil {
+			t.Fatal(allocErr)
+		}
+		if !a.Relayed.IP.Equal(net.IPv4(127, 0, 0, 1)) {
+			t.Errorf("unexpected relayed address %s", a.Relayed)
+		}
+	})
+}
+
+func TestServer_Relay(t *testing.T) {
+	s := newTestServer(t, ServerOptions{})
+	defer s.Close()
+	c := s.client()
+	defer c.Close()
+	a, err := c.Allocate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !a.Relayed.IP.Equal(net.IPv4(127, 0, 0, 1)) || a.Relayed.Port == 0 {
+		t.Errorf("unexpected relayed address %s", a.Relayed)
+	}
+	if a.Lifetime.Duration != DefaultLifetime {
+		t.Errorf("unexpected lifetime %s", a.Lifetime)
+	}
+	peer := listenPeer(t)
+	defer peer.Close()
+	buf := make([]byte, 1024)
+
+	// Data to peer without permission is dropped before permission is
+	// created, because requests are handled in order.
+	if _, err = a.WriteTo([]byte("dropped"), peer.LocalAddr()); err != nil {
+		t.Fatal(err)
+	}
+	if err = a.CreatePermission(peer.LocalAddr()); err != nil {
+		t.Fatal(err)
+	}
+	if err = a.SetReadDeadline(time.Now().Add(time.Second * 5)); err != nil {
+		t.Fatal(err)
+	}
+	exchange := func(t *testing.T, data string) {
+		t.Helper()
+		if _, err = a.WriteTo([]byte(data), peer.LocalAddr()); err != nil {
+			t.Fatal(err)
+		}
+		n, addr, readErr := peer.ReadFrom(buf)
+		if readErr != nil {
+			t.Fatal(readErr)
+		}
+		if string(buf[:n]) != data {
+			t.Errorf("peer got %q, expected %q", buf[:n], data)
+		}
+		if addr.String() != a.LocalAddr().String() {
+			t.Errorf("unexpected address %s", addr)
+		}
+		if _, err = peer.WriteTo([]byte(data+"-reply"), addr); err != nil {
+			t.Fatal(err)
+		}
+		n, addr, readErr = a.ReadFrom(buf)
+		if readErr != nil {
+			t.Fatal(readErr)
+		}
+		if string(buf[:n]) != data+"-reply" {
+			t.Errorf("client got %q", buf[:n])
+		}
+		if addr.String() != peer.LocalAddr().String() {
+			t.Errorf("unexpected address %s", addr)
+		}
+	}
+	t.Run("Send", func(t *testing.T) {
+		exchange(t, "send")
+	})
+	t.Run("ChannelData", func(t *testing.T) {
+		if _, err = a.BindChannel(peer.LocalAddr()); err != nil {
+			t.Fatal(err)
+		}
+		exchange(t, "channel")
+	})
+	t.Run("DontFragment", func(t *testing.T) {
+		if !supportsDontFragment {
+			t.Skip("DF bit is not supported")
+		}
+		// Send indication is used even if channel is bound.
+		if _, err = a.WriteToDontFragment([]byte("df"), peer.LocalAddr()); err != nil {
+			t.Fatal(err)
+		}
+		if err = peer.SetReadDeadline(time.Now().Add(time.Second * 5)); err != nil {
+			t.Fatal(err)
+		}
+		n, _, readErr := peer.ReadFrom(buf)
+		if readErr != nil {
+			t.Fatal(readErr)
+		}
+		if string(buf[:n]) != "df" {
+			t.Errorf("peer got %q", buf[:n])
+		}
+		exchange(t, "fragment")
+	})
+	t.Run("Close", func(t *testing.T) {
+		if err = a.Close(); err != nil {
+			t.Fatal(err)
+		}
+		s.mux.Lock()
+		count := len(s.allocs)
+		s.mux.Unlock()
+		if count != 0 {
+			t.Errorf("unexpected allocations count %d", count)
+		}
+	})
+}
+
+func TestServer_Requests(t *testing.T) {
+	s := newTestServer(t, ServerOptions{})
+	defer s.Close()
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	peer := PeerAddress{IP: net.IPv4(127, 0, 0, 1), Port: 1000}
+
+	t.Run("Binding", func(t *testing.T) {
+		res := s.do(conn, stun.TransactionID, stun.BindingRequest)
+		var addr stun.XORMappedAddress
+		if err = addr.GetFrom(res); err != nil {
+			t.Fatal(err)
+		}
+		if addr.String() != conn.LocalAddr().String() {
+			t.Errorf("unexpected address %s", addr)
+		}
+	})
+	t.Run("NoAllocation", func(t *testing.T) {
+		for _, setters := range [][]stun.Setter{
+			{stun.TransactionID, RefreshRequest},
+			{stun.TransactionID, CreatePermissionRequest, peer},
+			{stun.TransactionID, ChannelBindRequest, MinChannelNumber, peer},
+		} {
+			expectCode(t, s.do(conn, setters...), stun.CodeAllocMismatch)
+		}
+	})
+	t.Run("UnknownAttribute", func(t *testing.T) {
+		res := s.do(conn, stun.TransactionID, AllocateRequest, RequestedTransportUDP,
+			stun.RawAttribute{Type: 0x0033, Value: []byte{1, 2, 3, 4}},
+		)
+		expectCode(t, res, stun.CodeUnknownAttribute)
+		var unknown stun.UnknownAttributes
+		if err = unknown.GetFrom(res); err != nil {
+			t.Fatal(err)
+		}
+		if len(unknown) != 1 || unknown[0] != 0x0033 {
+			t.Errorf("unexpected unknown attributes %v", unknown)
+		}
+	})
+	t.Run("BadTransport", func(t *testing.T) {
+		expectCode(t, s.do(conn, stun.TransactionID, AllocateRequest), stun.CodeBadRequest)
+		expectCode(t, s.do(conn, stun.TransactionID, AllocateRequest,
+			RequestedTransport{Protocol: 132}, // SCTP
+		), stun.CodeUnsupportedTransProto)
+		// TCP allocations require TCP or TLS connection.
+		expectCode(t, s.do(conn, stun.TransactionID, AllocateRequest,
+			RequestedTransportTCP,
+		), stun.CodeBadRequest)
+	})
+	t.Run("DontFragment", func(t *testing.T) {
+		if supportsDontFragment {
+			t.Skip("DF bit is supported")
+		}
+		expectCode(t, s.do(conn, stun.TransactionID, AllocateRequest,
+			RequestedTransportUDP, DontFragment,
+		), stun.CodeUnknownAttribute)
+	})
+	t.Run("BadFamily", func(t *testing.T) {
+		expectCode(t, s.do(conn, stun.TransactionID, AllocateRequest,
+			RequestedTransportUDP, RequestedFamilyIPv6,
+		), stun.CodeAddrFamilyNotSupported)
+	})
+	allocate := []stun.Setter{
+		stun.NewTransactionIDSetter(stun.NewTransactionID()), AllocateRequest,
+		RequestedTransportUDP, Lifetime{Duration: time.Hour * 2},
+	}
+	t.Run("Allocate", func(t *testing.T) {
+		res := s.do(conn, allocate...)
+		if res.Type != stun.NewType(stun.MethodAllocate, stun.ClassSuccessResponse) {
+			t.Fatalf("unexpected response %s", res)
+		}
+		var lifetime Lifetime
+		if err = lifetime.GetFrom(res); err != nil {
+			t.Fatal(err)
+		}
+		if lifetime.Duration != DefaultMaxLifetime {
+			t.Errorf("unexpected lifetime %s", lifetime)
+		}
+		// Retransmission gets the same response.
+		retransmitted := s.do(conn, allocate...)
+		if !bytes.Equal(retransmitted.Raw, res.Raw) {
+			t.Error("unexpected response to retransmission")
+		}
+		expectCode(t, s.do(conn, stun.TransactionID, AllocateRequest, RequestedTransportUDP),
+			stun.CodeAllocMismatch,
+		)
+	})
+	t.Run("CreatePermission", func(t *testing.T) {
+		expectCode(t, s.do(conn, stun.TransactionID, CreatePermissionRequest), stun.CodeBadRequest)
+		expectCode(t, s.do(conn, stun.TransactionID, CreatePermissionRequest,
+			PeerAddress{IP: net.ParseIP("2001:db8::1"), Port: 1000},
+		), stun.CodePeerAddrFamilyMismatch)
+		res := s.do(conn, stun.TransactionID, CreatePermissionRequest, PeerAddresses{
+			peer, {IP: net.IPv4(127, 0, 0, 2), Port: 1000},
+		})
+		if res.Type.Class != stun.ClassSuccessResponse {
+			t.Fatalf("unexpected response %s", res)
+		}
+	})
+	t.Run("ChannelBind", func(t *testing.T) {
+		expectCode(t, s.do(conn, stun.TransactionID, ChannelBindRequest,
+			ChannelNumber(0x1000), peer,
+		), stun.CodeBadRequest)
+		for i := 0; i < 2; i++ {
+			// Rebinding to the same peer refreshes binding.
+			res := s.do(conn, stun.TransactionID, ChannelBindRequest, MinChannelNumber, peer)
+			if res.Type.Class != stun.ClassSuccessResponse {
+				t.Fatalf("unexpected response %s", res)
+			}
+		}
+		expectCode(t, s.do(conn, stun.TransactionID, ChannelBindRequest,
+			MinChannelNumber+1, peer,
+		), stun.CodeBadRequest)
+		expectCode(t, s.do(conn, stun.TransactionID, ChannelBindRequest,
+			MinChannelNumber, PeerAddress{IP: peer.IP, Port: 1001},
+		), stun.CodeBadRequest)
+	})
+	t.Run("Expiration", func(t *testing.T) {
+		tuple, tupleErr := s.tuple(s.conn, conn.LocalAddr())
+		if tupleErr != nil {
+			t.Fatal(tupleErr)
+		}
+		a := s.allocation(tuple)
+		if a == nil {
+			t.Fatal("no allocation")
+		}
+		now := time.Now()
+		s.collect(now.Add(PermissionLifetime + time.Second))
+		if a.perms.permitted(net.IPv4(127, 0, 0, 2), now.Add(PermissionLifetime+time.Second)) {
+			t.Error("permission should expire")
+		}
+		if _, ok := a.channelPeer(MinChannelNumber, now.Add(PermissionLifetime+time.Second)); ok {
+			t.Error("channel should be unusable without permission")
+		}
+		s.collect(now.Add(ChannelLifetime + time.Second))
+		if a.channels.len() != 0 {
+			t.Error("channel should expire")
+		}
+		if s.allocation(tuple) != a {
+			t.Fatal("allocation should not expire")
+		}
+		s.collect(now.Add(DefaultMaxLifetime + time.Second))
+		if s.allocation(tuple) != nil {
+			t.Error("allocation should expire")
+		}
+	})
+	t.Run("Refresh", func(t *testing.T) {
+		res := s.do(conn, stun.TransactionID, AllocateRequest, RequestedTransportUDP)
+		if res.Type.Class != stun.ClassSuccessResponse {
+			t.Fatalf("unexpected response %s", res)
+		}
+		res = s.do(conn, stun.TransactionID, RefreshRequest, Lifetime{Duration: time.Second})
+		var lifetime Lifetime
+		if err = lifetime.GetFrom(res); err != nil {
+			t.Fatal(err)
+		}
+		if lifetime.Duration != DefaultLifetime {
+			t.Errorf("unexpected lifetime %s", lifetime)
+		}
+		s.do(conn, stun.TransactionID, RefreshRequest, ZeroLifetime)
+		expectCode(t, s.do(conn, stun.TransactionID, RefreshRequest), stun.CodeAllocMismatch)
+	})
+}
+
+func TestServer_EvenPort(t *testing.T) {
+	s := newTestServer(t, ServerOptions{
+		Ports: PortRange{Min: 50200, Max: 50299},
+	})
+	defer s.Close()
+	listen := func(t *testing.T) net.PacketConn {
+		conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return conn
+	}
+	relayed := func(t *testing.T, res *stun.Message) RelayedAddress {
+		t.Helper()
+		var addr RelayedAddress
+		if err := addr.GetFrom(res); err != nil {
+			t.Fatalf("failed to get relayed address from %s: %v", res, err)
+		}
+		return addr
+	}
+	t.Run("Even", func(t *testing.T) {
+		conn := listen(t)
+		defer conn.Close()
+		res := s.do(conn, stun.TransactionID, AllocateRequest, RequestedTransportUDP, EvenPort{})
+		if addr := relayed(t, res); addr.Port%2 != 0 {
+			t.Errorf("port %d is not even", addr.Port)
+		}
+		if res.Contains(stun.AttrReservationToken) {
+			t.Error("unexpected reservation token")
+		}
+	})
+	t.Run("Reserve", func(t *testing.T) {
+		rtp, rtcp := listen(t), listen(t)
+		defer rtp.Close()
+		defer rtcp.Close()
+		res := s.do(rtp, stun.TransactionID, AllocateRequest, RequestedTransportUDP,
+			EvenPort{ReservePort: true},
+		)
+		addr := relayed(t, res)
+		if addr.Port%2 != 0 {
+			t.Errorf("port %d is not even", addr.Port)
+		}
+		var token ReservationToken
+		if err := token.GetFrom(res); err != nil {
+			t.Fatal(err)
+		}
+		expectCode(t, s.do(rtcp, stun.TransactionID, AllocateRequest, RequestedTransportUDP,
+			token, EvenPort{},
+		), stun.CodeBadRequest)
+		res = s.do(rtcp, stun.TransactionID, AllocateRequest, RequestedTransportUDP, token)
+		if next := relayed(t, res); next.Port != addr.Port+1 {
+			t.Errorf("unexpected port %d, expected %d", next.Port, addr.Port+1)
+		}
+		other := listen(t)
+		defer other.Close()
+		expectCode(t, s.do(other, stun.TransactionID, AllocateRequest, RequestedTransportUDP, token),
+			stun.CodeInsufficientCapacity,
+		)
+	})
+}
+
+func TestServer_PeerACL(t *testing.T) {
+	s := newTestServer(t, ServerOptions{PeerACL: DefaultPeerACL()})
+	defer s.Close()
+	c := s.client()
+	defer c.Close()
+	a, err := c.Allocate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, peer := range []string{
+		"127.0.0.1", "10.0.0.1", "169.254.169.254", "::ffff:127.0.0.1",
+	} {
+		addr := &net.UDPAddr{IP: net.ParseIP(peer), Port: 80}
+		err = a.CreatePermission(addr)
+		if respErr, ok := err.(*ResponseError); !ok || respErr.Code.Code != stun.CodeForbidden {
+			t.Errorf("%s: unexpected CreatePermission error %v", peer, err)
+		}
+		_, err = a.BindChannel(addr)
+		if respErr, ok := err.(*ResponseError); !ok || respErr.Code.Code != stun.CodeForbidden {
+			t.Errorf("%s: unexpected ChannelBind error %v", peer, err)
+		}
+	}
+	if err = a.CreatePermission(&net.UDPAddr{IP: net.IPv4(8, 8, 8, 8), Port: 53}); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestServer_Quota(t *testing.T) {
+	s := newTestServer(t, ServerOptions{
+		Quota: Quota{PerIP: 1},
+	})
+	defer s.Close()
+	c := s.client()
+	defer c.Close()
+	a, err := c.Allocate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var clients []*Client
+	defer func() {
+		for _, other := range clients {
+			other.Close()
+		}
+	}()
+	allocate := func(t *testing.T) (*Allocation, error) {
+		other := s.client()
+		clients = append(clients, other)
+		return other.Allocate()
+	}
+	if _, err := allocate(t); err == nil {
+		t.Fatal("should error")
+	} else if respErr, ok := err.(*ResponseError); !ok || respErr.Code.Code != stun.CodeAllocQuotaReached {
+		t.Errorf("unexpected error %v", err)
+	}
+	s.SetQuota(Quota{Total: 2})
+	if _, err := allocate(t); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := allocate(t); err == nil {
+		t.Fatal("should error")
+	} else if respErr, ok := err.(*ResponseError); !ok || respErr.Code.Code != stun.CodeInsufficientCapacity {
+		t.Errorf("unexpected error %v", err)
+	}
+	stats := s.Stats()
+	if stats.Allocations != 2 || stats.Quota.Quota.Total != 2 || stats.Quota.Rejected != 2 {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+	if err = a.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if stats = s.Stats(); stats.Allocations != 1 {
+		t.Errorf("unexpected allocations count %d", stats.Allocations)
+	}
+}
+
+func TestServer_Bandwidth(t *testing.T) {
+	s := newTestServer(t, ServerOptions{
+		Bandwidth: BandwidthLimit{BytesPerSecond: 1000},
+		UserBandwidth: map[string]BandwidthLimit{
+			"alice": {BytesPerSecond: 2000},
+		},
+	})
+	defer s.Close()
+	if limit := s.userBandwidth("alice"); limit.BytesPerSecond != 2000 {
+		t.Errorf("unexpected limit for user %+v", limit)
+	}
+	c := s.client()
+	defer c.Close()
+	a, err := c.Allocate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	peer := listenPeer(t)
+	defer peer.Close()
+	if err = a.CreatePermission(peer.LocalAddr()); err != nil {
+		t.Fatal(err)
+	}
+	data := make([]byte, 600)
+	for i := 0; i < 3; i++ {
+		if _, err = a.WriteTo(data, peer.LocalAddr()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Requests are handled in order, so data is processed after refresh.
+	if err = a.Refresh(); err != nil {
+		t.Fatal(err)
+	}
+	stats := s.Stats()
+	if stats.Dropped.Packets != 2 || stats.Dropped.Bytes != 1200 {
+		t.Errorf("unexpected dropped %+v", stats.Dropped)
+	}
+	buf := make([]byte, 1024)
+	if n, _, readErr := peer.ReadFrom(buf); readErr != nil || n != len(data) {
+		t.Fatalf("unexpected read: %d, %v", n, readErr)
+	}
+}
+
+func TestServer_Mobility(t *testing.T) {
+	issuer := newTestTicketIssuer(t)
+	s := newTestServer(t, ServerOptions{Tickets: issuer})
+	defer s.Close()
+	c := s.dial(ClientOptions{Mobility: true})
+	defer c.Close()
+	a, err := c.Allocate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	a.mux.Lock()
+	prevTicket := append(MobilityTicket(nil), a.ticket...)
+	a.mux.Unlock()
+	if len(prevTicket) == 0 {
+		t.Fatal("no mobility ticket")
+	}
+	peer := listenPeer(t)
+	defer peer.Close()
+	if err = a.CreatePermission(peer.LocalAddr()); err != nil {
+		t.Fatal(err)
+	}
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = c.Migrate(conn); err != nil {
+		t.Fatal(err)
+	}
+	tuple, err := s.tuple(s.conn, conn.LocalAddr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.allocation(tuple) == nil {
+		t.Fatal("allocation is not moved")
+	}
+	waitAllocations(t, s.Server, 1)
+	if err = a.SetReadDeadline(time.Now().Add(time.Second * 5)); err != nil {
+		t.Fatal(err)
+	}
+	t.Run("Relay", func(t *testing.T) {
+		buf := make([]byte, 1024)
+		if _, err = a.WriteTo([]byte("moved"), peer.LocalAddr()); err != nil {
+			t.Fatal(err)
+		}
+		n, addr, readErr := peer.ReadFrom(buf)
+		if readErr != nil {
+			t.Fatal(readErr)
+		}
+		if string(buf[:n]) != "moved" {
+			t.Errorf("peer got %q", buf[:n])
+		}
+		// Data from peer is relayed to new 5-tuple.
+		if _, err = peer.WriteTo([]byte("reply"), addr); err != nil {
+			t.Fatal(err)
+		}
+		n, _, readErr = a.ReadFrom(buf)
+		if readErr != nil {
+			t.Fatal(readErr)
+		}
+		if string(buf[:n]) != "reply" {
+			t.Errorf("client got %q", buf[:n])
+		}
+	})
+	t.Run("Refresh", func(t *testing.T) {
+		if err = a.Refresh(); err != nil {
+			t.Fatal(err)
+		}
+	})
+	t.Run("Mismatch", func(t *testing.T) {
+		other, listenErr := net.ListenPacket("udp", "127.0.0.1:0")
+		if listenErr != nil {
+			t.Fatal(listenErr)
+		}
+		defer other.Close()
+		unknown, issueErr := issuer.Issue(TicketInfo{
+			Tuple:   FiveTuple{Client: Addr{IP: net.IPv4(127, 0, 0, 1), Port: 1}, Proto: ProtoUDP},
+			Expires: time.Now().Add(time.Minute),
+		})
+		if issueErr != nil {
+			t.Fatal(issueErr)
+		}
+		wrongUser, issueErr := issuer.Issue(TicketInfo{
+			Tuple:    tuple,
+			Username: "other",
+			Expires:  time.Now().Add(time.Minute),
+		})
+		if issueErr != nil {
+			t.Fatal(issueErr)
+		}
+		for _, tc := range []struct {
+			name   string
+			ticket MobilityTicket
+			code   stun.ErrorCode
+		}{
+			{name: "Invalid", ticket: MobilityTicket("invalid"), code: stun.CodeBadRequest},
+			{name: "Previous", ticket: prevTicket, code: stun.CodeBadRequest},
+			{name: "Unknown", ticket: unknown, code: stun.CodeBadRequest},
+			{name: "WrongUser", ticket: wrongUser, code: stun.CodeWrongCredentials},
+		} {
+			t.Run(tc.name, func(t *testing.T) {
+				expectCode(t, s.do(other, stun.TransactionID, RefreshRequest, tc.ticket), tc.code)
+			})
+		}
+		// Allocation is not moved.
+		if err = a.Refresh(); err != nil {
+			t.Fatal(err)
+		}
+	})
+	t.Run("NotRequested", func(t *testing.T) {
+		plain := s.client()
+		defer plain.Close()
+		if _, err = plain.Allocate(); err != nil {
+			t.Fatal(err)
+		}
+		next, listenErr := net.ListenPacket("udp", "127.0.0.1:0")
+		if listenErr != nil {
+			t.Fatal(listenErr)
+		}
+		if err = plain.Migrate(next); err != ErrNoMobility {
+			t.Errorf("unexpected error %v", err)
+		}
+		_ = next.Close()
+	})
+}
+
+func TestServer_MobilityForbidden(t *testing.T) {
+	s := newTestServer(t, ServerOptions{})
+	defer s.Close()
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	t.Run("Allocate", func(t *testing.T) {
+		expectCode(t, s.do(conn, stun.TransactionID, AllocateRequest,
+			RequestedTransportUDP, MobilityTicket{},
+		), CodeMobilityForbidden)
+	})
+	t.Run("Refresh", func(t *testing.T) {
+		expectCode(t, s.do(conn, stun.TransactionID, RefreshRequest,
+			MobilityTicket("ticket"),
+		), CodeMobilityForbidden)
+	})
+	t.Run("Client", func(t *testing.T) {
+		// Client falls back to allocation without mobility.
+		c := s.dial(ClientOptions{Mobility: true})
+		defer c.Close()
+		if _, err = c.Allocate(); err != nil {
+			t.Fatal(err)
+		}
+		next, listenErr := net.ListenPacket("udp", "127.0.0.1:0")
+		if listenErr != nil {
+			t.Fatal(listenErr)
+		}
+		defer next.Close()
+		if err = c.Migrate(next); err != ErrNoMobility {
+			t.Errorf("unexpected error %v", err)
+		}
+	})
+	t.Run("TCP", func(t *testing.T) {
+		stream := newStreamTestServer(t, ServerOptions{Tickets: newTestTicketIssuer(t)})
+		defer stream.Close()
+		c := stream.dial("")
+		defer c.Close()
+		_, err = c.request(AllocateRequest, RequestedTransportUDP, MobilityTicket{})
+		expectResponseCode(t, err, CodeMobilityForbidden)
+	})
+}
+
+func TestServer_OAuth(t *testing.T) {
+	s := newTestServer(t, ServerOptions{
+		OAuth: &OAuthValidator{
+			ServerName: testServerName,
+			Keys:       map[string]OAuthKey{"kid": testOAuthKey},
+		},
+		Realm: testRealm,
+	})
+	defer s.Close()
+	t.Run("Challenge", func(t *testing.T) {
+		conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		res := s.do(conn, stun.TransactionID, AllocateRequest, RequestedTransportUDP)
+		expectCode(t, res, stun.CodeUnauthorized)
+		var name ThirdPartyAuthorization
+		if err = name.GetFrom(res); err != nil {
+			t.Fatal(err)
+		}
+		if name.String() != testServerName {
+			t.Errorf("unexpected server name %q", name)
+		}
+	})
+	t.Run("Allocate", func(t *testing.T) {
+		c := s.dial(ClientOptions{OAuth: newTestOAuthCredentials(t, time.Now())})
+		defer c.Close()
+		a, err := c.Allocate()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = a.CreatePermission(&net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 1000}); err != nil {
+			t.Fatal(err)
+		}
+		if err = a.Close(); err != nil {
+			t.Fatal(err)
+		}
+	})
+	t.Run("Expired", func(t *testing.T) {
+		c := s.dial(ClientOptions{OAuth: newTestOAuthCredentials(t, time.Now().Add(-time.Hour*2))})
+		defer c.Close()
+		if _, err := c.Allocate(); err != ErrUnauthorized {
+			t.Errorf("unexpected error %v", err)
+		}
+	})
+	t.Run("Unsupported", func(t *testing.T) {
+		plain := newTestServer(t, ServerOptions{})
+		defer plain.Close()
+		conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		res := plain.do(conn, stun.TransactionID, AllocateRequest, RequestedTransportUDP,
+			newTestOAuthCredentials(t, time.Now()),
+		)
+		expectCode(t, res, stun.CodeUnknownAttribute)
+		var unknown stun.UnknownAttributes
+		if err = unknown.GetFrom(res); err != nil {
+			t.Fatal(err)
+		}
+		if len(unknown) != 1 || unknown[0] != AttrAccessToken {
+			t.Errorf("unexpected unknown attributes %v", unknown)
+		}
+	})
+}
+
+func TestServer_AuthTimeout(t *testing.T) {
+	s := newTestServer(t, ServerOptions{
+		Auth: AuthFunc(func(ctx context.Context, username, realm string) (stun.MessageIntegrity, error) {
+			if _, ok := ctx.Deadline(); !ok {
+				t.Error("no deadline")
+			}
+			<-ctx.Done()
+			return nil, ctx.Err()
+		}),
+		AuthTimeout: time.Millisecond * 50,
+		Realm:       "realm",
+	})
+	defer s.Close()
+	c := s.dial(ClientOptions{Username: "user", Password: "secret"})
+	defer c.Close()
+	_, err := c.Allocate()
+	expectResponseCode(t, err, stun.CodeServerError)
+}
+
+func TestServer_SlowAuth(t *testing.T) {
+	release := make(chan struct{})
+	s := newTestServer(t, ServerOptions{
+		Auth: AuthFunc(func(ctx context.Context, username, realm string) (stun.MessageIntegrity, error) {
+			if username == "slow" {
+				select {
+				case <-release:
+				case <-ctx.Done():
+				}
+			}
+			return stun.NewLongTermIntegrity(username, realm, "secret"), nil
+		}),
+		Realm: "realm",
+	})
+	defer s.Close()
+	c := s.dial(ClientOptions{Username: "user", Password: "secret"})
+	defer c.Close()
+	a, err := c.Allocate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	peer := listenPeer(t)
+	defer peer.Close()
+	if _, err = a.BindChannel(peer.LocalAddr()); err != nil {
+		t.Fatal(err)
+	}
+	slow := s.dial(ClientOptions{Username: "slow", Password: "secret"})
+	defer slow.Close()
+	allocated := make(chan error, 1)
+	go func() {
+		_, allocErr := slow.Allocate()
+		allocated <- allocErr
+	}()
+	// Lookup of slow user is in progress while data is relayed, and
+	// requests of other users are handled.
+	time.Sleep(time.Millisecond * 50)
+	if _, err = a.WriteTo([]byte("data"), peer.LocalAddr()); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 10)
+	n, _, err := peer.ReadFrom(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(buf[:n]) != "data" {
+		t.Errorf("peer got %q", buf[:n])
+	}
+	if err = a.Refresh(); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case err = <-allocated:
+		t.Fatalf("allocated before lookup is done: %v", err)
+	default:
+	}
+	close(release)
+	if err = <-allocated; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestServer_Auth(t *testing.T) {
+	s := newTestServer(t, ServerOptions{
+		Auth:  StaticAuth{"user": "secret", "other": "secret"},
+		Realm: "realm",
+	})
+	defer s.Close()
+
+	t.Run("Allocate", func(t *testing.T) {
+		c := s.dial(ClientOptions{Username: "user", Password: "secret"})
+		defer c.Close()
+		a, err := c.Allocate()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = a.CreatePermission(&net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 1000}); err != nil {
+			t.Fatal(err)
+		}
+		if err = a.Close(); err != nil {
+			t.Fatal(err)
+		}
+	})
+	t.Run("WrongPassword", func(t *testing.T) {
+		c := s.dial(ClientOptions{Username: "user", Password: "wrong"})
+		defer c.Close()
+		if _, err := c.Allocate(); err != ErrUnauthorized {
+			t.Errorf("unexpected error %v", err)
+		}
+	})
+	t.Run("NoCredentials", func(t *testing.T) {
+		c := s.client()
+		defer c.Close()
+		if _, err := c.Allocate(); err != ErrUnauthorized {
+			t.Errorf("unexpected error %v", err)
+		}
+	})
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	integrity := stun.NewLongTermIntegrity("user", "realm", "secret")
+	t.Run("InvalidNonce", func(t *testing.T) {
+		res := s.do(conn, stun.TransactionID, AllocateRequest, RequestedTransportUDP,
+			stun.NewUsername("user"), stun.NewRealm("realm"), stun.NewNonce("nonce"),
+			integrity,
+		)
+		expectCode(t, res, stun.CodeStaleNonce)
+		var nonce stun.Nonce
+		if err = nonce.GetFrom(res); err != nil {
+			t.Fatal(err)
+		}
+		if err = s.nonces.Check(nonce, net.IPv4(127, 0, 0, 1)); err != nil {
+			t.Error(err)
+		}
+	})
+	t.Run("WrongCredentials", func(t *testing.T) {
+		nonce := s.nonces.Nonce(net.IPv4(127, 0, 0, 1))
+		res := s.do(conn, stun.TransactionID, AllocateRequest, RequestedTransportUDP,
+			stun.NewUsername("user"), stun.NewRealm("realm"), nonce, integrity,
+		)
+		if res.Type.Class != stun.ClassSuccessResponse {
+			t.Fatalf("unexpected response %s", res)
+		}
+		if err = integrity.Check(res); err != nil {
+			t.Error(err)
+		}
+		res = s.do(conn, stun.TransactionID, RefreshRequest,
+			stun.NewUsername("other"), stun.NewRealm("realm"), nonce,
+			stun.NewLongTermIntegrity("other", "realm", "secret"),
+		)
+		expectCode(t, res, stun.CodeWrongCredentials)
+	})
+}
+
+func TestServerAllocation_Bind(t *testing.T) {
+	now := time.Now()
+	a := &serverAllocation{
+		perms:    newPermissionTable(),
+		channels: newChannelTable(),
+	}
+	peer := Addr{IP: net.IPv4(10, 0, 0, 1), Port: 1000}
+	if err := a.bind(MinChannelNumber, peer, now); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.bind(MinChannelNumber+1, peer, now); err != errChannelConflict {
+		t.Errorf("unexpected error %v", err)
+	}
+	if !a.perms.permitted(peer.IP, now) {
+		t.Error("binding should install permission")
+	}
+	addr, ok := a.channelPeer(MinChannelNumber, now)
+	if !ok || addr.Port != peer.Port {
+		t.Errorf("unexpected peer %v", addr)
+	}
+	if _, ok = a.channelPeer(MinChannelNumber, now.Add(PermissionLifetime)); ok {
+		t.Error("channel should be unusable without permission")
+	}
+}
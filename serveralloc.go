@@ -0,0 +1,239 @@
+package turn
+
+import (
+	"net"
+	"sync"
+	"time"
+)
+
+// serverAllocation is allocation on Server.
+//
+// RFC 5766 Section 5
+type serverAllocation struct {
+	// tuple is guarded by mux of Server, because allocation can be
+	// moved to new 5-tuple with mobility ticket.
+	tuple   FiveTuple
+	conn    net.PacketConn // connection with client, guarded by mux
+	client  net.Addr       // client address on conn, guarded by mux
+	relay   net.PacketConn // nil for TCP allocation
+	relayed Addr
+	// listener accepts peer connections of TCP allocation.
+	listener *net.TCPListener
+	// username of Allocate request if it was authenticated.
+	username string
+	// mobile is true if mobility ticket is issued for allocation.
+	mobile bool
+
+	// transaction and response of Allocate request that created
+	// allocation, to handle retransmissions.
+	transaction [12]byte
+	response    []byte
+
+	mux       sync.Mutex
+	expiresAt time.Time
+
+	perms    *permissionTable
+	channels *channelTable
+	// peers are peer data connections of TCP allocation, guarded by mux.
+	peers  map[ConnectionID]*peerConnection
+	closed bool
+
+	// toPeer and toClient limit bandwidth of relayed data, nil if
+	// there is no limit.
+	toPeer   *tokenBucket
+	toClient *tokenBucket
+
+	// relayMux serializes writes to relay, so DF bit is only set for
+	// data of Send indication with DONT-FRAGMENT.
+	relayMux sync.Mutex
+
+	closeOnce sync.Once
+	release   func() // called on close, if set
+}
+
+func newServerAllocation(r *request, relay net.PacketConn, expiresAt time.Time) *serverAllocation {
+	a := &serverAllocation{
+		tuple:       r.tuple,
+		conn:        r.conn,
+		client:      r.addr,
+		relay:       relay,
+		username:    r.username,
+		transaction: r.m.TransactionID,
+		expiresAt:   expiresAt,
+		perms:       newPermissionTable(),
+		channels:    newChannelTable(),
+	}
+	if relay == nil {
+		return a
+	}
+	if udpAddr, ok := relay.LocalAddr().(*net.UDPAddr); ok {
+		a.relayed.FromUDPAddr(udpAddr)
+	}
+	return a
+}
+
+// newTCPServerAllocation returns TCP allocation with relayed transport
+// address of listener.
+//
+// RFC 6062 Section 5.1
+func newTCPServerAllocation(r *request, l *net.TCPListener, expiresAt time.Time) *serverAllocation {
+	a := newServerAllocation(r, nil, expiresAt)
+	a.listener = l
+	a.peers = make(map[ConnectionID]*peerConnection)
+	if tcpAddr, ok := l.Addr().(*net.TCPAddr); ok {
+		a.relayed = Addr{IP: tcpAddr.IP, Port: tcpAddr.Port}
+	}
+	return a
+}
+
+// refresh sets new expiration time of allocation.
+func (a *serverAllocation) refresh(expiresAt time.Time) {
+	a.mux.Lock()
+	a.expiresAt = expiresAt
+	a.mux.Unlock()
+}
+
+// clientConn returns connection with client and client address on it.
+func (a *serverAllocation) clientConn() (net.PacketConn, net.Addr) {
+	a.mux.Lock()
+	defer a.mux.Unlock()
+	return a.conn, a.client
+}
+
+// writeToClient writes message to client.
+func (a *serverAllocation) writeToClient(b []byte) {
+	conn, client := a.clientConn()
+	_, _ = conn.WriteTo(b, client)
+}
+
+// move sets connection with client to the one of request. Server
+// updates tuple of allocation.
+//
+// RFC 8016 Section 3.2
+func (a *serverAllocation) move(r *request) {
+	a.mux.Lock()
+	a.conn = r.conn
+	a.client = r.addr
+	a.mux.Unlock()
+}
+
+// bind binds channel number to peer or refreshes existing binding and
+// installs or refreshes permission for peer IP.
+//
+// RFC 5766 Section 11.2
+func (a *serverAllocation) bind(n ChannelNumber, peer Addr, now time.Time) error {
+	if err := a.channels.bind(n, peer, now); err != nil {
+		return err
+	}
+	a.perms.install(peer.IP, now)
+	return nil
+}
+
+// channelPeer returns peer address that is bound to channel number if
+// there is permission for it.
+func (a *serverAllocation) channelPeer(n ChannelNumber, now time.Time) (*net.UDPAddr, bool) {
+	peer, ok := a.channels.peer(n, now)
+	if !ok || !a.perms.permitted(peer.IP, now) {
+		return nil, false
+	}
+	return peer, true
+}
+
+// writeToPeer relays data to peer, setting DF bit on it if dontFragment
+// is true.
+//
+// RFC 5766 Section 10.2
+func (a *serverAllocation) writeToPeer(b []byte, peer *net.UDPAddr, dontFragment bool) error {
+	a.relayMux.Lock()
+	defer a.relayMux.Unlock()
+	if !dontFragment {
+		_, err := a.relay.WriteTo(b, peer)
+		return err
+	}
+	ipv6 := a.relayed.IP.To4() == nil
+	if err := setDontFragment(a.relay, ipv6, true); err != nil {
+		return err
+	}
+	_, err := a.relay.WriteTo(b, peer)
+	if restoreErr := setDontFragment(a.relay, ipv6, false); err == nil {
+		err = restoreErr
+	}
+	return err
+}
+
+// collect deletes expired permissions and channel bindings and returns
+// true if allocation itself is expired.
+func (a *serverAllocation) collect(now time.Time) bool {
+	a.mux.Lock()
+	expired := !now.Before(a.expiresAt)
+	a.mux.Unlock()
+	if expired {
+		return true
+	}
+	a.perms.collect(now)
+	a.channels.collect(now)
+	return false
+}
+
+// addPeer adds peer data connection to allocation, returning false if
+// allocation is closed.
+func (a *serverAllocation) addPeer(c *peerConnection) bool {
+	a.mux.Lock()
+	defer a.mux.Unlock()
+	if a.closed {
+		return false
+	}
+	a.peers[c.id] = c
+	return true
+}
+
+// hasPeer returns true if allocation has peer data connection with id.
+func (a *serverAllocation) hasPeer(id ConnectionID) bool {
+	a.mux.Lock()
+	_, ok := a.peers[id]
+	a.mux.Unlock()
+	return ok
+}
+
+// removePeer removes peer data connection from allocation.
+func (a *serverAllocation) removePeer(c *peerConnection) {
+	a.mux.Lock()
+	if a.peers[c.id] == c {
+		delete(a.peers, c.id)
+	}
+	a.mux.Unlock()
+}
+
+// connected returns true if allocation has data connection with peer.
+func (a *serverAllocation) connected(peer Addr) bool {
+	a.mux.Lock()
+	defer a.mux.Unlock()
+	for _, c := range a.peers {
+		if c.peer.Equal(peer) {
+			return true
+		}
+	}
+	return false
+}
+
+// close releases relayed transport address, peer data connections and
+// quota.
+func (a *serverAllocation) close() {
+	a.closeOnce.Do(func() {
+		if a.relay != nil {
+			_ = a.relay.Close()
+		}
+		if a.listener != nil {
+			_ = a.listener.Close()
+		}
+		a.mux.Lock()
+		a.closed = true
+		for _, c := range a.peers {
+			_ = c.conn.Close()
+		}
+		a.mux.Unlock()
+		if a.release != nil {
+			a.release()
+		}
+	})
+}
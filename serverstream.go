@@ -0,0 +1,99 @@
+package turn
+
+import (
+	"crypto/tls"
+	"net"
+)
+
+// ServeListener accepts TCP or TLS connections from l and handles
+// requests on them until l or server is closed. The l is closed by
+// Close. Listeners are usually on DefaultPort for TCP and on
+// DefaultTLSPort for TLS.
+//
+// STUN messages and padded ChannelData messages are framed as described
+// in RFC 5766 Section 11.5 and 5-tuple of allocations has TCP transport
+// protocol. Allocation is deleted when its connection is closed.
+//
+// RFC 5766 Section 2.1, RFC 6062 Section 5.2
+func (s *Server) ServeListener(l net.Listener) error {
+	if err := s.checkLocalAddr(l.Addr()); err != nil {
+		return err
+	}
+	s.mux.Lock()
+	select {
+	case <-s.closed:
+		s.mux.Unlock()
+		return ErrServerClosed
+	default:
+	}
+	s.listeners[l] = struct{}{}
+	s.mux.Unlock()
+	defer func() {
+		s.mux.Lock()
+		delete(s.listeners, l)
+		s.mux.Unlock()
+	}()
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			select {
+			case <-s.closed:
+				return ErrServerClosed
+			default:
+			}
+			if ne, ok := err.(net.Error); ok && ne.Temporary() {
+				continue
+			}
+			return err
+		}
+		stream := NewStreamConn(conn)
+		s.mux.Lock()
+		select {
+		case <-s.closed:
+			s.mux.Unlock()
+			_ = conn.Close()
+			return ErrServerClosed
+		default:
+		}
+		s.conns[stream] = struct{}{}
+		s.wg.Add(1)
+		s.mux.Unlock()
+		go func() {
+			defer s.wg.Done()
+			s.serveStream(stream)
+		}()
+	}
+}
+
+// ServeTLS is ServeListener for TLS connections over l with config.
+func (s *Server) ServeTLS(l net.Listener, config *tls.Config) error {
+	return s.ServeListener(tls.NewListener(l, config))
+}
+
+// serveStream handles requests on stream connection until it is closed
+// and then deletes allocation of connection.
+func (s *Server) serveStream(conn *StreamConn) {
+	defer func() {
+		s.removeConn(conn)
+		_ = conn.Close()
+		tuple, err := s.tuple(conn, conn.RemoteAddr())
+		if err != nil {
+			return
+		}
+		a := s.allocation(tuple)
+		if a == nil {
+			return
+		}
+		if aconn, _ := a.clientConn(); aconn == net.PacketConn(conn) {
+			s.delete(a)
+		}
+	}()
+	buf := make([]byte, maxPacketSize)
+	for {
+		n, addr, err := conn.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		s.handle(conn, buf[:n], addr)
+	}
+}
@@ -0,0 +1,189 @@
+package turn
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net"
+	"testing"
+	"time"
+)
+
+// testCertificate returns self-signed certificate for 127.0.0.1.
+func testCertificate(t testing.TB) tls.Certificate {
+	cert, _ := signTestCertificate(t, &x509.Certificate{
+		IPAddresses: []net.IP{net.IPv4(127, 0, 0, 1)},
+	})
+	return cert
+}
+
+// testHostCertificate returns self-signed certificate only for host
+// name and pool of roots that trusts it.
+func testHostCertificate(t testing.TB, host string) (tls.Certificate, *x509.CertPool) {
+	cert, parsed := signTestCertificate(t, &x509.Certificate{
+		DNSNames:              []string{host},
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	})
+	roots := x509.NewCertPool()
+	roots.AddCert(parsed)
+	return cert, roots
+}
+
+// signTestCertificate fills template of server certificate and returns
+// it self-signed.
+func signTestCertificate(t testing.TB, template *x509.Certificate) (tls.Certificate, *x509.Certificate) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template.SerialNumber = big.NewInt(1)
+	template.Subject = pkix.Name{CommonName: "turn"}
+	template.NotBefore = time.Now().Add(-time.Hour)
+	template.NotAfter = time.Now().Add(time.Hour)
+	template.KeyUsage = x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign
+	template.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, parsed
+}
+
+// waitAllocations waits until server has expected count of allocations.
+func waitAllocations(t testing.TB, s *Server, expected int) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second * 5)
+	for s.Stats().Allocations != expected {
+		if time.Now().After(deadline) {
+			t.Fatalf("unexpected allocations count %d, expected %d", s.Stats().Allocations, expected)
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+}
+
+func TestServer_ServeListener(t *testing.T) {
+	cert := testCertificate(t)
+	for _, tc := range []struct {
+		name  string
+		serve func(s *Server, l net.Listener) error
+		uri   URI
+		tls   *tls.Config
+	}{
+		{
+			name:  "TCP",
+			serve: (*Server).ServeListener,
+			uri:   URI{Scheme: Scheme, Host: "127.0.0.1", Transport: TransportTCP},
+		},
+		{
+			name: "TLS",
+			serve: func(s *Server, l net.Listener) error {
+				return s.ServeTLS(l, &tls.Config{Certificates: []tls.Certificate{cert}})
+			},
+			uri: URI{Scheme: SchemeSecure, Host: "127.0.0.1"},
+			tls: &tls.Config{InsecureSkipVerify: true}, // #nosec
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			s, err := NewServer(ServerOptions{PeerACL: testPeerACL})
+			if err != nil {
+				t.Fatal(err)
+			}
+			l, err := net.Listen("tcp", "127.0.0.1:0")
+			if err != nil {
+				t.Fatal(err)
+			}
+			done := make(chan error, 1)
+			go func() {
+				done <- tc.serve(s, l)
+			}()
+			defer func() {
+				if err = s.Close(); err != nil {
+					t.Error(err)
+				}
+				if err = <-done; err != ErrServerClosed {
+					t.Errorf("unexpected serve error %v", err)
+				}
+			}()
+			uri := tc.uri
+			uri.Port = l.Addr().(*net.TCPAddr).Port
+			c, err := Dial(uri, tc.tls, ClientOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer c.Close()
+			a, err := c.Allocate()
+			if err != nil {
+				t.Fatal(err)
+			}
+			s.mux.Lock()
+			for _, alloc := range s.allocs {
+				if alloc.tuple.Proto != ProtoTCP {
+					t.Errorf("unexpected 5-tuple %s", alloc.tuple)
+				}
+			}
+			s.mux.Unlock()
+			peer := listenPeer(t)
+			defer peer.Close()
+			if _, err = a.BindChannel(peer.LocalAddr()); err != nil {
+				t.Fatal(err)
+			}
+			if err = a.SetReadDeadline(time.Now().Add(time.Second * 5)); err != nil {
+				t.Fatal(err)
+			}
+			buf := make([]byte, 1024)
+			// Odd sizes to check ChannelData padding.
+			for _, data := range []string{"a", "abc", "abcde"} {
+				if _, err = a.WriteTo([]byte(data), peer.LocalAddr()); err != nil {
+					t.Fatal(err)
+				}
+				n, addr, readErr := peer.ReadFrom(buf)
+				if readErr != nil {
+					t.Fatal(readErr)
+				}
+				if string(buf[:n]) != data {
+					t.Errorf("peer got %q, expected %q", buf[:n], data)
+				}
+				if _, err = peer.WriteTo(buf[:n], addr); err != nil {
+					t.Fatal(err)
+				}
+				if n, _, readErr = a.ReadFrom(buf); readErr != nil {
+					t.Fatal(readErr)
+				}
+				if string(buf[:n]) != data {
+					t.Errorf("client got %q, expected %q", buf[:n], data)
+				}
+			}
+			// Closing control connection deletes allocation.
+			if err = c.Close(); err != nil {
+				t.Fatal(err)
+			}
+			waitAllocations(t, s, 0)
+		})
+	}
+	t.Run("Closed", func(t *testing.T) {
+		s, err := NewServer(ServerOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = s.Close(); err != nil {
+			t.Fatal(err)
+		}
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer l.Close()
+		if err = s.ServeListener(l); err != ErrServerClosed {
+			t.Errorf("unexpected error %v", err)
+		}
+	})
+}
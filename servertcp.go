@@ -0,0 +1,319 @@
+package turn
+
+import (
+	"crypto/rand"
+	"io"
+	"net"
+	"syscall"
+	"time"
+
+	"gortc.io/stun"
+)
+
+// connectionBindTimeout is duration for which server holds peer data
+// connection until it is bound with ConnectionBind request.
+//
+// RFC 6062 Section 5.2 and Section 5.3
+const connectionBindTimeout = time.Second * 30
+
+// connectTimeout is timeout of establishing connection with peer for
+// Connect request.
+//
+// RFC 6062 Section 5.2
+const connectTimeout = time.Second * 30
+
+// peerConnection is TCP connection between relayed transport address
+// of allocation and peer.
+type peerConnection struct {
+	id    ConnectionID
+	alloc *serverAllocation
+	conn  net.Conn
+	peer  Addr
+	// expiresAt is deadline of ConnectionBind request.
+	expiresAt time.Time
+}
+
+// close closes connection and removes it from allocation.
+func (c *peerConnection) close() {
+	_ = c.conn.Close()
+	c.alloc.removePeer(c)
+}
+
+// listenTCPRelay returns listener for TCP relayed transport address of
+// Allocate request, or error code.
+//
+// RFC 6062 Section 5.1
+func (s *Server) listenTCPRelay(r *request) (*net.TCPListener, stun.ErrorCode) {
+	relayIP, code := s.requestedRelayAddr(r)
+	if code != 0 {
+		return nil, code
+	}
+	l, err := s.ports.listenTCP(relayIP)
+	if err != nil {
+		return nil, stun.CodeInsufficientCapacity
+	}
+	return l, 0
+}
+
+// addPending assigns random connection ID to peer data connection and
+// adds it to allocation and to connections that wait for ConnectionBind
+// request. Returns false if allocation or server is closed.
+func (s *Server) addPending(c *peerConnection) bool {
+	b := make([]byte, connectionIDSize)
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	select {
+	case <-s.closed:
+		return false
+	default:
+	}
+	for {
+		if _, err := rand.Read(b); err != nil {
+			return false
+		}
+		c.id = ConnectionID(bin.Uint32(b))
+		if _, exists := s.pending[c.id]; !exists && !c.alloc.hasPeer(c.id) {
+			break
+		}
+	}
+	if !c.alloc.addPeer(c) {
+		return false
+	}
+	s.pending[c.id] = c
+	return true
+}
+
+// takePending removes peer data connection with id from pending ones
+// and returns it if its allocation exists and was created by user, or
+// error code.
+func (s *Server) takePending(id ConnectionID, username string) (*peerConnection, stun.ErrorCode) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	c, ok := s.pending[id]
+	if !ok || s.allocs[c.alloc.tuple.key()] != c.alloc {
+		return nil, stun.CodeBadRequest
+	}
+	if c.alloc.username != username {
+		return nil, stun.CodeWrongCredentials
+	}
+	delete(s.pending, id)
+	return c, 0
+}
+
+// acceptUntilClosed accepts peer connections to TCP allocation until
+// allocation is closed.
+//
+// RFC 6062 Section 5.3
+func (s *Server) acceptUntilClosed(a *serverAllocation) {
+	for {
+		conn, err := a.listener.AcceptTCP()
+		if err != nil {
+			if ne, ok := err.(net.Error); ok && ne.Temporary() {
+				continue
+			}
+			return
+		}
+		s.handlePeerConnection(a, conn)
+	}
+}
+
+// handlePeerConnection sends ConnectionAttempt indication to client for
+// peer connection if there is permission for peer, otherwise closes it.
+//
+// RFC 6062 Section 5.3
+func (s *Server) handlePeerConnection(a *serverAllocation, conn *net.TCPConn) {
+	now := s.now()
+	peer, err := peerAddr(conn.RemoteAddr())
+	if err != nil || !s.acl.Allowed(peer.IP) || !a.perms.permitted(peer.IP, now) {
+		_ = conn.Close()
+		return
+	}
+	c := &peerConnection{
+		alloc:     a,
+		conn:      conn,
+		peer:      peer,
+		expiresAt: now.Add(connectionBindTimeout),
+	}
+	if !s.addPending(c) {
+		_ = conn.Close()
+		return
+	}
+	m, err := stun.Build(stun.TransactionID, ConnectionAttemptIndication,
+		c.id, PeerAddress(peer),
+	)
+	if err != nil {
+		// Connection will be closed after bind timeout.
+		return
+	}
+	a.writeToClient(m.Raw)
+}
+
+// connect handles Connect request, establishing connection with peer in
+// background.
+//
+// RFC 6062 Section 5.2
+func (s *Server) connect(r *request) {
+	a := s.requestAllocation(r)
+	if a == nil {
+		return
+	}
+	var peer PeerAddress
+	if err := peer.GetFrom(r.m); err != nil || a.listener == nil {
+		s.fail(r, stun.CodeBadRequest)
+		return
+	}
+	if !checkPeerFamily(a, peer.IP) {
+		s.fail(r, stun.CodePeerAddrFamilyMismatch)
+		return
+	}
+	if !s.acl.Allowed(peer.IP) || !a.perms.permitted(peer.IP, s.now()) {
+		s.fail(r, stun.CodeForbidden)
+		return
+	}
+	if a.connected(Addr(peer)) {
+		s.fail(r, stun.CodeConnAlreadyExists)
+		return
+	}
+	// Message is valid only during handling, so it is copied for
+	// response.
+	dial := *r
+	dial.m = new(stun.Message)
+	if err := r.m.CloneTo(dial.m); err != nil {
+		s.fail(r, stun.CodeServerError)
+		return
+	}
+	s.mux.Lock()
+	select {
+	case <-s.closed:
+		s.mux.Unlock()
+		return
+	default:
+	}
+	s.wg.Add(1)
+	s.mux.Unlock()
+	go func() {
+		defer s.wg.Done()
+		s.dialPeer(&dial, a, Addr(peer))
+	}()
+}
+
+// dialPeer establishes connection from relayed transport address to
+// peer and responds to Connect request with its connection ID.
+//
+// Relayed transport address is shared with listener of allocation by
+// SO_REUSEPORT. If it is not supported, connection is established from
+// another port of relayed transport address IP.
+//
+// RFC 6062 Section 5.2
+func (s *Server) dialPeer(r *request, a *serverAllocation, peer Addr) {
+	d := net.Dialer{
+		Timeout:   connectTimeout,
+		LocalAddr: &net.TCPAddr{IP: a.relayed.IP},
+	}
+	if supportsReusePort {
+		d.LocalAddr = &net.TCPAddr{IP: a.relayed.IP, Port: a.relayed.Port}
+		d.Control = func(network, address string, c syscall.RawConn) error {
+			return setReusePort(c)
+		}
+	}
+	conn, err := d.DialContext(s.ctx, "tcp", (&net.TCPAddr{IP: peer.IP, Port: peer.Port}).String())
+	if err != nil {
+		s.fail(r, stun.CodeConnTimeoutOrFailure)
+		return
+	}
+	c := &peerConnection{
+		alloc:     a,
+		conn:      conn,
+		peer:      peer,
+		expiresAt: s.now().Add(connectionBindTimeout),
+	}
+	if !s.addPending(c) {
+		_ = conn.Close()
+		s.fail(r, stun.CodeConnTimeoutOrFailure)
+		return
+	}
+	s.success(r, c.id)
+}
+
+// connectionBind handles ConnectionBind request on new client data
+// connection, relaying data between it and peer data connection until
+// one of them is closed.
+//
+// RFC 6062 Section 5.4
+func (s *Server) connectionBind(r *request) {
+	stream, ok := r.conn.(*StreamConn)
+	if !ok || s.allocation(r.tuple) != nil {
+		// Request must be sent on new TCP or TLS connection.
+		s.fail(r, stun.CodeBadRequest)
+		return
+	}
+	var id ConnectionID
+	if err := id.GetFrom(r.m); err != nil {
+		s.fail(r, stun.CodeBadRequest)
+		return
+	}
+	c, code := s.takePending(id, r.username)
+	if code != 0 {
+		s.fail(r, code)
+		return
+	}
+	s.success(r)
+	// Connection carries raw data after response.
+	s.splice(c.alloc, stream.detach(), c.conn)
+	c.alloc.removePeer(c)
+}
+
+// limitedReader reads from connection at rate of bucket. Reads are
+// delayed instead of dropping data, so TCP flow control slows down
+// sender.
+type limitedReader struct {
+	conn   net.Conn
+	bucket *tokenBucket
+	now    func() time.Time
+	// done aborts waiting for tokens.
+	done <-chan struct{}
+}
+
+func (r *limitedReader) Read(b []byte) (int, error) {
+	if burst := int(r.bucket.burst); len(b) > burst {
+		b = b[:burst]
+	}
+	n, err := r.conn.Read(b)
+	if n == 0 {
+		return n, err
+	}
+	if wait := r.bucket.reserve(n, r.now()); wait > 0 {
+		timer := time.NewTimer(wait)
+		select {
+		case <-timer.C:
+		case <-r.done:
+			timer.Stop()
+			return 0, io.EOF
+		}
+	}
+	return n, err
+}
+
+// splice copies data between client and peer data connections of
+// allocation in both directions until one of them is closed, closing
+// both after that. Data is relayed at rate of allocation bandwidth
+// limits.
+func (s *Server) splice(a *serverAllocation, client, peer net.Conn) {
+	done := make(chan struct{})
+	finished := make(chan struct{}, 2)
+	cp := func(dst, src net.Conn, bucket *tokenBucket) {
+		var r io.Reader = src
+		if bucket != nil {
+			r = &limitedReader{conn: src, bucket: bucket, now: s.now, done: done}
+		}
+		_, _ = io.Copy(dst, r)
+		finished <- struct{}{}
+	}
+	go cp(peer, client, a.toPeer)
+	go cp(client, peer, a.toClient)
+	<-finished
+	close(done)
+	_ = client.Close()
+	_ = peer.Close()
+	<-finished
+}
@@ -0,0 +1,408 @@
+package turn
+
+import (
+	"crypto/tls"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"gortc.io/stun"
+)
+
+// streamTestServer is Server that handles TCP connections.
+type streamTestServer struct {
+	*Server
+	t    testing.TB
+	addr *net.TCPAddr
+	done chan error
+}
+
+func newStreamTestServer(t testing.TB, o ServerOptions) *streamTestServer {
+	if o.PeerACL == nil {
+		o.PeerACL = testPeerACL
+	}
+	s, err := NewServer(o)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := &streamTestServer{
+		Server: s,
+		t:      t,
+		addr:   l.Addr().(*net.TCPAddr),
+		done:   make(chan error, 1),
+	}
+	go func() {
+		ts.done <- s.ServeListener(l)
+	}()
+	return ts
+}
+
+func (s *streamTestServer) Close() {
+	if err := s.Server.Close(); err != nil {
+		s.t.Error(err)
+	}
+	if err := <-s.done; err != ErrServerClosed {
+		s.t.Errorf("unexpected serve error %v", err)
+	}
+}
+
+func (s *streamTestServer) dial(username string) *Client {
+	c, err := Dial(URI{
+		Scheme:    Scheme,
+		Host:      "127.0.0.1",
+		Port:      s.addr.Port,
+		Transport: TransportTCP,
+	}, nil, ClientOptions{Username: username, Password: "secret"})
+	if err != nil {
+		s.t.Fatal(err)
+	}
+	return c
+}
+
+// listenEchoPeer returns TCP listener of peer that echoes data.
+func listenEchoPeer(t testing.TB) net.Listener {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for {
+			conn, acceptErr := l.Accept()
+			if acceptErr != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				_, _ = io.Copy(conn, conn)
+			}()
+		}
+	}()
+	return l
+}
+
+func expectResponseCode(t testing.TB, err error, expected stun.ErrorCode) {
+	t.Helper()
+	if e, ok := err.(*ResponseError); !ok || e.Code.Code != expected {
+		t.Errorf("unexpected error %v, expected %d", err, expected)
+	}
+}
+
+// expectClosed checks that conn is closed by remote side.
+func expectClosed(t testing.TB, conn net.Conn) {
+	t.Helper()
+	if err := conn.SetReadDeadline(time.Now().Add(time.Second * 5)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := conn.Read(make([]byte, 1)); err == nil {
+		t.Error("connection should be closed")
+	} else if ne, ok := err.(net.Error); ok && ne.Timeout() {
+		t.Error("connection is not closed")
+	}
+}
+
+func TestServer_TCPAllocation(t *testing.T) {
+	s := newStreamTestServer(t, ServerOptions{
+		Auth:  StaticAuth{"user": "secret", "other": "secret", "limited": "secret"},
+		Realm: "realm",
+		UserBandwidth: map[string]BandwidthLimit{
+			"limited": {BytesPerSecond: 64 * 1024, Burst: 8 * 1024},
+		},
+	})
+	defer s.Close()
+	peerListener := listenEchoPeer(t)
+	defer peerListener.Close()
+	peer := peerListener.Addr().(*net.TCPAddr)
+	c := s.dial("user")
+	defer c.Close()
+	a, err := c.AllocateTCP()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !a.Relayed().IP.Equal(peer.IP) || a.Relayed().Port < DefaultPortRange.Min {
+		t.Errorf("unexpected relayed address %s", a.Relayed())
+	}
+	if err = a.CreatePermission(peer); err != nil {
+		t.Fatal(err)
+	}
+	t.Run("Dial", func(t *testing.T) {
+		conn, dialErr := a.Dial(peer)
+		if dialErr != nil {
+			t.Fatal(dialErr)
+		}
+		defer conn.Close()
+		testEcho(t, conn)
+		_, dialErr = a.Dial(peer)
+		expectResponseCode(t, dialErr, stun.CodeConnAlreadyExists)
+	})
+	t.Run("RelayedAddress", func(t *testing.T) {
+		if !supportsReusePort {
+			t.Skip("SO_REUSEPORT is not supported")
+		}
+		l, listenErr := net.Listen("tcp", "127.0.0.1:0")
+		if listenErr != nil {
+			t.Fatal(listenErr)
+		}
+		defer l.Close()
+		accepted := make(chan net.Conn, 1)
+		go func() {
+			conn, acceptErr := l.Accept()
+			if acceptErr != nil {
+				close(accepted)
+				return
+			}
+			accepted <- conn
+		}()
+		conn, dialErr := a.Dial(l.Addr())
+		if dialErr != nil {
+			t.Fatal(dialErr)
+		}
+		defer conn.Close()
+		peerConn, ok := <-accepted
+		if !ok {
+			t.Fatal("not accepted")
+		}
+		defer peerConn.Close()
+		// Peer sees the same address as client in XOR-RELAYED-ADDRESS.
+		if peerConn.RemoteAddr().String() != a.Addr().String() {
+			t.Errorf("peer connection from %s, expected %s", peerConn.RemoteAddr(), a.Addr())
+		}
+	})
+	t.Run("Forbidden", func(t *testing.T) {
+		_, dialErr := a.Dial(&net.TCPAddr{IP: net.IPv4(127, 0, 0, 2), Port: peer.Port})
+		expectResponseCode(t, dialErr, stun.CodeForbidden)
+	})
+	t.Run("Failure", func(t *testing.T) {
+		l, listenErr := net.Listen("tcp", "127.0.0.1:0")
+		if listenErr != nil {
+			t.Fatal(listenErr)
+		}
+		if listenErr = l.Close(); listenErr != nil {
+			t.Fatal(listenErr)
+		}
+		_, dialErr := a.Dial(l.Addr())
+		expectResponseCode(t, dialErr, stun.CodeConnTimeoutOrFailure)
+	})
+	t.Run("Accept", func(t *testing.T) {
+		conn, dialErr := net.Dial("tcp", a.Addr().String())
+		if dialErr != nil {
+			t.Fatal(dialErr)
+		}
+		defer conn.Close()
+		accepted, acceptErr := a.Accept()
+		if acceptErr != nil {
+			t.Fatal(acceptErr)
+		}
+		defer accepted.Close()
+		if accepted.RemoteAddr().String() != conn.LocalAddr().String() {
+			t.Errorf("unexpected remote addr %s", accepted.RemoteAddr())
+		}
+		// Echoing data as client.
+		go func() {
+			_, _ = io.Copy(accepted, accepted)
+		}()
+		testEcho(t, conn)
+	})
+	t.Run("AcceptForbidden", func(t *testing.T) {
+		d := net.Dialer{LocalAddr: &net.TCPAddr{IP: net.IPv4(127, 0, 0, 2)}}
+		conn, dialErr := d.Dial("tcp", a.Addr().String())
+		if dialErr != nil {
+			t.Fatal(dialErr)
+		}
+		defer conn.Close()
+		expectClosed(t, conn)
+	})
+	t.Run("Bind", func(t *testing.T) {
+		// Each test uses new peer, because previous connections with peer
+		// can be closed asynchronously.
+		connect := func(t *testing.T) (ConnectionID, Addr, func()) {
+			l := listenEchoPeer(t)
+			addr, connectErr := peerAddr(l.Addr())
+			if connectErr != nil {
+				t.Fatal(connectErr)
+			}
+			res, connectErr := c.request(ConnectRequest, PeerAddress(addr))
+			if connectErr != nil {
+				t.Fatal(connectErr)
+			}
+			var id ConnectionID
+			if connectErr = id.GetFrom(res); connectErr != nil {
+				t.Fatal(connectErr)
+			}
+			return id, addr, func() { _ = l.Close() }
+		}
+		t.Run("ControlConnection", func(t *testing.T) {
+			_, bindErr := c.request(ConnectionBindRequest, ConnectionID(1))
+			expectResponseCode(t, bindErr, stun.CodeBadRequest)
+		})
+		t.Run("UnknownID", func(t *testing.T) {
+			_, bindErr := a.bind(1, Addr{IP: peer.IP, Port: peer.Port})
+			expectResponseCode(t, bindErr, stun.CodeBadRequest)
+		})
+		t.Run("WrongCredentials", func(t *testing.T) {
+			id, addr, closePeer := connect(t)
+			defer closePeer()
+			other := s.dial("other")
+			defer other.Close()
+			otherAlloc, allocErr := other.AllocateTCP()
+			if allocErr != nil {
+				t.Fatal(allocErr)
+			}
+			defer otherAlloc.Close()
+			_, bindErr := otherAlloc.bind(id, addr)
+			expectResponseCode(t, bindErr, stun.CodeWrongCredentials)
+			// Connection still can be bound by the user.
+			conn, bindErr := a.bind(id, addr)
+			if bindErr != nil {
+				t.Fatal(bindErr)
+			}
+			defer conn.Close()
+			testEcho(t, conn)
+		})
+		t.Run("Timeout", func(t *testing.T) {
+			id, addr, closePeer := connect(t)
+			defer closePeer()
+			s.collect(time.Now().Add(connectionBindTimeout))
+			_, bindErr := a.bind(id, addr)
+			expectResponseCode(t, bindErr, stun.CodeBadRequest)
+			// Peer connection is closed, so it can be established again.
+			conn, dialErr := a.Dial(&net.TCPAddr{IP: addr.IP, Port: addr.Port})
+			if dialErr != nil {
+				t.Fatal(dialErr)
+			}
+			defer conn.Close()
+			testEcho(t, conn)
+		})
+	})
+	t.Run("Bandwidth", func(t *testing.T) {
+		limited := s.dial("limited")
+		defer limited.Close()
+		limitedAlloc, allocErr := limited.AllocateTCP()
+		if allocErr != nil {
+			t.Fatal(allocErr)
+		}
+		defer limitedAlloc.Close()
+		if err = limitedAlloc.CreatePermission(peer); err != nil {
+			t.Fatal(err)
+		}
+		conn, dialErr := limitedAlloc.Dial(peer)
+		if dialErr != nil {
+			t.Fatal(dialErr)
+		}
+		defer conn.Close()
+		// Data is relayed to peer and back with 8K burst and 64K/s
+		// rate, so 32K takes at least 375ms.
+		data := make([]byte, 32*1024)
+		start := time.Now()
+		go func() {
+			_, _ = conn.Write(data)
+		}()
+		if err = conn.SetReadDeadline(time.Now().Add(time.Second * 5)); err != nil {
+			t.Fatal(err)
+		}
+		if _, err = io.ReadFull(conn, make([]byte, len(data))); err != nil {
+			t.Fatal(err)
+		}
+		if elapsed := time.Since(start); elapsed < time.Millisecond*350 {
+			t.Errorf("data relayed in %s, faster than limit", elapsed)
+		}
+		// Data is delayed instead of dropping.
+		if drops := s.Stats().Dropped; drops.Bytes != 0 {
+			t.Errorf("unexpected drops %+v", drops)
+		}
+	})
+	t.Run("Close", func(t *testing.T) {
+		otherPeer := listenEchoPeer(t)
+		defer otherPeer.Close()
+		conn, dialErr := a.Dial(otherPeer.Addr())
+		if dialErr != nil {
+			t.Fatal(dialErr)
+		}
+		defer conn.Close()
+		if err = a.Close(); err != nil {
+			t.Fatal(err)
+		}
+		// Peer data connections are closed with allocation.
+		expectClosed(t, conn)
+		waitAllocations(t, s.Server, 0)
+	})
+}
+
+func TestServer_TCPAllocationTLS(t *testing.T) {
+	cert, roots := testHostCertificate(t, "localhost")
+	s, err := NewServer(ServerOptions{PeerACL: testPeerACL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan error, 1)
+	go func() {
+		done <- s.ServeTLS(l, &tls.Config{Certificates: []tls.Certificate{cert}})
+	}()
+	defer func() {
+		if err = s.Close(); err != nil {
+			t.Error(err)
+		}
+		if err = <-done; err != ErrServerClosed {
+			t.Errorf("unexpected serve error %v", err)
+		}
+	}()
+	// Server name is not set, so it should be taken from URI for both
+	// control and data connections.
+	c, err := Dial(URI{
+		Scheme: SchemeSecure,
+		Host:   "localhost",
+		Port:   l.Addr().(*net.TCPAddr).Port,
+	}, &tls.Config{RootCAs: roots}, ClientOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	a, err := c.AllocateTCP()
+	if err != nil {
+		t.Fatal(err)
+	}
+	peerListener := listenEchoPeer(t)
+	defer peerListener.Close()
+	if err = a.CreatePermission(peerListener.Addr()); err != nil {
+		t.Fatal(err)
+	}
+	conn, err := a.Dial(peerListener.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	testEcho(t, conn)
+}
+
+func TestServer_TCPAllocationRequests(t *testing.T) {
+	s := newStreamTestServer(t, ServerOptions{})
+	defer s.Close()
+	c := s.dial("")
+	defer c.Close()
+	t.Run("EvenPort", func(t *testing.T) {
+		_, err := c.request(AllocateRequest, RequestedTransportTCP, EvenPort{})
+		expectResponseCode(t, err, stun.CodeBadRequest)
+	})
+	t.Run("UDP", func(t *testing.T) {
+		a, err := c.Allocate()
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer a.Close()
+		peer := &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 1}
+		if err = a.CreatePermission(peer); err != nil {
+			t.Fatal(err)
+		}
+		// Connect is only allowed for TCP allocations.
+		_, err = c.request(ConnectRequest, PeerAddress{IP: peer.IP, Port: peer.Port})
+		expectResponseCode(t, err, stun.CodeBadRequest)
+	})
+}
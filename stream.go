@@ -0,0 +1,205 @@
+package turn
+
+import (
+	"bytes"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// stunHeaderSize is size of STUN message header.
+const stunHeaderSize = 20
+
+// StreamConn implements net.PacketConn over stream-oriented connection
+// like TCP or TLS, framing STUN messages and ChannelData messages.
+//
+// ChannelData messages are padded to multiple of four bytes on write
+// as required for stream transports by RFC 5766 Section 11.5.
+type StreamConn struct {
+	conn net.Conn
+
+	readMux sync.Mutex
+	r       *FrameReader
+
+	writeMux sync.Mutex
+	buf      []byte
+}
+
+// NewStreamConn wraps stream-oriented connection to TURN server.
+func NewStreamConn(conn net.Conn) *StreamConn {
+	return &StreamConn{
+		conn: conn,
+		r:    NewFrameReader(conn, DefaultFrameBufferSize),
+	}
+}
+
+var (
+	// ErrFrameTooBig means that frame length exceeds read buffer.
+	ErrFrameTooBig = errors.New("frame is too big for buffer")
+	// ErrInvalidFrame means that frame is neither STUN message
+	// nor ChannelData message.
+	ErrInvalidFrame = errors.New("invalid frame")
+)
+
+// ReadFrom reads single STUN or ChannelData message from stream. Returned
+// address is always the remote address of underlying connection.
+//
+// Implements net.PacketConn.
+func (c *StreamConn) ReadFrom(b []byte) (int, net.Addr, error) {
+	c.readMux.Lock()
+	defer c.readMux.Unlock()
+	if _, err := c.r.Next(); err != nil {
+		return 0, nil, err
+	}
+	frame := c.r.Bytes()
+	if len(frame) > len(b) {
+		return 0, nil, ErrFrameTooBig
+	}
+	return copy(b, frame), c.conn.RemoteAddr(), nil
+}
+
+// WriteTo writes STUN or ChannelData message to stream, ignoring addr.
+//
+// Implements net.PacketConn.
+func (c *StreamConn) WriteTo(b []byte, addr net.Addr) (int, error) {
+	// Writes are serialized, so concurrent messages are not interleaved
+	// if underlying connection writes them partially.
+	c.writeMux.Lock()
+	defer c.writeMux.Unlock()
+	if !IsChannelData(b) {
+		return c.conn.Write(b)
+	}
+	c.buf = append(c.buf[:0], b...)
+	for len(c.buf)%padding != 0 {
+		c.buf = append(c.buf, 0)
+	}
+	if _, err := c.conn.Write(c.buf); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+// detach returns underlying connection that reads data which was read
+// ahead by framing first, so it can be used for raw data after STUN
+// messages.
+func (c *StreamConn) detach() net.Conn {
+	c.readMux.Lock()
+	defer c.readMux.Unlock()
+	buffered := append([]byte(nil), c.r.buffered()...)
+	return &detachedConn{
+		Conn: c.conn,
+		r:    io.MultiReader(bytes.NewReader(buffered), c.conn),
+	}
+}
+
+// detachedConn is connection that was detached from StreamConn.
+type detachedConn struct {
+	net.Conn
+	r io.Reader
+}
+
+func (c *detachedConn) Read(b []byte) (int, error) { return c.r.Read(b) }
+
+// Close closes underlying connection.
+func (c *StreamConn) Close() error { return c.conn.Close() }
+
+// LocalAddr returns local address of underlying connection.
+func (c *StreamConn) LocalAddr() net.Addr { return c.conn.LocalAddr() }
+
+// RemoteAddr returns remote address of underlying connection.
+func (c *StreamConn) RemoteAddr() net.Addr { return c.conn.RemoteAddr() }
+
+// SetDeadline sets deadline on underlying connection.
+func (c *StreamConn) SetDeadline(t time.Time) error { return c.conn.SetDeadline(t) }
+
+// SetReadDeadline sets read deadline on underlying connection.
+func (c *StreamConn) SetReadDeadline(t time.Time) error { return c.conn.SetReadDeadline(t) }
+
+// SetWriteDeadline sets write deadline on underlying connection.
+func (c *StreamConn) SetWriteDeadline(t time.Time) error { return c.conn.SetWriteDeadline(t) }
+
+// Dial connects to TURN server from URI and returns client for it.
+//
+// The "turn" scheme uses UDP unless TCP transport is specified, while
+// "turns" scheme uses TLS over TCP with tlsConfig that can be nil.
+// Conn and Server fields of options are set by Dial, as well as DialData
+// for TCP and TLS transports if it is not provided.
+//
+// RFC 7065 Section 3
+func Dial(uri URI, tlsConfig *tls.Config, o ClientOptions) (*Client, error) {
+	port := uri.Port
+	if port == 0 {
+		port = DefaultPort
+		if uri.Scheme == SchemeSecure {
+			port = DefaultTLSPort
+		}
+	}
+	addr := net.JoinHostPort(uri.Host, strconv.Itoa(port))
+	switch {
+	case uri.Scheme == Scheme && (uri.Transport == "" || uri.Transport == TransportUDP):
+		raddr, err := net.ResolveUDPAddr("udp", addr)
+		if err != nil {
+			return nil, err
+		}
+		conn, err := net.ListenUDP("udp", nil)
+		if err != nil {
+			return nil, err
+		}
+		o.Conn, o.Server = conn, raddr
+	case uri.Scheme == Scheme && uri.Transport == TransportTCP:
+		conn, err := net.Dial("tcp", addr)
+		if err != nil {
+			return nil, err
+		}
+		o.Conn, o.Server = NewStreamConn(conn), conn.RemoteAddr()
+		if o.DialData == nil {
+			o.DialData = func() (net.Conn, error) {
+				return net.Dial("tcp", o.Server.String())
+			}
+		}
+	case uri.Scheme == SchemeSecure && (uri.Transport == "" || uri.Transport == TransportTCP):
+		if tlsConfig == nil {
+			tlsConfig = &tls.Config{ServerName: uri.Host}
+		} else if tlsConfig.ServerName == "" {
+			// Data connections are established to resolved address, so
+			// host name is required to verify certificate.
+			tlsConfig = tlsConfig.Clone()
+			tlsConfig.ServerName = uri.Host
+		}
+		conn, err := tls.Dial("tcp", addr, tlsConfig)
+		if err != nil {
+			return nil, err
+		}
+		o.Conn, o.Server = NewStreamConn(conn), conn.RemoteAddr()
+		if o.DialData == nil {
+			o.DialData = func() (net.Conn, error) {
+				return tls.Dial("tcp", o.Server.String(), tlsConfig)
+			}
+		}
+	default:
+		return nil, fmt.Errorf("unsupported transport %q for scheme %q", uri.Transport, uri.Scheme)
+	}
+	c, err := NewClient(o)
+	if err != nil {
+		_ = o.Conn.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+// reliableTimeout is transaction timeout over reliable transports.
+//
+// RFC 5389 Section 7.2.2
+const reliableTimeout = time.Millisecond * 39500
+
+// isReliable returns true if conn is reliable transport, so
+// retransmissions should not be performed.
+func isReliable(conn net.PacketConn) bool {
+	_, ok := conn.(*StreamConn)
+	return ok
+}
@@ -0,0 +1,298 @@
+package turn
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"runtime"
+	"sync"
+	"testing"
+	"time"
+
+	"gortc.io/stun"
+)
+
+func TestStreamConn(t *testing.T) {
+	a, b := net.Pipe()
+	var (
+		client = NewStreamConn(a)
+		server = NewStreamConn(b)
+	)
+	defer client.Close()
+	defer server.Close()
+	m := stun.MustBuild(stun.TransactionID, AllocateRequest, RequestedTransportUDP)
+	d := &ChannelData{Number: MinChannelNumber, Data: []byte{1, 2, 3, 4, 5}}
+	d.Encode()
+	go func() {
+		if _, err := client.WriteTo(d.Raw, nil); err != nil {
+			t.Error(err)
+		}
+		if _, err := client.WriteTo(m.Raw, nil); err != nil {
+			t.Error(err)
+		}
+		if _, err := client.WriteTo([]byte{0xff, 0xff, 0xff, 0xff}, nil); err != nil {
+			t.Error(err)
+		}
+	}()
+	buf := make([]byte, 1024)
+	n, addr, err := server.ReadFrom(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 12 {
+		t.Errorf("ChannelData should be padded to 12 bytes, got %d", n)
+	}
+	if addr != b.RemoteAddr() {
+		t.Errorf("unexpected addr %s", addr)
+	}
+	decoded := &ChannelData{Raw: buf[:n]}
+	if err = decoded.Decode(); err != nil {
+		t.Fatal(err)
+	}
+	if !decoded.Equal(d) {
+		t.Error("not equal")
+	}
+	if n, _, err = server.ReadFrom(buf); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf[:n], m.Raw) {
+		t.Error("message not equal")
+	}
+	if _, _, err = server.ReadFrom(buf); err != ErrInvalidFrame {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if client.LocalAddr() != a.LocalAddr() || client.RemoteAddr() != a.RemoteAddr() {
+		t.Error("unexpected addresses")
+	}
+	if err = client.SetDeadline(time.Now().Add(time.Second)); err != nil {
+		t.Error(err)
+	}
+	if err = client.SetReadDeadline(time.Time{}); err != nil {
+		t.Error(err)
+	}
+	if err = client.SetWriteDeadline(time.Time{}); err != nil {
+		t.Error(err)
+	}
+}
+
+// byteConn records data that is written one byte at a time.
+type byteConn struct {
+	net.Conn
+
+	mux sync.Mutex
+	buf bytes.Buffer
+}
+
+func (c *byteConn) Write(b []byte) (int, error) {
+	for i := range b {
+		c.mux.Lock()
+		c.buf.WriteByte(b[i])
+		c.mux.Unlock()
+		runtime.Gosched()
+	}
+	return len(b), nil
+}
+
+func TestStreamConn_WriteTo(t *testing.T) {
+	conn := new(byteConn)
+	c := NewStreamConn(conn)
+	const writes = 10
+	m := stun.MustBuild(stun.TransactionID, RefreshRequest, Lifetime{time.Minute})
+	d := &ChannelData{Number: MinChannelNumber, Data: []byte{1, 2, 3}}
+	d.Encode()
+	var wg sync.WaitGroup
+	for i := 0; i < writes; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			if _, err := c.WriteTo(m.Raw, nil); err != nil {
+				t.Error(err)
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			if _, err := c.WriteTo(d.Raw, nil); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	r := NewFrameReader(&conn.buf, 0)
+	for i := 0; i < 2*writes; i++ {
+		if _, err := r.Next(); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(r.Bytes(), m.Raw) && !bytes.Equal(r.Bytes(), append(d.Raw, 0)) {
+			t.Fatalf("unexpected frame %x", r.Bytes())
+		}
+	}
+}
+
+func TestStreamConn_ReadFrom(t *testing.T) {
+	t.Run("TooBig", func(t *testing.T) {
+		a, b := net.Pipe()
+		defer a.Close()
+		m := stun.MustBuild(stun.TransactionID, AllocateRequest, RequestedTransportUDP)
+		go a.Write(m.Raw)
+		if _, _, err := NewStreamConn(b).ReadFrom(make([]byte, 10)); err != ErrFrameTooBig {
+			t.Errorf("unexpected error: %v", err)
+		}
+	})
+	t.Run("EOF", func(t *testing.T) {
+		a, b := net.Pipe()
+		go func() {
+			a.Write([]byte{0x40, 0x00})
+			a.Close()
+		}()
+		if _, _, err := NewStreamConn(b).ReadFrom(make([]byte, 10)); err != io.ErrUnexpectedEOF {
+			t.Errorf("unexpected error: %v", err)
+		}
+	})
+}
+
+func TestStreamConn_detach(t *testing.T) {
+	a, b := net.Pipe()
+	defer a.Close()
+	m := stun.MustBuild(stun.TransactionID, ConnectionBindRequest, ConnectionID(1))
+	go func() {
+		// Raw data is written along with message, so it is read ahead.
+		if _, err := a.Write(append(m.Raw, "raw"...)); err != nil {
+			t.Error(err)
+		}
+		if _, err := a.Write([]byte("data")); err != nil {
+			t.Error(err)
+		}
+	}()
+	c := NewStreamConn(b)
+	buf := make([]byte, 1024)
+	n, _, err := c.ReadFrom(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf[:n], m.Raw) {
+		t.Error("message not equal")
+	}
+	conn := c.detach()
+	defer conn.Close()
+	if conn.RemoteAddr() != b.RemoteAddr() {
+		t.Errorf("unexpected addr %s", conn.RemoteAddr())
+	}
+	data := make([]byte, 7)
+	if _, err = io.ReadFull(conn, data); err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "rawdata" {
+		t.Errorf("unexpected data %q", data)
+	}
+}
+
+// serveStream handles STUN messages on stream connection using handler
+// until connection is closed.
+func serveStream(t testing.TB, conn net.Conn, handler func(req *stun.Message, addr net.Addr) *stun.Message) {
+	c := NewStreamConn(conn)
+	defer c.Close()
+	buf := make([]byte, 2048)
+	for {
+		n, addr, err := c.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		if !stun.IsMessage(buf[:n]) {
+			continue
+		}
+		req := &stun.Message{Raw: append([]byte(nil), buf[:n]...)}
+		if err = req.Decode(); err != nil {
+			t.Error(err)
+			return
+		}
+		if res := handler(req, addr); res != nil {
+			if _, err = c.WriteTo(res.Raw, addr); err != nil {
+				return
+			}
+		}
+	}
+}
+
+func TestDial(t *testing.T) {
+	t.Run("TCP", func(t *testing.T) {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer l.Close()
+		go func() {
+			conn, acceptErr := l.Accept()
+			if acceptErr != nil {
+				return
+			}
+			serveStream(t, conn, func(req *stun.Message, addr net.Addr) *stun.Message {
+				if req.Type != AllocateRequest {
+					return allocateHandler(t, req, addr)
+				}
+				if res := authenticate(t, req); res != nil {
+					return res
+				}
+				return stun.MustBuild(req, stun.NewType(stun.MethodAllocate, stun.ClassSuccessResponse),
+					RelayedAddress{IP: net.IPv4(10, 0, 0, 1), Port: 5000},
+					Lifetime{time.Minute * 5},
+					testIntegrity, stun.Fingerprint,
+				)
+			})
+		}()
+		tcpAddr := l.Addr().(*net.TCPAddr)
+		c, err := Dial(URI{
+			Scheme:    Scheme,
+			Host:      "127.0.0.1",
+			Port:      tcpAddr.Port,
+			Transport: TransportTCP,
+		}, nil, ClientOptions{Username: "user", Password: "secret"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer c.Close()
+		if !isReliable(c.conn) {
+			t.Error("stream should be reliable")
+		}
+		a, err := c.Allocate()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if a.Relayed.Port != 5000 {
+			t.Errorf("unexpected relayed address %s", a.Relayed)
+		}
+		if err = a.Close(); err != nil {
+			t.Error(err)
+		}
+	})
+	t.Run("UDP", func(t *testing.T) {
+		s := newFakeServer(t, func(req *stun.Message, addr net.Addr) *stun.Message {
+			return allocateHandler(t, req, addr)
+		})
+		defer s.Close()
+		udpAddr := s.conn.LocalAddr().(*net.UDPAddr)
+		c, err := Dial(URI{
+			Scheme: Scheme,
+			Host:   "127.0.0.1",
+			Port:   udpAddr.Port,
+		}, nil, ClientOptions{Username: "user", Password: "secret"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer c.Close()
+		if isReliable(c.conn) {
+			t.Error("udp should not be reliable")
+		}
+		if _, err = c.Allocate(); err != nil {
+			t.Fatal(err)
+		}
+	})
+	t.Run("Unsupported", func(t *testing.T) {
+		if _, err := Dial(URI{
+			Scheme:    SchemeSecure,
+			Host:      "127.0.0.1",
+			Transport: TransportUDP,
+		}, nil, ClientOptions{}); err == nil {
+			t.Error("DTLS should be unsupported")
+		}
+	})
+}
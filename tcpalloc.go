@@ -0,0 +1,204 @@
+package turn
+
+import (
+	"errors"
+	"io"
+	"net"
+	"time"
+
+	"gortc.io/stun"
+)
+
+// ErrNotStream means that operation requires TCP or TLS transport.
+var ErrNotStream = errors.New("TCP or TLS transport required")
+
+// connectionAttempt is incoming connection from peer.
+type connectionAttempt struct {
+	id   ConnectionID
+	peer Addr
+}
+
+// TCPAllocation represents TCP allocation on TURN server that was
+// created by Client, allowing to establish TCP connections with peers
+// through the server.
+//
+// TCPAllocation implements net.Listener, accepting connections from
+// peers for which permission is installed.
+//
+// RFC 6062
+type TCPAllocation struct {
+	alloc *Allocation
+}
+
+// AllocateTCP performs Allocate request with TCP requested transport.
+// Client should use TCP or TLS transport and DialData should be set.
+//
+// RFC 6062 Section 5.1
+func (c *Client) AllocateTCP() (*TCPAllocation, error) {
+	if !isReliable(c.connection()) || c.dialData == nil {
+		return nil, ErrNotStream
+	}
+	a, err := c.allocate(RequestedTransport{Protocol: ProtoTCP})
+	if err != nil {
+		return nil, err
+	}
+	return &TCPAllocation{alloc: a}, nil
+}
+
+// handleConnectionAttempt handles ConnectionAttempt indication.
+func (a *Allocation) handleConnectionAttempt(m *stun.Message) {
+	if a.attempts == nil {
+		return
+	}
+	var (
+		id   ConnectionID
+		peer PeerAddress
+	)
+	if err := m.Parse(&id, &peer); err != nil {
+		return
+	}
+	select {
+	case a.attempts <- connectionAttempt{id: id, peer: Addr(peer)}:
+	default:
+		// Server will close peer connection after timeout.
+	}
+}
+
+// Relayed returns relayed transport address.
+func (t *TCPAllocation) Relayed() RelayedAddress { return t.alloc.Relayed }
+
+// Addr returns relayed transport address as *net.TCPAddr.
+//
+// Implements net.Listener.
+func (t *TCPAllocation) Addr() net.Addr {
+	return &net.TCPAddr{IP: t.alloc.Relayed.IP, Port: t.alloc.Relayed.Port}
+}
+
+// CreatePermission installs permissions for peers, see
+// Allocation.CreatePermission.
+func (t *TCPAllocation) CreatePermission(peers ...net.Addr) error {
+	return t.alloc.CreatePermission(peers...)
+}
+
+// RemovePermission stops refreshing permissions for peers, see
+// Allocation.RemovePermission.
+func (t *TCPAllocation) RemovePermission(peers ...net.Addr) error {
+	return t.alloc.RemovePermission(peers...)
+}
+
+// Permissions returns IP addresses of installed permissions.
+func (t *TCPAllocation) Permissions() []net.IP { return t.alloc.Permissions() }
+
+// Refresh refreshes allocation, see Allocation.Refresh.
+func (t *TCPAllocation) Refresh() error { return t.alloc.Refresh() }
+
+// Close deletes allocation on server.
+//
+// Implements net.Listener.
+func (t *TCPAllocation) Close() error { return t.alloc.Close() }
+
+// Dial establishes TCP connection with peer through server. Permission
+// for peer should be installed.
+//
+// RFC 6062 Section 5.2
+func (t *TCPAllocation) Dial(peer net.Addr) (net.Conn, error) {
+	p, err := peerAddr(peer)
+	if err != nil {
+		return nil, err
+	}
+	res, err := t.alloc.client.request(ConnectRequest, PeerAddress(p))
+	if err != nil {
+		return nil, err
+	}
+	var id ConnectionID
+	if err = id.GetFrom(res); err != nil {
+		return nil, err
+	}
+	return t.bind(id, p)
+}
+
+// Accept waits for and returns the next connection from peer.
+//
+// Implements net.Listener.
+//
+// RFC 6062 Section 5.3
+func (t *TCPAllocation) Accept() (net.Conn, error) {
+	a := t.alloc
+	for {
+		select {
+		case attempt := <-a.attempts:
+			conn, err := t.bind(attempt.id, attempt.peer)
+			if e, ok := err.(*ResponseError); ok && e.Code.Code == stun.CodeConnTimeoutOrFailure {
+				// Peer connection is already closed by server.
+				continue
+			}
+			return conn, err
+		case <-a.closed:
+			return nil, ErrAllocationClosed
+		}
+	}
+}
+
+// bind opens new data connection and binds it to peer connection.
+//
+// RFC 6062 Section 5.4
+func (t *TCPAllocation) bind(id ConnectionID, peer Addr) (net.Conn, error) {
+	c := t.alloc.client
+	conn, err := c.dialData()
+	if err != nil {
+		return nil, err
+	}
+	do := func(req, res *stun.Message) error {
+		return roundTrip(conn, req, res)
+	}
+	if _, err = c.requestWith(do, ConnectionBindRequest, id); err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	return &peerConn{
+		Conn:   conn,
+		local:  t.Addr(),
+		remote: &net.TCPAddr{IP: peer.IP, Port: peer.Port},
+	}, nil
+}
+
+// roundTrip writes req to conn and reads exactly one message from it
+// to res, so conn can be used for raw data after that.
+func roundTrip(conn net.Conn, req, res *stun.Message) error {
+	if err := conn.SetDeadline(time.Now().Add(reliableTimeout)); err != nil {
+		return err
+	}
+	if _, err := conn.Write(req.Raw); err != nil {
+		return err
+	}
+	res.Raw = res.Raw[:0]
+	res.Raw = append(res.Raw, make([]byte, stunHeaderSize)...)
+	if _, err := io.ReadFull(conn, res.Raw); err != nil {
+		return err
+	}
+	length := int(bin.Uint16(res.Raw[2:4]))
+	res.Raw = append(res.Raw, make([]byte, length)...)
+	if _, err := io.ReadFull(conn, res.Raw[stunHeaderSize:]); err != nil {
+		return err
+	}
+	if err := res.Decode(); err != nil {
+		return err
+	}
+	if res.TransactionID != req.TransactionID {
+		return errors.New("unexpected transaction id")
+	}
+	return conn.SetDeadline(time.Time{})
+}
+
+// peerConn is data connection to peer.
+type peerConn struct {
+	net.Conn
+	local  net.Addr
+	remote net.Addr
+}
+
+// LocalAddr returns relayed transport address.
+func (c *peerConn) LocalAddr() net.Addr { return c.local }
+
+// RemoteAddr returns peer address.
+func (c *peerConn) RemoteAddr() net.Addr { return c.remote }
@@ -0,0 +1,248 @@
+package turn
+
+import (
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"gortc.io/stun"
+)
+
+// readMessage reads exactly one STUN message from stream.
+func readMessage(r io.Reader) (*stun.Message, error) {
+	m := &stun.Message{Raw: make([]byte, stunHeaderSize)}
+	if _, err := io.ReadFull(r, m.Raw); err != nil {
+		return nil, err
+	}
+	m.Raw = append(m.Raw, make([]byte, bin.Uint16(m.Raw[2:4]))...)
+	if _, err := io.ReadFull(r, m.Raw[stunHeaderSize:]); err != nil {
+		return nil, err
+	}
+	return m, m.Decode()
+}
+
+// tcpServer is fake TURN server for TCP allocations that echoes
+// data received on bound data connections.
+type tcpServer struct {
+	t testing.TB
+	l net.Listener
+
+	mux     sync.Mutex
+	control net.Conn
+	wg      sync.WaitGroup
+}
+
+func newTCPServer(t testing.TB) *tcpServer {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &tcpServer{t: t, l: l}
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		for {
+			conn, acceptErr := l.Accept()
+			if acceptErr != nil {
+				return
+			}
+			s.wg.Add(1)
+			go func() {
+				defer s.wg.Done()
+				s.serve(conn)
+			}()
+		}
+	}()
+	return s
+}
+
+func (s *tcpServer) write(conn net.Conn, m *stun.Message) {
+	if _, err := conn.Write(m.Raw); err != nil {
+		s.t.Error(err)
+	}
+}
+
+func (s *tcpServer) serve(conn net.Conn) {
+	defer conn.Close()
+	for {
+		req, err := readMessage(conn)
+		if err != nil {
+			return
+		}
+		if res := authenticate(s.t, req); res != nil {
+			s.write(conn, res)
+			continue
+		}
+		success := stun.NewType(req.Type.Method, stun.ClassSuccessResponse)
+		switch req.Type {
+		case AllocateRequest:
+			var transport RequestedTransport
+			if err = transport.GetFrom(req); err != nil || transport.Protocol != ProtoTCP {
+				s.t.Errorf("unexpected transport %s: %v", transport, err)
+			}
+			s.mux.Lock()
+			s.control = conn
+			s.mux.Unlock()
+			s.write(conn, stun.MustBuild(req, success,
+				RelayedAddress{IP: net.IPv4(10, 0, 0, 1), Port: 5000},
+				Lifetime{time.Minute * 5},
+				testIntegrity, stun.Fingerprint,
+			))
+		case ConnectRequest:
+			var peer PeerAddress
+			if err = peer.GetFrom(req); err != nil {
+				s.t.Error(err)
+			}
+			if peer.Port == 404 {
+				s.write(conn, stun.MustBuild(req, stun.NewType(req.Type.Method, stun.ClassErrorResponse),
+					stun.CodeConnTimeoutOrFailure,
+				))
+				continue
+			}
+			s.write(conn, stun.MustBuild(req, success,
+				ConnectionID(42), testIntegrity, stun.Fingerprint,
+			))
+		case ConnectionBindRequest:
+			var id ConnectionID
+			if err = id.GetFrom(req); err != nil {
+				s.t.Error(err)
+			}
+			if id == 404 {
+				s.write(conn, stun.MustBuild(req, stun.NewType(req.Type.Method, stun.ClassErrorResponse),
+					stun.CodeConnTimeoutOrFailure,
+				))
+				return
+			}
+			s.write(conn, stun.MustBuild(req, success, testIntegrity, stun.Fingerprint))
+			// Echoing data as peer.
+			_, _ = io.Copy(conn, conn)
+			return
+		case RefreshRequest:
+			s.write(conn, stun.MustBuild(req, success,
+				Lifetime{time.Minute * 5}, testIntegrity, stun.Fingerprint,
+			))
+		default:
+			s.write(conn, stun.MustBuild(req, success, testIntegrity, stun.Fingerprint))
+		}
+	}
+}
+
+// attempt sends ConnectionAttempt indication to client.
+func (s *tcpServer) attempt(id ConnectionID, peer PeerAddress) {
+	s.mux.Lock()
+	conn := s.control
+	s.mux.Unlock()
+	s.write(conn, stun.MustBuild(stun.TransactionID, ConnectionAttemptIndication, id, peer))
+}
+
+func (s *tcpServer) Close() {
+	if err := s.l.Close(); err != nil {
+		s.t.Error(err)
+	}
+	s.wg.Wait()
+}
+
+func (s *tcpServer) dial(t testing.TB) *Client {
+	c, err := Dial(URI{
+		Scheme:    Scheme,
+		Host:      "127.0.0.1",
+		Port:      s.l.Addr().(*net.TCPAddr).Port,
+		Transport: TransportTCP,
+	}, nil, ClientOptions{Username: "user", Password: "secret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func testEcho(t *testing.T, conn net.Conn) {
+	if _, err := conn.Write([]byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 5)
+	if _, err := io.ReadFull(conn, buf); err != nil {
+		t.Fatal(err)
+	}
+	if string(buf) != "hello" {
+		t.Errorf("unexpected data %q", buf)
+	}
+}
+
+func TestTCPAllocation(t *testing.T) {
+	s := newTCPServer(t)
+	defer s.Close()
+	c := s.dial(t)
+	defer c.Close()
+	a, err := c.AllocateTCP()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a.Addr().String() != "10.0.0.1:5000" || a.Relayed().Port != 5000 {
+		t.Errorf("unexpected addr %s", a.Addr())
+	}
+	peer := &net.TCPAddr{IP: net.IPv4(10, 0, 0, 2), Port: 80}
+	if err = a.CreatePermission(peer); err != nil {
+		t.Fatal(err)
+	}
+	if len(a.Permissions()) != 1 {
+		t.Error("permission should be installed")
+	}
+	if err = a.Refresh(); err != nil {
+		t.Fatal(err)
+	}
+	t.Run("Dial", func(t *testing.T) {
+		conn, dialErr := a.Dial(peer)
+		if dialErr != nil {
+			t.Fatal(dialErr)
+		}
+		defer conn.Close()
+		if conn.RemoteAddr().String() != peer.String() {
+			t.Errorf("unexpected remote addr %s", conn.RemoteAddr())
+		}
+		if conn.LocalAddr().String() != a.Addr().String() {
+			t.Errorf("unexpected local addr %s", conn.LocalAddr())
+		}
+		testEcho(t, conn)
+		_, dialErr = a.Dial(&net.TCPAddr{IP: peer.IP, Port: 404})
+		if e, ok := dialErr.(*ResponseError); !ok || e.Code.Code != stun.CodeConnTimeoutOrFailure {
+			t.Errorf("unexpected error %v", dialErr)
+		}
+	})
+	t.Run("Accept", func(t *testing.T) {
+		// Failed attempt should be skipped.
+		s.attempt(404, PeerAddress{IP: peer.IP, Port: 1000})
+		s.attempt(43, PeerAddress{IP: peer.IP, Port: 1001})
+		conn, acceptErr := a.Accept()
+		if acceptErr != nil {
+			t.Fatal(acceptErr)
+		}
+		defer conn.Close()
+		if conn.RemoteAddr().String() != "10.0.0.2:1001" {
+			t.Errorf("unexpected remote addr %s", conn.RemoteAddr())
+		}
+		testEcho(t, conn)
+	})
+	if err = a.RemovePermission(peer); err != nil {
+		t.Error(err)
+	}
+	if err = a.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = a.Accept(); err != ErrAllocationClosed {
+		t.Errorf("unexpected error %v", err)
+	}
+}
+
+func TestClient_AllocateTCP_NotStream(t *testing.T) {
+	s := newFakeServer(t, func(req *stun.Message, addr net.Addr) *stun.Message {
+		return allocateHandler(t, req, addr)
+	})
+	defer s.Close()
+	c := newTestClient(t, s)
+	defer c.Close()
+	if _, err := c.AllocateTCP(); err != ErrNotStream {
+		t.Errorf("unexpected error %v", err)
+	}
+}
@@ -27,4 +27,39 @@ var (
 	SendIndication = stun.NewType(stun.MethodSend, stun.ClassIndication)
 	// RefreshRequest is shorthand for refresh request message type.
 	RefreshRequest = stun.NewType(stun.MethodRefresh, stun.ClassRequest)
+	// ChannelBindRequest is shorthand for channel bind request type.
+	ChannelBindRequest = stun.NewType(stun.MethodChannelBind, stun.ClassRequest)
+	// DataIndication is shorthand for data indication message type.
+	DataIndication = stun.NewType(stun.MethodData, stun.ClassIndication)
 )
+
+// Message types from RFC 6062 Section 6.1.
+var (
+	// ConnectRequest is shorthand for connect request type.
+	ConnectRequest = stun.NewType(stun.MethodConnect, stun.ClassRequest)
+	// ConnectionBindRequest is shorthand for connection bind request type.
+	ConnectionBindRequest = stun.NewType(stun.MethodConnectionBind, stun.ClassRequest)
+	// ConnectionAttemptIndication is shorthand for connection attempt
+	// indication type.
+	ConnectionAttemptIndication = stun.NewType(stun.MethodConnectionAttempt, stun.ClassIndication)
+)
+
+// Attribute types from RFC 8656 Section 18 that are not defined in stun.
+const (
+	AttrAdditionalAddressFamily stun.AttrType = 0x8000 // ADDITIONAL-ADDRESS-FAMILY
+	AttrAddressErrorCode        stun.AttrType = 0x8001 // ADDRESS-ERROR-CODE
+	AttrICMP                    stun.AttrType = 0x8004 // ICMP
+)
+
+// Attribute types from RFC 7635 Section 6.
+const (
+	AttrAccessToken             stun.AttrType = 0x001B // ACCESS-TOKEN
+	AttrThirdPartyAuthorization stun.AttrType = 0x802E // THIRD-PARTY-AUTHORIZATION
+)
+
+// AttrMobilityTicket is MOBILITY-TICKET attribute type from RFC 8016.
+const AttrMobilityTicket stun.AttrType = 0x8030
+
+// CodeMobilityForbidden is error code for "Mobility Forbidden" from
+// RFC 8016.
+const CodeMobilityForbidden stun.ErrorCode = 405